@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
@@ -22,14 +23,14 @@ import (
 )
 
 func main() {
-	termList, err := tcb.FetchTermList()
+	termList, err := tcb.FetchTermList(context.Background())
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	_ = termList
 
-	_, err = tcb.FetchFromTerm(18077)
+	_, err = tcb.FetchFromTerm(context.Background(), 18077)
 	if err != nil {
 		fmt.Println(err)
 		return
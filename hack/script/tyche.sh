@@ -2,7 +2,14 @@
 
 tyche_start() {
     echo "Start tyche server..."
-    docker run -d -p :443:443 --restart always --name tyche -v /root/keys:/keys tyche:0.0.1 tyche -wx-appid ${WXAPPID} -wx-appsecret ${WXAPPSECRET} -wx-token ${WXTOKEN} -wx-aeskey ${WXAESKEY} -listen-client-url "https://0.0.0.0:443" -client-key-file /keys/www.soren.vip.key -client-cert-file /keys/www.soren.vip.pem
+    # pass wechat secrets by environment, so they are not visible in the command line
+    export TYCHE_WX_APPID=${WXAPPID}
+    export TYCHE_WX_APPSECRET=${WXAPPSECRET}
+    export TYCHE_WX_TOKEN=${WXTOKEN}
+    export TYCHE_WX_AESKEY=${WXAESKEY}
+    docker run -d -p :443:443 --restart always --name tyche -v /root/keys:/keys \
+        -e TYCHE_WX_APPID -e TYCHE_WX_APPSECRET -e TYCHE_WX_TOKEN -e TYCHE_WX_AESKEY \
+        tyche:0.0.1 tyche -listen-client-url "https://0.0.0.0:443" -client-key-file /keys/www.soren.vip.key -client-cert-file /keys/www.soren.vip.pem
 
     if [ $? -ne 0 ]; then
         echo "Start tyche Failed"
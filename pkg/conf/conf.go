@@ -18,40 +18,107 @@ package conf
 // Config is iploc server config instance
 type Config struct {
 	// 配置项
-	Name                   string `json:"name"`
-	DefaultListenClientURL string `json:"listenClientUrl"`
-	IsDebug                bool
-	IsPprof                bool
+	Name                   string `json:"name" yaml:"name"`
+	DefaultListenClientURL string `json:"listenClientUrl" yaml:"listenClientUrl"`
+	IsDebug                bool   `json:"debug" yaml:"debug"`
+	IsPprof                bool   `json:"pprof" yaml:"pprof"`
+	LogFormat              string `json:"logFormat" yaml:"logFormat"`
 
 	// 客户端证书
-	ClientTLSInfo TLSInfo
-	IsTLSEnable   bool
+	ClientTLSInfo TLSInfo `json:"clientTLS" yaml:"clientTLS"`
+	IsTLSEnable   bool    `json:"-" yaml:"-"`
 
-	WxAppID          string
-	WxAppSecret      string
-	WxToken          string
-	WxEncodingAESKey string
+	WxAppID          string `json:"wxAppID" yaml:"wxAppID"`
+	WxAppSecret      string `json:"wxAppSecret" yaml:"wxAppSecret"`
+	WxToken          string `json:"wxToken" yaml:"wxToken"`
+	WxEncodingAESKey string `json:"wxEncodingAESKey" yaml:"wxEncodingAESKey"`
+	WxDrawTemplateID string `json:"wxDrawTemplateID" yaml:"wxDrawTemplateID"`
+
+	// 存储
+	DataDir string `json:"dataDir" yaml:"dataDir"`
+	// 数据源页面缓存目录, 为空时使用 DataDir 下的 cache 目录, DataDir 也为空则不缓存
+	CacheDir string `json:"cacheDir" yaml:"cacheDir"`
+
+	// 开奖推送, 每秒最多发送的消息数
+	NotifyRate int `json:"notifyRate" yaml:"notifyRate"`
+	// 是否在存储中保存未投递完成的事件, 重启后继续投递
+	EventOutbox bool `json:"eventOutbox" yaml:"eventOutbox"`
+	// 是否在推送开奖结果前与福彩官网对账, 不一致的开奖结果不推送
+	Reconcile bool `json:"reconcile" yaml:"reconcile"`
+	// 内置之外的休市期间 (如春节), 格式为 "2006-01-02~2006-01-02", 期间不开奖也不报告同步失败
+	DrawSuspensions []string `json:"drawSuspensions" yaml:"drawSuspensions"`
+
+	// 数据源请求, 单次请求超时 (秒) 和失败重试次数
+	FetchTimeout int `json:"fetchTimeout" yaml:"fetchTimeout"`
+	FetchRetries int `json:"fetchRetries" yaml:"fetchRetries"`
+	// 每个数据源主机每秒请求数和并发数, 0 表示不限制
+	FetchRate        float64 `json:"fetchRate" yaml:"fetchRate"`
+	FetchConcurrency int     `json:"fetchConcurrency" yaml:"fetchConcurrency"`
+	// 数据源请求的 User-Agent, 为空使用默认值; 是否忽略 robots.txt
+	UserAgent    string `json:"userAgent" yaml:"userAgent"`
+	IgnoreRobots bool   `json:"ignoreRobots" yaml:"ignoreRobots"`
+	// 数据源代理 (http, https 或 socks5 url), 为空时使用环境变量 HTTP_PROXY/HTTPS_PROXY
+	FetchProxy string `json:"fetchProxy" yaml:"fetchProxy"`
+	// 数据源额外信任的 CA 证书文件 (PEM)
+	FetchCAFile string `json:"fetchCAFile" yaml:"fetchCAFile"`
+	// 每个数据源主机 (host[:port]) 的默认请求头
+	FetchHeaders map[string]map[string]string `json:"fetchHeaders" yaml:"fetchHeaders"`
+
+	// API 访问密钥, 也可以通过 /api/keys 保存在存储中
+	APIKeys []APIKey `json:"apiKeys" yaml:"apiKeys"`
+	// 每个客户端 (API key 或 IP) 每秒请求数, 0 表示不限制
+	RateLimit float64 `json:"rateLimit" yaml:"rateLimit"`
+	RateBurst int     `json:"rateBurst" yaml:"rateBurst"`
+}
+
+// APIKey 是 API 访问密钥, 只保存密钥的 sha256 哈希 (hex)
+type APIKey struct {
+	Name   string   `json:"name" yaml:"name"`
+	Hash   string   `json:"hash" yaml:"hash"`
+	Scopes []string `json:"scopes" yaml:"scopes"`
 }
 
 // TLSInfo is tls certificate info
 type TLSInfo struct {
-	CertFile       string
-	KeyFile        string
-	TrustedCAFile  string
-	ClientCertAuth bool
-	CRLFile        string
+	CertFile       string `json:"certFile" yaml:"certFile"`
+	KeyFile        string `json:"keyFile" yaml:"keyFile"`
+	TrustedCAFile  string `json:"trustedCAFile" yaml:"trustedCAFile"`
+	ClientCertAuth bool   `json:"clientCertAuth" yaml:"clientCertAuth"`
+	CRLFile        string `json:"crlFile" yaml:"crlFile"`
 
-	InsecureSkipVerify bool
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
 }
 
 const (
-	defaultName = "svs"
+	defaultName       = "svs"
+	defaultLogFormat  = "text"
+	defaultDataDir    = "data"
+	defaultNotifyRate = 10
+	defaultRateLimit  = 10
+	defaultRateBurst  = 20
+
+	defaultFetchTimeout     = 15
+	defaultFetchRetries     = 3
+	defaultFetchRate        = 2
+	defaultFetchConcurrency = 2
 )
 
 // New will construct a Config instance
 func New() *Config {
 	c := &Config{
-		Name: defaultName,
+		Name:        defaultName,
+		LogFormat:   defaultLogFormat,
+		DataDir:     defaultDataDir,
+		NotifyRate:  defaultNotifyRate,
+		EventOutbox: true,
+		Reconcile:   true,
+		RateLimit:   defaultRateLimit,
+		RateBurst:   defaultRateBurst,
+
+		FetchTimeout:     defaultFetchTimeout,
+		FetchRetries:     defaultFetchRetries,
+		FetchRate:        defaultFetchRate,
+		FetchConcurrency: defaultFetchConcurrency,
 	}
 
 	return c
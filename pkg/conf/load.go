@@ -0,0 +1,103 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix is the prefix of config environment variables
+	EnvPrefix = "TYCHE_"
+
+	redacted = "******"
+)
+
+// EnvName return the environment variable name of flag, eg: wx-appsecret => TYCHE_WX_APPSECRET
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// LoadFile will load config from yaml or json file, only the fields present in file are overwritten
+func LoadFile(path string, c *Config) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file failed: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, c)
+	default:
+		return fmt.Errorf("config file[%s] should be .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file[%s] failed: %v", path, err)
+	}
+
+	return nil
+}
+
+// Redacted return a copy of config with secret fields masked, the userinfo of proxy url is
+// stripped and all fetch header values are masked since they may be credentials
+func (c *Config) Redacted() *Config {
+	v := *c
+	for _, s := range []*string{&v.WxAppSecret, &v.WxToken, &v.WxEncodingAESKey} {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+
+	if v.FetchProxy != "" {
+		if u, err := url.Parse(v.FetchProxy); err == nil {
+			u.User = nil
+			v.FetchProxy = u.String()
+		} else {
+			v.FetchProxy = redacted
+		}
+	}
+	if c.FetchHeaders != nil {
+		v.FetchHeaders = make(map[string]map[string]string, len(c.FetchHeaders))
+		for host, headers := range c.FetchHeaders {
+			masked := make(map[string]string, len(headers))
+			for k := range headers {
+				masked[k] = redacted
+			}
+			v.FetchHeaders[host] = masked
+		}
+	}
+	return &v
+}
+
+// String return the json string of redacted config, it is safe to log
+func (c *Config) String() string {
+	content, err := json.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(content)
+}
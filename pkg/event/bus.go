@@ -0,0 +1,404 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tyche_events_total",
+		Help: "Total events by type and result: published, delivered, retried, failed or dropped.",
+	}, []string{"type", "result"})
+)
+
+const (
+	defaultMaxAttempts   = 5
+	defaultBackoff       = time.Second
+	defaultMaxBackoff    = time.Minute
+	defaultRetryInterval = 5 * time.Minute
+	// the failed event blocks the handler for 1 hour at most, then it is dropped
+	defaultMaxRetries = 12
+)
+
+// Handler handle the event, the event is delivered again if error returned.
+// It is called at least once for each event in publish order, so it should be idempotent.
+type Handler func(ctx context.Context, e *Event) error
+
+// Record is the event with the names of handlers it is delivered to
+type Record struct {
+	Event
+	Delivered []string
+}
+
+func (r *Record) delivered(name string) bool {
+	for _, v := range r.Delivered {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Outbox keep the records until delivered to all handlers, so they survive restarts
+type Outbox interface {
+	// Save will add or update the record
+	Save(r *Record) error
+	// Delete will remove the record of id
+	Delete(id string) error
+	// Records return the saved records order by publish time
+	Records() ([]*Record, error)
+}
+
+// subscriber has its own queue, so a slow or failing handler doesnot block the others
+type subscriber struct {
+	name    string
+	types   map[Type]bool
+	handler Handler
+
+	mu    sync.Mutex
+	queue []*Record
+	// blocked is true if the head of queue failed, it is not delivered until the next retry
+	blocked bool
+	// retries is the count of retry of the failed head
+	retries int
+	wake    chan struct{}
+}
+
+func (s *subscriber) accept(r *Record) bool {
+	return s.types == nil || s.types[r.Type]
+}
+
+func (s *subscriber) push(r *Record) {
+	s.mu.Lock()
+	s.queue = append(s.queue, r)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) next() (*Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.blocked || len(s.queue) == 0 {
+		return nil, false
+	}
+	r := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return r, true
+}
+
+// fail will put r back to the head of queue and block the queue until the next retry,
+// so the records after it are not delivered before it. It return false if r is retried
+// more than maxRetries times (no limit if 0), then r is dropped.
+func (s *subscriber) fail(r *Record, maxRetries int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if maxRetries > 0 && s.retries >= maxRetries {
+		s.retries = 0
+		return false
+	}
+	s.retries++
+	s.queue = append([]*Record{r}, s.queue...)
+	s.blocked = true
+	return true
+}
+
+// succeed will reset the retries after the head is delivered
+func (s *subscriber) succeed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retries = 0
+}
+
+// retry will unblock the queue, return the count of records waiting (0 if not blocked)
+func (s *subscriber) retry() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.blocked {
+		return 0
+	}
+	s.blocked = false
+	return len(s.queue)
+}
+
+// Bus deliver the published events to subscribed handlers, each handler receive the events
+// in order by its own goroutine. The failed event is retried every retryInterval, and the events
+// after it wait until it is delivered or dropped after maxRetries.
+type Bus struct {
+	outbox Outbox
+
+	maxAttempts   int
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	retryInterval time.Duration
+	maxRetries    int
+
+	mu      sync.Mutex
+	subs    []*subscriber
+	running bool
+	// records is the queued records which are not delivered to all handlers
+	records map[string]*Record
+}
+
+// New will construct a Bus instance, the events are only kept in memory if outbox is nil
+func New(outbox Outbox) *Bus {
+	return &Bus{
+		outbox:        outbox,
+		maxAttempts:   defaultMaxAttempts,
+		backoff:       defaultBackoff,
+		maxBackoff:    defaultMaxBackoff,
+		retryInterval: defaultRetryInterval,
+		maxRetries:    defaultMaxRetries,
+		records:       make(map[string]*Record),
+	}
+}
+
+// Subscribe will register handler of the types (all types if empty) with unique name.
+// The name is recorded in outbox, so it should be stable across restarts.
+// All handlers should be subscribed before Run.
+func (b *Bus) Subscribe(name string, handler Handler, types ...Type) {
+	sub := &subscriber{
+		name:    name,
+		handler: handler,
+		wake:    make(chan struct{}, 1),
+	}
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, sub)
+}
+
+// Publish will save e to outbox and queue it, it returns before the delivery
+func (b *Bus) Publish(e *Event) error {
+	r := &Record{Event: *e}
+	if b.outbox != nil {
+		if err := b.outbox.Save(r); err != nil {
+			return fmt.Errorf("save event[%s] to outbox: %v", e.ID, err)
+		}
+	}
+	events.WithLabelValues(string(e.Type), "published").Inc()
+
+	b.enqueue(r)
+	return nil
+}
+
+// enqueue will push r to the subscribers which it is not delivered to, it is ignored if queued.
+// The record without subscriber is removed once running, as all handlers are subscribed.
+func (b *Bus) enqueue(r *Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.records[r.ID]; ok {
+		return
+	}
+
+	subs := make([]*subscriber, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if sub.accept(r) && !r.delivered(sub.name) {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		if b.running {
+			b.remove(r)
+		}
+		return
+	}
+
+	b.records[r.ID] = r
+	for _, sub := range subs {
+		sub.push(r)
+	}
+}
+
+// remove will delete r from outbox, must be called with b.mu held
+func (b *Bus) remove(r *Record) {
+	delete(b.records, r.ID)
+	if b.outbox == nil {
+		return
+	}
+	if err := b.outbox.Delete(r.ID); err != nil {
+		logger.Errorf("Delete event[%s] from outbox failed: %s", r.ID, err)
+	}
+}
+
+// Run will replay the undelivered events in outbox, then deliver the published events until ctx done
+func (b *Bus) Run(ctx context.Context) error {
+	b.mu.Lock()
+	b.running = true
+	b.mu.Unlock()
+
+	if err := b.replay(); err != nil {
+		logger.Errorf("Replay the event outbox failed: %s", err)
+	}
+
+	b.mu.Lock()
+	subs := append([]*subscriber(nil), b.subs...)
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func(sub *subscriber) {
+			defer wg.Done()
+			b.run(ctx, sub)
+		}(sub)
+	}
+	wg.Wait()
+	return nil
+}
+
+// replay will queue the outbox records, the records published before Run are not queued again
+func (b *Bus) replay() error {
+	if b.outbox == nil {
+		return nil
+	}
+	records, err := b.outbox.Records()
+	if err != nil {
+		return err
+	}
+
+	if len(records) > 0 {
+		logger.Infof("Replay %d undelivered events", len(records))
+	}
+	for _, r := range records {
+		b.enqueue(r)
+	}
+	return nil
+}
+
+// run will deliver the queued records to sub until ctx done
+func (b *Bus) run(ctx context.Context, sub *subscriber) {
+	retry := time.NewTicker(b.retryInterval)
+	defer retry.Stop()
+
+	for {
+		r, ok := sub.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.wake:
+			case <-retry.C:
+				if n := sub.retry(); n > 0 {
+					logger.Infof("Retry the failed event of %s, %d events are queued", sub.name, n)
+				}
+			}
+			continue
+		}
+
+		b.deliver(ctx, sub, r)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// deliver will call the handler of sub with r, the record is removed from outbox once it is
+// delivered to (or dropped by) all handlers, otherwise it is retried later or after restart
+func (b *Bus) deliver(ctx context.Context, sub *subscriber, r *Record) {
+	if err := b.call(ctx, sub, &r.Event); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		events.WithLabelValues(string(r.Type), "failed").Inc()
+		if sub.fail(r, b.maxRetries) {
+			logger.Errorf("Deliver event[%s] %s to %s failed, retry after %s: %s", r.ID, r.Type, sub.name, b.retryInterval, err)
+			return
+		}
+		events.WithLabelValues(string(r.Type), "dropped").Inc()
+		logger.Errorf("Deliver event[%s] %s to %s failed, dropped after %d retries: %s", r.ID, r.Type, sub.name, b.maxRetries, err)
+	} else {
+		sub.succeed()
+		events.WithLabelValues(string(r.Type), "delivered").Inc()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r.Delivered = append(r.Delivered, sub.name)
+	for _, v := range b.subs {
+		if v.accept(r) && !r.delivered(v.name) {
+			if b.outbox != nil {
+				if err := b.outbox.Save(r); err != nil {
+					logger.Errorf("Save event[%s] to outbox failed: %s", r.ID, err)
+				}
+			}
+			return
+		}
+	}
+	b.remove(r)
+}
+
+// call will call the handler with backoff until it succeed, attempts exhausted or ctx done
+func (b *Bus) call(ctx context.Context, sub *subscriber, e *Event) error {
+	backoff := b.backoff
+	var err error
+	for attempt := 0; attempt < b.maxAttempts; attempt++ {
+		if attempt > 0 {
+			events.WithLabelValues(string(e.Type), "retried").Inc()
+			t := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+			if backoff *= 2; backoff > b.maxBackoff {
+				backoff = b.maxBackoff
+			}
+		}
+
+		if err = b.safeCall(ctx, sub, e); err == nil {
+			return nil
+		}
+		logger.Debugf("Deliver event[%s] to %s at attempt %d failed: %s", e.ID, sub.name, attempt+1, err)
+	}
+	return err
+}
+
+// safeCall will recover the panic of handler as error
+func (b *Bus) safeCall(ctx context.Context, sub *subscriber, e *Event) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("handler panic: %v", v)
+		}
+	}()
+	return sub.handler(ctx, e)
+}
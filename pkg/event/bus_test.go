@@ -0,0 +1,371 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/stretchr/testify/suite"
+)
+
+type memOutbox struct {
+	mu      sync.Mutex
+	records map[string]Record
+	order   []string
+}
+
+func newMemOutbox() *memOutbox {
+	return &memOutbox{records: make(map[string]Record)}
+}
+
+func (o *memOutbox) Save(r *Record) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.records[r.ID]; !ok {
+		o.order = append(o.order, r.ID)
+	}
+	v := *r
+	v.Delivered = append([]string(nil), r.Delivered...)
+	o.records[r.ID] = v
+	return nil
+}
+
+func (o *memOutbox) Delete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.records, id)
+	return nil
+}
+
+func (o *memOutbox) Records() ([]*Record, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	v := make([]*Record, 0, len(o.records))
+	for _, id := range o.order {
+		if r, ok := o.records[id]; ok {
+			v = append(v, &r)
+		}
+	}
+	return v, nil
+}
+
+// recorder record the handled event ids, the first fails calls of each event return error
+type recorder struct {
+	mu    sync.Mutex
+	fails int
+	calls map[string]int
+	ids   []string
+	ch    chan string
+}
+
+func newRecorder(fails int) *recorder {
+	return &recorder{
+		fails: fails,
+		calls: make(map[string]int),
+		ch:    make(chan string, 16),
+	}
+}
+
+func (r *recorder) handle(ctx context.Context, e *Event) error {
+	r.mu.Lock()
+	r.calls[e.ID]++
+	failed := r.calls[e.ID] <= r.fails
+	if !failed {
+		r.ids = append(r.ids, e.ID)
+	}
+	r.mu.Unlock()
+
+	if failed {
+		return errors.New("unavailable")
+	}
+	r.ch <- e.ID
+	return nil
+}
+
+type busTestSuite struct {
+	suite.Suite
+}
+
+func (p *busTestSuite) newBus(outbox Outbox) *Bus {
+	b := New(outbox)
+	b.backoff = time.Millisecond
+	b.maxBackoff = 2 * time.Millisecond
+	b.maxAttempts = 3
+	return b
+}
+
+// run will run b in background, the returned func stop it
+func (p *busTestSuite) run(b *Bus) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		p.NoError(<-done)
+	}
+}
+
+func (p *busTestSuite) wait(r *recorder) string {
+	select {
+	case id := <-r.ch:
+		return id
+	case <-time.After(5 * time.Second):
+		p.Fail("event is not delivered")
+		return ""
+	}
+}
+
+func (p *busTestSuite) newEvent(typ Type) *Event {
+	e, err := NewEvent(typ, &SyncFailed{Error: "timeout"})
+	p.NoError(err)
+	return e
+}
+
+func (p *busTestSuite) TestEventDecode() {
+	award := &tcb.Award{Term: 18077, Number: []uint8{1, 2, 3, 4, 5, 6, 7}}
+	e, err := NewEvent(TypeDrawPublished, &Draw{Award: award})
+	p.NoError(err)
+	p.Len(e.ID, 32)
+
+	var v Draw
+	p.NoError(e.Decode(&v))
+	p.Equal(award, v.Award)
+
+	e.Data = []byte("{")
+	p.Error(e.Decode(&v))
+}
+
+func (p *busTestSuite) TestDeliverByType() {
+	b := p.newBus(nil)
+	draws, all := newRecorder(0), newRecorder(0)
+	b.Subscribe("draws", draws.handle, TypeDrawPublished)
+	b.Subscribe("all", all.handle)
+	stop := p.run(b)
+	defer stop()
+
+	e1, e2 := p.newEvent(TypeSyncFailed), p.newEvent(TypeDrawPublished)
+	p.NoError(b.Publish(e1))
+	p.NoError(b.Publish(e2))
+
+	p.Equal(e1.ID, p.wait(all))
+	p.Equal(e2.ID, p.wait(all))
+	p.Equal(e2.ID, p.wait(draws))
+}
+
+func (p *busTestSuite) TestRetry() {
+	outbox := newMemOutbox()
+	b := p.newBus(outbox)
+	r := newRecorder(2)
+	b.Subscribe("r", r.handle)
+	stop := p.run(b)
+
+	e := p.newEvent(TypeTicketWon)
+	p.NoError(b.Publish(e))
+	p.Equal(e.ID, p.wait(r))
+	stop()
+
+	p.Equal(3, r.calls[e.ID])
+	records, err := outbox.Records()
+	p.NoError(err)
+	p.Empty(records)
+}
+
+func (p *busTestSuite) TestReplay() {
+	outbox := newMemOutbox()
+	b := p.newBus(outbox)
+	ok, broken := newRecorder(0), newRecorder(10)
+	b.Subscribe("ok", ok.handle)
+	b.Subscribe("broken", broken.handle)
+
+	// events published before run are delivered
+	e := p.newEvent(TypePrizeTableUpdated)
+	p.NoError(b.Publish(e))
+	stop := p.run(b)
+	p.Equal(e.ID, p.wait(ok))
+
+	// the event is kept since broken is not delivered
+	e2 := p.newEvent(TypeSyncFailed)
+	p.NoError(b.Publish(e2))
+	p.Equal(e2.ID, p.wait(ok))
+	stop()
+
+	records, err := outbox.Records()
+	p.NoError(err)
+	p.Len(records, 2)
+	p.Equal(e.ID, records[0].ID)
+	p.Equal([]string{"ok"}, records[0].Delivered)
+
+	// restart, only the undelivered handler is called
+	b = p.newBus(outbox)
+	broken = newRecorder(0)
+	b.Subscribe("ok", ok.handle)
+	b.Subscribe("broken", broken.handle)
+	stop = p.run(b)
+	p.Equal(e.ID, p.wait(broken))
+	p.Equal(e2.ID, p.wait(broken))
+	stop()
+
+	p.Equal(1, ok.calls[e.ID])
+	records, err = outbox.Records()
+	p.NoError(err)
+	p.Empty(records)
+}
+
+func (p *busTestSuite) TestSlowHandler() {
+	b := p.newBus(nil)
+	block := make(chan struct{})
+	defer close(block)
+	r := newRecorder(0)
+	b.Subscribe("slow", func(ctx context.Context, e *Event) error {
+		select {
+		case <-block:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	b.Subscribe("r", r.handle)
+	stop := p.run(b)
+	defer stop()
+
+	// the blocked handler doesnot delay the others
+	e1, e2 := p.newEvent(TypeSyncFailed), p.newEvent(TypeDrawPending)
+	p.NoError(b.Publish(e1))
+	p.NoError(b.Publish(e2))
+	p.Equal(e1.ID, p.wait(r))
+	p.Equal(e2.ID, p.wait(r))
+}
+
+func (p *busTestSuite) TestRetryInterval() {
+	outbox := newMemOutbox()
+	b := p.newBus(outbox)
+	b.retryInterval = 20 * time.Millisecond
+	// fail all attempts of the first delivery of e1
+	e1, e2, e3 := p.newEvent(TypeTicketWon), p.newEvent(TypeSyncFailed), p.newEvent(TypeDrawPending)
+	r := newRecorder(0)
+	attempts := 0
+	b.Subscribe("r", func(ctx context.Context, e *Event) error {
+		if e.ID == e1.ID {
+			if attempts++; attempts <= b.maxAttempts {
+				r.mu.Lock()
+				r.calls[e.ID]++
+				r.mu.Unlock()
+				return errors.New("unavailable")
+			}
+		}
+		return r.handle(ctx, e)
+	})
+	stop := p.run(b)
+
+	p.NoError(b.Publish(e1))
+	p.NoError(b.Publish(e2))
+
+	// e1 is retried by the timer, the events after it are not delivered before it
+	p.Equal(e1.ID, p.wait(r))
+	p.NoError(b.Publish(e3))
+	p.Equal(e2.ID, p.wait(r))
+	p.Equal(e3.ID, p.wait(r))
+	stop()
+
+	p.Equal(b.maxAttempts+1, r.calls[e1.ID])
+	p.Equal([]string{e1.ID, e2.ID, e3.ID}, r.ids)
+	records, err := outbox.Records()
+	p.NoError(err)
+	p.Empty(records)
+}
+
+func (p *busTestSuite) TestRetryDropped() {
+	outbox := newMemOutbox()
+	b := p.newBus(outbox)
+	b.retryInterval = 5 * time.Millisecond
+	b.maxRetries = 2
+	e1, e2 := p.newEvent(TypeTicketWon), p.newEvent(TypeSyncFailed)
+	r := newRecorder(0)
+	b.Subscribe("r", func(ctx context.Context, e *Event) error {
+		if e.ID == e1.ID {
+			r.mu.Lock()
+			r.calls[e.ID]++
+			r.mu.Unlock()
+			return errors.New("unavailable")
+		}
+		return r.handle(ctx, e)
+	})
+	stop := p.run(b)
+
+	p.NoError(b.Publish(e1))
+	p.NoError(b.Publish(e2))
+
+	// e1 blocks e2 until it is dropped
+	p.Equal(e2.ID, p.wait(r))
+	stop()
+
+	p.Equal(b.maxAttempts*(b.maxRetries+1), r.calls[e1.ID])
+	records, err := outbox.Records()
+	p.NoError(err)
+	p.Empty(records)
+}
+
+func (p *busTestSuite) TestNoSubscriber() {
+	outbox := newMemOutbox()
+	b := p.newBus(outbox)
+	r := newRecorder(0)
+	b.Subscribe("r", r.handle, TypeDrawPublished)
+
+	// kept until run, as the handlers may be subscribed later
+	p.NoError(b.Publish(p.newEvent(TypeSyncFailed)))
+	records, err := outbox.Records()
+	p.NoError(err)
+	p.Len(records, 1)
+
+	stop := p.run(b)
+	e := p.newEvent(TypeDrawPublished)
+	p.NoError(b.Publish(e))
+	p.Equal(e.ID, p.wait(r))
+	stop()
+
+	records, err = outbox.Records()
+	p.NoError(err)
+	p.Empty(records)
+}
+
+func (p *busTestSuite) TestHandlerPanic() {
+	b := p.newBus(nil)
+	b.maxAttempts = 1
+	r := newRecorder(0)
+	b.Subscribe("panic", func(ctx context.Context, e *Event) error {
+		panic("boom")
+	})
+	b.Subscribe("r", r.handle)
+	stop := p.run(b)
+	defer stop()
+
+	e := p.newEvent(TypeSyncFailed)
+	p.NoError(b.Publish(e))
+	p.Equal(e.ID, p.wait(r))
+}
+
+func TestBusTestSuite(t *testing.T) {
+	p := &busTestSuite{}
+	suite.Run(t, p)
+}
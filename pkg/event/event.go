@@ -0,0 +1,105 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package event is the in-process publish/subscribe bus of draw and ticket events
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+)
+
+// Type is the event type
+type Type string
+
+const (
+	// TypeDrawPending is published when the watcher starts polling a draw, payload is DrawPending
+	TypeDrawPending = Type("DrawPending")
+	// TypeDrawPublished is published when the numbers of a new draw are available, payload is Draw
+	TypeDrawPublished = Type("DrawPublished")
+	// TypePrizeTableUpdated is published when the prize table of a draw is complete, payload is Draw
+	TypePrizeTableUpdated = Type("PrizeTableUpdated")
+	// TypeTicketWon is published for each bet which won at the draw, payload is TicketWon
+	TypeTicketWon = Type("TicketWon")
+	// TypeSyncFailed is published when the draw is not synced within the window, payload is SyncFailed
+	TypeSyncFailed = Type("SyncFailed")
+)
+
+// Event is the published event, Data is the json encoded payload
+type Event struct {
+	ID   string          `json:"id"`
+	Type Type            `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// DrawPending is the payload of DrawPending
+type DrawPending struct {
+	Draw time.Time `json:"draw"`
+}
+
+// Draw is the payload of DrawPublished and PrizeTableUpdated
+type Draw struct {
+	Award *tcb.Award `json:"award"`
+}
+
+// TicketWon is the payload of TicketWon
+type TicketWon struct {
+	OpenID string         `json:"openid"`
+	Term   uint32         `json:"term"`
+	Ticket tcb.Ticket     `json:"ticket"`
+	Level  tcb.AwardLevel `json:"level"`
+	// Bonus of one bet at the level, 0 if unknown
+	Bonus uint32 `json:"bonus"`
+}
+
+// SyncFailed is the payload of SyncFailed
+type SyncFailed struct {
+	Draw  time.Time `json:"draw"`
+	Error string    `json:"error"`
+}
+
+// NewEvent will construct an event of typ with payload v
+func NewEvent(typ Type, v interface{}) (*Event, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s payload: %v", typ, err)
+	}
+	return &Event{
+		ID:   newID(),
+		Type: typ,
+		Time: time.Now().UTC(),
+		Data: data,
+	}, nil
+}
+
+// Decode will decode the payload into v
+func (e *Event) Decode(v interface{}) error {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("decode %s payload of event[%s]: %v", e.Type, e.ID, err)
+	}
+	return nil
+}
+
+// newID return a random 128-bit hex id
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -16,7 +16,9 @@
 package ierror
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/lsytj0413/ena/cerror"
 )
@@ -24,22 +26,90 @@ import (
 const (
 	// EcodeRequestParam errors for Request Param error info
 	EcodeRequestParam = 10000001
-	// EcodeIPNotFound errors for param ip location not found
-	EcodeIPNotFound = 20000001
+	// EcodeInvalidBet errors for bet numbers out of rule
+	EcodeInvalidBet = 10000002
+	// EcodeUnsupportedGame errors for lottery game not supported
+	EcodeUnsupportedGame = 10000003
+	// EcodeWxSignatureInvalid errors for wechat message signature mismatch
+	EcodeWxSignatureInvalid = 10000004
+	// EcodeRateLimited errors for too many requests
+	EcodeRateLimited = 10000005
+	// EcodeUnauthorized errors for api key missing or invalid
+	EcodeUnauthorized = 10000006
+	// EcodeForbidden errors for api key without the required scope
+	EcodeForbidden = 10000007
+	// EcodeNotFound errors for the requested resource not found
+	EcodeNotFound = 10000008
+	// EcodeTermNotFound errors for lottery term not found
+	EcodeTermNotFound = 20000002
 	// EcodeInitFailed errors for system init error
 	EcodeInitFailed = 30000001
+	// EcodeUpstreamFetch errors for fetch from upstream data source failed
+	EcodeUpstreamFetch = 30000002
+	// EcodeUpstreamParse errors for upstream data source content unexpected
+	EcodeUpstreamParse = 30000003
+	// EcodeUpstreamInvalid errors for upstream data violate the lottery rules
+	EcodeUpstreamInvalid = 30000004
 	// EcodeUnknown errors for unexpected server error
 	EcodeUnknown = 99999999
 )
 
+const (
+	// LangEN is english message
+	LangEN = "en"
+	// LangZH is chinese message
+	LangZH = "zh"
+)
+
 var errorsMessage = map[int]string{
-	EcodeRequestParam: "Request Param Error",
-	EcodeInitFailed:   "Server Startup Failed",
-	EcodeUnknown:      "Server Unknown Error",
+	EcodeRequestParam:       "Request Param Error",
+	EcodeInvalidBet:         "Invalid Bet Numbers",
+	EcodeUnsupportedGame:    "Unsupported Lottery Game",
+	EcodeWxSignatureInvalid: "Wechat Signature Invalid",
+	EcodeRateLimited:        "Too Many Requests",
+	EcodeUnauthorized:       "Unauthorized",
+	EcodeForbidden:          "Forbidden",
+	EcodeNotFound:           "Resource Not Found",
+	EcodeTermNotFound:       "Lottery Term Not Found",
+	EcodeInitFailed:         "Server Startup Failed",
+	EcodeUpstreamFetch:      "Upstream Fetch Failed",
+	EcodeUpstreamParse:      "Upstream Content Unexpected",
+	EcodeUpstreamInvalid:    "Upstream Data Invalid",
+	EcodeUnknown:            "Server Unknown Error",
+}
+
+var errorsMessageZH = map[int]string{
+	EcodeRequestParam:       "请求参数错误",
+	EcodeInvalidBet:         "投注号码不符合规则",
+	EcodeUnsupportedGame:    "不支持的彩票玩法",
+	EcodeWxSignatureInvalid: "微信签名校验失败",
+	EcodeRateLimited:        "请求过于频繁",
+	EcodeUnauthorized:       "未授权的访问",
+	EcodeForbidden:          "没有访问权限",
+	EcodeNotFound:           "请求的资源不存在",
+	EcodeTermNotFound:       "彩票期号不存在",
+	EcodeInitFailed:         "服务启动失败",
+	EcodeUpstreamFetch:      "获取数据源失败",
+	EcodeUpstreamParse:      "数据源内容解析失败",
+	EcodeUpstreamInvalid:    "数据源数据校验失败",
+	EcodeUnknown:            "服务器未知错误",
 }
 
 var errorsStatus = map[int]int{
-	EcodeUnknown: http.StatusInternalServerError,
+	EcodeRequestParam:       http.StatusBadRequest,
+	EcodeInvalidBet:         http.StatusBadRequest,
+	EcodeUnsupportedGame:    http.StatusBadRequest,
+	EcodeWxSignatureInvalid: http.StatusForbidden,
+	EcodeRateLimited:        http.StatusTooManyRequests,
+	EcodeUnauthorized:       http.StatusUnauthorized,
+	EcodeForbidden:          http.StatusForbidden,
+	EcodeNotFound:           http.StatusNotFound,
+	EcodeTermNotFound:       http.StatusNotFound,
+	EcodeInitFailed:         http.StatusInternalServerError,
+	EcodeUpstreamFetch:      http.StatusBadGateway,
+	EcodeUpstreamParse:      http.StatusBadGateway,
+	EcodeUpstreamInvalid:    http.StatusBadGateway,
+	EcodeUnknown:            http.StatusInternalServerError,
 }
 
 // NewError const struct a cerror.Error and return it
@@ -47,6 +117,85 @@ func NewError(errorCode int, cause string) *cerror.Error {
 	return cerror.NewError(errorCode, cause)
 }
 
+// Error is project error which keeps the underlying error
+type Error struct {
+	Code  int
+	Cause string
+	Err   error
+}
+
+// Wrap will construct a Error with the underlying err
+func Wrap(errorCode int, err error) *Error {
+	return &Error{
+		Code:  errorCode,
+		Cause: err.Error(),
+		Err:   err,
+	}
+}
+
+// Wrapf will construct a Error with the underlying err and format cause
+func Wrapf(errorCode int, err error, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:  errorCode,
+		Cause: fmt.Sprintf(format, args...) + ": " + err.Error(),
+		Err:   err,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%s)", Message(e.Code), e.Cause)
+}
+
+// Unwrap return the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Cause return the root underlying error of err
+func Cause(err error) error {
+	for {
+		v, ok := err.(*Error)
+		if !ok || v.Err == nil {
+			return err
+		}
+		err = v.Err
+	}
+}
+
+// Code return the error code and cause of err, EcodeUnknown for errors out of project
+func Code(err error) (int, string) {
+	switch v := err.(type) {
+	case *Error:
+		return v.Code, v.Cause
+	case *cerror.Error:
+		return v.ErrorCode, v.Cause
+	}
+	return EcodeUnknown, err.Error()
+}
+
+// StatusCode return the http status of errorCode
+func StatusCode(errorCode int) int {
+	if status, ok := errorsStatus[errorCode]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Message return the english message of errorCode
+func Message(errorCode int) string {
+	return errorsMessage[errorCode]
+}
+
+// MessageIn return the message of errorCode in lang, english is returned when lang is unsupported
+func MessageIn(errorCode int, lang string) string {
+	if strings.HasPrefix(strings.ToLower(lang), LangZH) {
+		if v, ok := errorsMessageZH[errorCode]; ok {
+			return v
+		}
+	}
+	return Message(errorCode)
+}
+
 func init() {
 	cerror.SetErrorsMessage(errorsMessage)
 	cerror.SetErrorsStatus(errorsStatus)
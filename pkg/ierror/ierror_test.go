@@ -0,0 +1,70 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ierror
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ierrorTestSuite struct {
+	suite.Suite
+}
+
+func (p *ierrorTestSuite) TestCatalogueComplete() {
+	for code, message := range errorsMessage {
+		p.NotEmpty(message, "code %d", code)
+		p.NotEmpty(errorsMessageZH[code], "code %d", code)
+		_, ok := errorsStatus[code]
+		p.True(ok, "code %d", code)
+	}
+	p.Equal(len(errorsMessage), len(errorsMessageZH))
+	p.Equal(len(errorsMessage), len(errorsStatus))
+}
+
+func (p *ierrorTestSuite) TestMessageIn() {
+	p.Equal("彩票期号不存在", MessageIn(EcodeTermNotFound, "zh-CN"))
+	p.Equal("Lottery Term Not Found", MessageIn(EcodeTermNotFound, LangEN))
+	p.Equal("Lottery Term Not Found", MessageIn(EcodeTermNotFound, "fr"))
+}
+
+func (p *ierrorTestSuite) TestWrapOk() {
+	root := errors.New("connection refused")
+	err := Wrapf(EcodeUpstreamFetch, root, "fetch term[%d]", 18077)
+	p.Equal(root, err.Unwrap())
+	p.Equal(root, Cause(err))
+	p.Equal(root, Cause(Wrap(EcodeUnknown, err)))
+
+	code, cause := Code(err)
+	p.Equal(EcodeUpstreamFetch, code)
+	p.Equal("fetch term[18077]: connection refused", cause)
+}
+
+func (p *ierrorTestSuite) TestCodeOk() {
+	code, cause := Code(NewError(EcodeRequestParam, "term"))
+	p.Equal(EcodeRequestParam, code)
+	p.Equal("term", cause)
+
+	code, cause = Code(errors.New("unexpected"))
+	p.Equal(EcodeUnknown, code)
+	p.Equal("unexpected", cause)
+}
+
+func TestIErrorTestSuite(t *testing.T) {
+	p := &ierrorTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lifecycle coordinate the background workers and the graceful shutdown of the process
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+)
+
+// Errors is the errors occurred while running or shutting down
+type Errors []error
+
+func (e Errors) Error() string {
+	v := make([]string, 0, len(e))
+	for _, err := range e {
+		v = append(v, err.Error())
+	}
+	return strings.Join(v, "; ")
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Manager run the background workers until shutdown.
+// On shutdown the hooks are called in reverse order of registration (e.g. drain http server),
+// then the workers are stopped via context cancellation.
+type Manager struct {
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	mu      sync.Mutex
+	hooks   []hook
+	running map[string]int
+	errs    Errors
+}
+
+// New will construct a Manager, shutdown will give up after timeout
+func New(timeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+		running: make(map[string]int),
+	}
+}
+
+// Context return the context which is canceled when workers should stop
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Go will run fn as a background worker, fn should return when ctx is done.
+// A worker returns error before shutdown will trigger the shutdown.
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	m.running[name]++
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		err := fn(m.ctx)
+
+		m.mu.Lock()
+		m.running[name]--
+		if m.running[name] == 0 {
+			delete(m.running, name)
+		}
+		if err != nil && m.ctx.Err() == nil {
+			m.errs = append(m.errs, fmt.Errorf("%s: %s", name, err))
+		}
+		m.mu.Unlock()
+
+		if err != nil && m.ctx.Err() == nil {
+			logger.Errorf("Worker %s failed: %s", name, err)
+			m.Stop()
+		}
+	}()
+}
+
+// OnShutdown will register fn to be called on shutdown, before the workers are stopped
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Stop will trigger the shutdown
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// Run will block until one of signals received, Stop called or a worker failed,
+// then shutdown and return the errors occurred
+func (m *Manager) Run(signals ...os.Signal) error {
+	quit := make(chan os.Signal, 1)
+	if len(signals) > 0 {
+		signal.Notify(quit, signals...)
+		defer signal.Stop(quit)
+	}
+
+	select {
+	case sig := <-quit:
+		logger.Infof("Receive signal %s, shutting down", sig)
+	case <-m.stop:
+		logger.Infof("Shutting down")
+	}
+
+	return m.shutdown()
+}
+
+func (m *Manager) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	m.mu.Lock()
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	var errs Errors
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown %s: %s", hooks[i].name, err))
+		}
+	}
+
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("shutdown timeout, workers still running: %s", strings.Join(m.runningNames(), ", ")))
+	}
+
+	m.mu.Lock()
+	errs = append(append(Errors{}, m.errs...), errs...)
+	m.mu.Unlock()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (m *Manager) runningNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.running))
+	for name := range m.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
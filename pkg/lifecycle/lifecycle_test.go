@@ -0,0 +1,121 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type lifecycleTestSuite struct {
+	suite.Suite
+}
+
+func (p *lifecycleTestSuite) TestStop() {
+	m := New(time.Second)
+
+	mu := sync.Mutex{}
+	order := make([]string, 0, 3)
+	record := func(v string) {
+		mu.Lock()
+		order = append(order, v)
+		mu.Unlock()
+	}
+
+	m.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		record("worker")
+		return ctx.Err()
+	})
+	m.OnShutdown("first", func(ctx context.Context) error {
+		record("first")
+		return nil
+	})
+	m.OnShutdown("second", func(ctx context.Context) error {
+		record("second")
+		return nil
+	})
+
+	m.Stop()
+	p.NoError(m.Run())
+	p.Equal([]string{"second", "first", "worker"}, order)
+	p.Error(m.Context().Err())
+}
+
+func (p *lifecycleTestSuite) TestWorkerFailed() {
+	m := New(time.Second)
+
+	m.Go("sleep", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	m.Go("failed", func(ctx context.Context) error {
+		return errors.New("listen failed")
+	})
+	m.OnShutdown("hook", func(ctx context.Context) error {
+		return errors.New("drain failed")
+	})
+
+	err := m.Run()
+	p.Error(err)
+	p.Len(err.(Errors), 2)
+	p.Equal("failed: listen failed; shutdown hook: drain failed", err.Error())
+}
+
+func (p *lifecycleTestSuite) TestWorkerDone() {
+	m := New(time.Second)
+
+	done := make(chan struct{})
+	m.Go("once", func(ctx context.Context) error {
+		defer close(done)
+		return nil
+	})
+	<-done
+
+	select {
+	case <-m.stop:
+		p.Fail("worker returned without error should not trigger shutdown")
+	default:
+	}
+
+	m.Stop()
+	p.NoError(m.Run())
+}
+
+func (p *lifecycleTestSuite) TestTimeout() {
+	m := New(10 * time.Millisecond)
+
+	block := make(chan struct{})
+	defer close(block)
+	m.Go("stuck", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+
+	m.Stop()
+	err := m.Run()
+	p.Error(err)
+	p.Contains(err.Error(), "workers still running: stuck")
+}
+
+func TestLifecycleTestSuite(t *testing.T) {
+	p := &lifecycleTestSuite{}
+	suite.Run(t, p)
+}
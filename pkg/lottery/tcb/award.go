@@ -0,0 +1,78 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// RedCount 是红球个数
+	RedCount = 6
+)
+
+// Reds return the red balls of award
+func (a *Award) Reds() []uint8 {
+	if len(a.Number) < RedCount {
+		return a.Number
+	}
+	return a.Number[:RedCount]
+}
+
+// Blue return the blue ball of award, 0 if number is incomplete
+func (a *Award) Blue() uint8 {
+	if len(a.Number) <= RedCount {
+		return 0
+	}
+	return a.Number[RedCount]
+}
+
+// NumberString return the award number like "03 07 12 19 25 31 + 09"
+func (a *Award) NumberString() string {
+	return formatNumber(a.Reds(), a.Blue())
+}
+
+// Piece return the prize piece of level, nil if not found
+func (a *Award) Piece(level AwardLevel) *Piece {
+	for i := range a.Pieces {
+		if a.Pieces[i].Level == level {
+			return &a.Pieces[i]
+		}
+	}
+	return nil
+}
+
+// Complete return whether the award is final: the number, sales volume and
+// the fixed bonus of level 3-6 are published, which are filled later than the number
+func (a *Award) Complete() bool {
+	if len(a.Number) != RedCount+1 || a.SalesVolume == 0 {
+		return false
+	}
+	for level := ThirdAward; level <= SixthAward; level++ {
+		if p := a.Piece(level); p == nil || p.Bonus == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func formatNumber(reds []uint8, blue uint8) string {
+	v := make([]string, len(reds))
+	for i, n := range reds {
+		v[i] = fmt.Sprintf("%02d", n)
+	}
+	return fmt.Sprintf("%s + %02d", strings.Join(v, " "), blue)
+}
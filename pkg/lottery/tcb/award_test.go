@@ -0,0 +1,69 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type awardTestSuite struct {
+	suite.Suite
+}
+
+func newCompleteAward() *Award {
+	return &Award{
+		Term:          18077,
+		AwardOpenDate: time.Date(2018, 7, 8, 0, 0, 0, 0, time.UTC),
+		DeadlineDate:  time.Date(2018, 9, 6, 0, 0, 0, 0, time.UTC),
+		Number:        []uint8{1, 2, 3, 4, 5, 6, 7},
+		SalesVolume:   355223534,
+		Pieces: []Piece{
+			{Level: FirstAward, Count: 0, Bonus: 0},
+			{Level: SecondAward, Count: 100, Bonus: 120000},
+			{Level: ThirdAward, Count: 1000, Bonus: 3000},
+			{Level: FourthAward, Count: 50000, Bonus: 200},
+			{Level: FifthAward, Count: 900000, Bonus: 10},
+			{Level: SixthAward, Count: 9000000, Bonus: 5},
+		},
+	}
+}
+
+func (p *awardTestSuite) TestComplete() {
+	p.True(newCompleteAward().Complete())
+
+	v := newCompleteAward()
+	v.Number = v.Number[:RedCount]
+	p.False(v.Complete())
+
+	v = newCompleteAward()
+	v.SalesVolume = 0
+	p.False(v.Complete())
+
+	v = newCompleteAward()
+	v.Pieces[5].Bonus = 0
+	p.False(v.Complete())
+
+	v = newCompleteAward()
+	v.Pieces = nil
+	p.False(v.Complete())
+}
+
+func TestAwardTestSuite(t *testing.T) {
+	p := &awardTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/util"
+)
+
+const (
+	cwlURL     = "http://www.cwl.gov.cn/cwl_admin/front/cwlkj/search/kjxx/findDrawNotice"
+	cwlReferer = "http://www.cwl.gov.cn/ygkj/wqkjgg/ssq/"
+	// 期号列表只取最近的期数
+	cwlIssueCount = 30
+)
+
+// SourceCWL 是中国福利彩票官网 (cwl.gov.cn) 数据源, 不提供兑奖截止日期
+var SourceCWL Fetcher = sourceCWL{}
+
+type sourceCWL struct{}
+
+type cwlPrizeGrade struct {
+	Type      int    `json:"type"`
+	TypeNum   string `json:"typenum"`
+	TypeMoney string `json:"typemoney"`
+}
+
+type cwlDraw struct {
+	Code        string          `json:"code"`
+	Date        string          `json:"date"`
+	Red         string          `json:"red"`
+	Blue        string          `json:"blue"`
+	Sales       string          `json:"sales"`
+	PoolMoney   string          `json:"poolmoney"`
+	PrizeGrades []cwlPrizeGrade `json:"prizegrades"`
+}
+
+type cwlResponse struct {
+	State   int       `json:"state"`
+	Message string    `json:"message"`
+	Result  []cwlDraw `json:"result"`
+}
+
+func (sourceCWL) Name() string {
+	return "cwl.gov.cn"
+}
+
+func (sourceCWL) FetchTermList(ctx context.Context) ([]uint32, error) {
+	resp, err := requestCWL(withCacheTTL(ctx, termListCacheTTL), fmt.Sprintf("issueCount=%d", cwlIssueCount))
+	if err != nil {
+		return nil, ierror.Wrapf(ierror.EcodeUpstreamFetch, err, "fetch term list")
+	}
+
+	terms, err := parseCWLTermList(resp)
+	if err != nil {
+		return nil, ierror.Wrapf(ierror.EcodeUpstreamParse, err, "parse term list")
+	}
+	return terms, nil
+}
+
+func (sourceCWL) FetchFromTerm(ctx context.Context, term uint32) (*Award, error) {
+	issue := cwlIssue(term)
+	resp, err := requestCWL(withCacheTTL(ctx, termCacheTTL), fmt.Sprintf("issueStart=%s&issueEnd=%s", issue, issue))
+	if err != nil {
+		return nil, ierror.Wrapf(ierror.EcodeUpstreamFetch, err, "fetch term[%d]", term)
+	}
+	if len(resp.Result) == 0 {
+		return nil, ierror.Wrapf(ierror.EcodeTermNotFound, errors.New("result is empty"), "fetch term[%d]", term)
+	}
+
+	award, err := parseCWLAward(term, &resp.Result[0])
+	if err != nil {
+		return nil, ierror.Wrapf(ierror.EcodeUpstreamParse, err, "parse term[%d]", term)
+	}
+	return award, nil
+}
+
+func requestCWL(ctx context.Context, query string) (*cwlResponse, error) {
+	request, err := http.NewRequest("GET", cwlURL+"?name=ssq&"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the api rejects the request without referer
+	request.Header.Set("Referer", cwlReferer)
+	request = request.WithContext(ctx)
+
+	body, err := util.DoRequestReader(request)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return decodeCWL(body)
+}
+
+func decodeCWL(r io.Reader) (*cwlResponse, error) {
+	resp := &cwlResponse{}
+	if err := json.NewDecoder(r).Decode(resp); err != nil {
+		return nil, err
+	}
+	if resp.State != 0 {
+		return nil, fmt.Errorf("state[%d] is not ok: %s", resp.State, resp.Message)
+	}
+	return resp, nil
+}
+
+// cwlIssue convert term yyNNN to the issue yyyyNNN
+func cwlIssue(term uint32) string {
+	return fmt.Sprintf("20%05d", term)
+}
+
+// parseCWLTerm convert the issue yyyyNNN to term yyNNN
+func parseCWLTerm(code string) (uint32, error) {
+	v, err := strconv.ParseUint(code, 10, 32)
+	if err != nil || len(code) != 7 {
+		return 0, fmt.Errorf("code[%s] is not format yyyyNNN", code)
+	}
+	return uint32(v/1000%100*1000 + v%1000), nil
+}
+
+func parseCWLTermList(resp *cwlResponse) ([]uint32, error) {
+	if len(resp.Result) == 0 {
+		return nil, fmt.Errorf("result is empty")
+	}
+
+	// the result is in descending order
+	terms := make([]uint32, len(resp.Result))
+	for i := range resp.Result {
+		term, err := parseCWLTerm(resp.Result[i].Code)
+		if err != nil {
+			return nil, err
+		}
+		terms[len(terms)-i-1] = term
+	}
+	return terms, nil
+}
+
+var cwlDateRegexp = regexp.MustCompile(`^([[:digit:]]{4})-([[:digit:]]{2})-([[:digit:]]{2})`)
+
+func parseCWLAward(term uint32, v *cwlDraw) (award *Award, err error) {
+	code, err := parseCWLTerm(v.Code)
+	if err != nil {
+		return
+	}
+	if code != term {
+		err = fmt.Errorf("code[%s] doesnot equal to args term[%d]", v.Code, term)
+		return
+	}
+
+	award = &Award{
+		Term: term,
+	}
+	d := cwlDateRegexp.FindStringSubmatch(v.Date)
+	if len(d) != 4 {
+		err = fmt.Errorf("date[%s] is unexpected format", v.Date)
+		return
+	}
+	if award.AwardOpenDate, err = parseDate(d[1], d[2], d[3]); err != nil {
+		return
+	}
+
+	reds := strings.Split(v.Red, ",")
+	if len(reds) != RedCount {
+		err = fmt.Errorf("red[%s] count doesnot equal %d", v.Red, RedCount)
+		return
+	}
+	award.Number = make([]uint8, 0, RedCount+1)
+	for _, s := range append(reds, v.Blue) {
+		var n int
+		n, err = strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			err = fmt.Errorf("ball[%s] is unexpected format: %v", s, err)
+			return
+		}
+		award.Number = append(award.Number, uint8(n))
+	}
+
+	if award.SalesVolume, err = parseUint(v.Sales); err != nil {
+		return
+	}
+	if award.RemainBonus, err = parseUint(v.PoolMoney); err != nil {
+		return
+	}
+
+	// the prize grades may contain other types (e.g. 福运奖), only the level 1-6 are kept
+	pieces := make([]Piece, SixthAward)
+	found := 0
+	for _, g := range v.PrizeGrades {
+		if g.Type < int(FirstAward) || g.Type > int(SixthAward) {
+			continue
+		}
+		var count, bonus uint64
+		if count, err = parseUint(g.TypeNum); err != nil {
+			return
+		}
+		if bonus, err = parseUint(g.TypeMoney); err != nil {
+			return
+		}
+		pieces[g.Type-1] = Piece{Level: AwardLevel(g.Type), Count: uint32(count), Bonus: uint32(bonus)}
+		found++
+	}
+	if found == int(SixthAward) {
+		award.Pieces = pieces
+	}
+	return
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type cwlTestSuite struct {
+	suite.Suite
+}
+
+func (p *cwlTestSuite) load(name string) *cwlResponse {
+	f, err := os.Open(filepath.Join("testdata", name))
+	p.Require().NoError(err)
+	defer f.Close()
+
+	resp, err := decodeCWL(f)
+	p.Require().NoError(err)
+	return resp
+}
+
+func (p *cwlTestSuite) TestParseTermList() {
+	terms, err := parseCWLTermList(p.load("cwl_18077.json"))
+	p.NoError(err)
+	p.Equal([]uint32{18077, 18078}, terms)
+
+	_, err = parseCWLTermList(&cwlResponse{})
+	p.Error(err)
+}
+
+func (p *cwlTestSuite) TestParseAward() {
+	resp := p.load("cwl_18077.json")
+	award, err := parseCWLAward(18077, &resp.Result[1])
+	p.NoError(err)
+
+	expect := newCompleteAward()
+	expect.DeadlineDate = time.Time{}
+	expect.RemainBonus = 1234567890
+	p.Equal(expect, award)
+	p.Empty(Compare(newCompleteAward(), award))
+
+	award, err = parseCWLAward(18078, &resp.Result[0])
+	p.NoError(err)
+	p.Equal([]uint8{11, 12, 13, 14, 15, 16, 8}, award.Number)
+	p.False(award.Complete())
+	p.NoError(Validate(award))
+
+	_, err = parseCWLAward(18078, &resp.Result[1])
+	p.Error(err)
+}
+
+func (p *cwlTestSuite) TestParseTerm() {
+	term, err := parseCWLTerm("2018077")
+	p.NoError(err)
+	p.Equal(uint32(18077), term)
+	p.Equal("2018077", cwlIssue(term))
+
+	for _, code := range []string{"", "18077", "2018o77"} {
+		_, err = parseCWLTerm(code)
+		p.Error(err, code)
+	}
+}
+
+func (p *cwlTestSuite) TestDecodeState() {
+	_, err := decodeCWL(strings.NewReader(`{"state":1,"message":"error"}`))
+	p.Error(err)
+}
+
+func TestCwlTestSuite(t *testing.T) {
+	p := &cwlTestSuite{}
+	suite.Run(t, p)
+}
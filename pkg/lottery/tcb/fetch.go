@@ -15,6 +15,7 @@
 package tcb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -24,6 +25,8 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/ierror"
 	"github.com/lsytj0413/tyche/pkg/util"
 )
 
@@ -34,7 +37,7 @@ type Award struct {
 	Term          uint32
 	AwardOpenDate time.Time
 	DeadlineDate  time.Time
-	Number        []uint8
+	Number        []uint8 // 6 个红球 + 1 个蓝球
 	SalesVolume   uint64
 	RemainBonus   uint64
 	Pieces        []Piece
@@ -67,8 +70,21 @@ type Piece struct {
 
 const (
 	url = "http://kaijiang.500.com/shtml/ssq/"
+
+	// 期号列表页的缓存时间
+	termListCacheTTL = 5 * time.Minute
+	// 开奖页未完成时的缓存时间, 完成后永久缓存
+	termCacheTTL = time.Minute
 )
 
+// withCacheTTL enable the response cache with ttl, unless ctx has its own
+func withCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	if _, ok := util.CacheTTL(ctx); ok {
+		return ctx
+	}
+	return util.WithCacheTTL(ctx, ttl)
+}
+
 func termToString(term uint32) string {
 	return fmt.Sprintf("%05d", term)
 }
@@ -83,23 +99,62 @@ func requestDocumentContent() (content string, err error) {
 	return
 }
 
+// Fetcher 是开奖数据源
+type Fetcher interface {
+	// Name 是数据源名称
+	Name() string
+	// FetchTermList will fetch all terms in ascending order
+	FetchTermList(ctx context.Context) ([]uint32, error)
+	// FetchFromTerm will fetch award data at term
+	FetchFromTerm(ctx context.Context, term uint32) (*Award, error)
+}
+
+// Source500 是 kaijiang.500.com 数据源, 即 FetchTermList 和 FetchFromTerm
+var Source500 Fetcher = source500{}
+
+type source500 struct{}
+
+func (source500) Name() string {
+	return "500.com"
+}
+
+func (source500) FetchTermList(ctx context.Context) ([]uint32, error) {
+	return FetchTermList(ctx)
+}
+
+func (source500) FetchFromTerm(ctx context.Context, term uint32) (*Award, error) {
+	return FetchFromTerm(ctx, term)
+}
+
 // FetchTermList will fetch all terms
-func FetchTermList() (terms []uint32, err error) {
+func FetchTermList(ctx context.Context) (terms []uint32, err error) {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return
 	}
+	request = request.WithContext(withCacheTTL(ctx, termListCacheTTL))
 
-	content, err := util.DoRequest(request)
+	body, err := util.DoRequestReader(request)
 	if err != nil {
+		err = ierror.Wrapf(ierror.EcodeUpstreamFetch, err, "fetch term list")
 		return
 	}
+	defer body.Close()
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	doc, err := goquery.NewDocumentFromReader(body)
 	if err != nil {
+		err = ierror.Wrapf(ierror.EcodeUpstreamParse, err, "parse term list")
 		return
 	}
 
+	terms, err = parseTermList(doc)
+	if err != nil {
+		err = ierror.Wrapf(ierror.EcodeUpstreamParse, err, "parse term list")
+	}
+	return
+}
+
+func parseTermList(doc *goquery.Document) (terms []uint32, err error) {
 	termNodes := doc.Find(".kj_main01_right .kjxq_box02 .iSelectBox .iSelectList a")
 	termNodesLength := termNodes.Length()
 	if termNodesLength < 1 {
@@ -125,31 +180,85 @@ func FetchTermList() (terms []uint32, err error) {
 }
 
 // FetchFromTerm will fetch award data at term
-func FetchFromTerm(term uint32) (award *Award, err error) {
+func FetchFromTerm(ctx context.Context, term uint32) (award *Award, err error) {
 	request, err := http.NewRequest("GET", fmt.Sprintf("%s/%s.shtml", url, termToString(term)), nil)
 	if err != nil {
 		return
 	}
+	request = request.WithContext(withCacheTTL(ctx, termCacheTTL))
+
+	body, err := util.DoRequestReader(request)
+	if err != nil {
+		if v, ok := err.(*util.StatusError); ok && v.StatusCode == http.StatusNotFound {
+			err = ierror.Wrapf(ierror.EcodeTermNotFound, err, "fetch term[%d]", term)
+			return
+		}
+		err = ierror.Wrapf(ierror.EcodeUpstreamFetch, err, "fetch term[%d]", term)
+		return
+	}
+
+	defer body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		err = ierror.Wrapf(ierror.EcodeUpstreamParse, err, "parse term[%d]", term)
+		return
+	}
 
-	content, err := util.DoRequest(request)
+	award, err = parseAward(term, doc)
 	if err != nil {
+		award, err = nil, ierror.Wrapf(ierror.EcodeUpstreamParse, err, "parse term[%d]", term)
 		return
 	}
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	// the page of finished term never change
+	if award.Complete() {
+		if err := util.SetCacheTTL(request.URL.String(), util.CacheForever); err != nil {
+			logger.Errorf("Keep the cache of term[%d] failed: %s", term, err)
+		}
+	}
+	return
+}
+
+// FetchLatest will fetch award data at the latest term
+func FetchLatest(ctx context.Context) (award *Award, err error) {
+	terms, err := FetchTermList(ctx)
 	if err != nil {
 		return
 	}
 
+	return FetchFromTerm(ctx, terms[len(terms)-1])
+}
+
+var (
+	termTimeRegexp = regexp.MustCompile(`^开奖日期：([[:digit:]]+)年([[:digit:]]+)月([[:digit:]]+)日 兑奖截止日期：([[:digit:]]+)年([[:digit:]]+)月([[:digit:]]+)日$`)
+	digitsRegexp   = regexp.MustCompile(`[^[:digit:]]`)
+)
+
+func parseDate(year, month, day string) (time.Time, error) {
+	y, _ := strconv.Atoi(year)
+	m, _ := strconv.Atoi(month)
+	d, _ := strconv.Atoi(day)
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if t.Year() != y || t.Month() != time.Month(m) || t.Day() != d {
+		return t, fmt.Errorf("date[%s-%s-%s] is invalid", year, month, day)
+	}
+	return t, nil
+}
+
+// parseUint will parse number like "355,223,534元", empty or "--" is parsed as zero
+func parseUint(s string) (uint64, error) {
+	v := digitsRegexp.ReplaceAllString(s, "")
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+func parseAward(term uint32, doc *goquery.Document) (award *Award, err error) {
 	termTitleNode := doc.Find(".kj_main01_right .kj_tablelist02 .td_title01 span")
-	// termTitleNode.Each(func(i int, s *goquery.Selection) {
-	// 	v, _ := s.Html()
-	// 	fmt.Println("--------:   ", v)
-	// })
-	// htmlStr, _ := termTitleNode.Parent().Html()
-	// fmt.Println(htmlStr)
 	if termTitleNode.Length() != 3 {
-		err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 .td_title01 span] select length doesnot equal 2")
+		err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 .td_title01 span] select length doesnot equal 3")
 		return
 	}
 
@@ -165,57 +274,77 @@ func FetchFromTerm(term uint32) (award *Award, err error) {
 		return
 	}
 
+	award = &Award{
+		Term: term,
+	}
+
 	termTimeNodeText := termTitleNode.Eq(1).Text()
-	termTimeRegexp := regexp.MustCompile(`^开奖日期：([[:digit:]]+)年([[:digit:]]+)月([[:digit:]]+)日 兑奖截止日期：([[:digit:]]+)年([[:digit:]]+)月([[:digit:]]+)日$`)
 	v := termTimeRegexp.FindStringSubmatch(strings.TrimSpace(termTimeNodeText))
 	if len(v) != 7 {
 		err = fmt.Errorf("termTimeNodeValue[%s] form html is unexepected format ", termTimeNodeText)
 		return
 	}
-	// fmt.Println(v)
-	for i, v0 := range v {
-		if len(v0) == 1 {
-			v[i] = "0" + v0
-		}
+	if award.AwardOpenDate, err = parseDate(v[1], v[2], v[3]); err != nil {
+		return
+	}
+	if award.DeadlineDate, err = parseDate(v[4], v[5], v[6]); err != nil {
+		return
 	}
-	openTimeStr := fmt.Sprintf("%s-%s-%sT00:00:00.000Z", v[1], v[2], v[3])
-	t, err := time.ParseInLocation(time.RFC3339Nano, openTimeStr, time.UTC)
-	fmt.Println(t.Format("2006-01-02 15:04:05"))
-
-	ballNode := doc.Find(".kj_main01_right .kj_tablelist02 .ball_box01 .ball_blue")
-	// if ballNode.Length() != 7 {
-	// 	err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 .ball_box01 li] select length doesnot equal 7")
-	// 	return
-	// }
-	ballBlue := ballNode.Text()
-	fmt.Println("blue ball: ", ballBlue)
 
-	ballNodes := doc.Find(".kj_main01_right .kj_tablelist02 tr td tr td")
-	if ballNodes.Length() != 4 {
-		err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 tr td tr td] select length doesnot equal 4")
+	redNodes := doc.Find(".kj_main01_right .kj_tablelist02 .ball_box01 .ball_red")
+	if redNodes.Length() != RedCount {
+		err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 .ball_box01 .ball_red] select length doesnot equal %d", RedCount)
+		return
+	}
+	blueNode := doc.Find(".kj_main01_right .kj_tablelist02 .ball_box01 .ball_blue")
+	if blueNode.Length() != 1 {
+		err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 .ball_box01 .ball_blue] select length doesnot equal 1")
 		return
 	}
-	ballReds := ballNodes.Eq(3).Text()
-	// fmt.Println(ballReds)
-	fmt.Println(strings.Split(strings.TrimSpace(ballReds), " "))
+	award.Number = make([]uint8, 0, RedCount+1)
+	for _, s := range append(redNodes.Map(func(i int, s *goquery.Selection) string {
+		return s.Text()
+	}), blueNode.Text()) {
+		var n int
+		n, err = strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			err = fmt.Errorf("ball[%s] is unexpected format: %v", s, err)
+			return
+		}
+		award.Number = append(award.Number, uint8(n))
+	}
 
 	bonusNodes := doc.Find(".kj_main01_right .kj_tablelist02 .cfont1")
 	if bonusNodes.Length() != 2 {
 		err = fmt.Errorf("[.kj_main01_right .kj_tablelist02 .cfont1] select length doesnot equal 2")
 		return
 	}
-	fmt.Println(bonusNodes.Eq(0).Text(), "  :  ", bonusNodes.Eq(1).Text())
+	if award.SalesVolume, err = parseUint(bonusNodes.Eq(0).Text()); err != nil {
+		return
+	}
+	if award.RemainBonus, err = parseUint(bonusNodes.Eq(1).Text()); err != nil {
+		return
+	}
 
 	piecesNodes := doc.Find(".kj_main01_right .kj_tablelist02").Eq(1).Find("tr td")
 	if piecesNodes.Length() != 24 {
 		err = fmt.Errorf("[piecesNodes] select length[%d] doesnot equal 24", piecesNodes.Length())
 		return
 	}
+	award.Pieces = make([]Piece, 0, 6)
 	for i := 4; i < 22; i += 3 {
-		fmt.Println("-------------------------")
-		fmt.Println(piecesNodes.Eq(i).Text())
-		fmt.Println(piecesNodes.Eq(i + 1).Text())
-		fmt.Println(piecesNodes.Eq(i + 2).Text())
+		var count, bonus uint64
+		if count, err = parseUint(piecesNodes.Eq(i + 1).Text()); err != nil {
+			return
+		}
+		if bonus, err = parseUint(piecesNodes.Eq(i + 2).Text()); err != nil {
+			return
+		}
+		award.Pieces = append(award.Pieces, Piece{
+			Level: AwardLevel(len(award.Pieces) + 1),
+			Count: uint32(count),
+			Bonus: uint32(bonus),
+		})
 	}
 
 	return
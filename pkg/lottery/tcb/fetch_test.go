@@ -0,0 +1,83 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/suite"
+)
+
+type fetchTestSuite struct {
+	suite.Suite
+}
+
+func (p *fetchTestSuite) load(name string) *goquery.Document {
+	f, err := os.Open(filepath.Join("testdata", name))
+	p.Require().NoError(err)
+	defer f.Close()
+
+	doc, err := goquery.NewDocumentFromReader(f)
+	p.Require().NoError(err)
+	return doc
+}
+
+func (p *fetchTestSuite) TestParseTermList() {
+	terms, err := parseTermList(p.load("ssq_18077.html"))
+	p.NoError(err)
+	p.Equal([]uint32{18076, 18077, 18078}, terms)
+
+	_, err = parseTermList(p.load("ssq_18077_noprize.html"))
+	p.Error(err)
+}
+
+func (p *fetchTestSuite) TestParseAward() {
+	award, err := parseAward(18077, p.load("ssq_18077.html"))
+	p.NoError(err)
+
+	expect := newCompleteAward()
+	expect.RemainBonus = 1234567890
+	p.Equal(expect, award)
+	p.True(award.Complete())
+}
+
+func (p *fetchTestSuite) TestParseAwardPending() {
+	award, err := parseAward(18078, p.load("ssq_18078_pending.html"))
+	p.NoError(err)
+	p.Equal(uint32(18078), award.Term)
+	p.Equal([]uint8{1, 2, 3, 4, 5, 6, 7}, award.Number)
+	p.Zero(award.SalesVolume)
+	p.Len(award.Pieces, 6)
+	p.False(award.Complete())
+}
+
+func (p *fetchTestSuite) TestParseAwardMissingPrizeTable() {
+	_, err := parseAward(18077, p.load("ssq_18077_noprize.html"))
+	p.Error(err)
+	p.Contains(err.Error(), "piecesNodes")
+}
+
+func (p *fetchTestSuite) TestParseAwardTermMismatch() {
+	_, err := parseAward(18078, p.load("ssq_18077.html"))
+	p.Error(err)
+}
+
+func TestFetchTestSuite(t *testing.T) {
+	p := &fetchTestSuite{}
+	suite.Run(t, p)
+}
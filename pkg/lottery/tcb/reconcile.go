@@ -0,0 +1,126 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Diff 是两个数据源同一字段的不同值
+type Diff struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// Reconciliation 是同一期在两个数据源的对账结果
+type Reconciliation struct {
+	Term    uint32    `json:"term"`
+	Sources [2]string `json:"sources"`
+	// Problems 是每个数据源的校验错误
+	Problems [2][]string `json:"problems"`
+	// Warnings 是每个数据源的异常数据, 需要人工确认但不标记
+	Warnings [2][]string `json:"warnings"`
+	Diffs    []Diff      `json:"diffs"`
+}
+
+// Flagged return whether the term need review: the sources disagree or data is invalid
+func (r *Reconciliation) Flagged() bool {
+	return len(r.Diffs) > 0 || len(r.Problems[0]) > 0 || len(r.Problems[1]) > 0
+}
+
+// Compare return the different fields of a and b.
+// The reds are compared regardless of order, the zero value (not published yet or by the source) of
+// deadline, sales volume, remain bonus and pieces are skipped.
+func Compare(a, b *Award) []Diff {
+	diffs := make([]Diff, 0)
+	add := func(field string, va, vb string) {
+		if va != vb {
+			diffs = append(diffs, Diff{Field: field, A: va, B: vb})
+		}
+	}
+	addUint := func(field string, va, vb uint64) {
+		if va != 0 && vb != 0 {
+			add(field, strconv.FormatUint(va, 10), strconv.FormatUint(vb, 10))
+		}
+	}
+
+	add("term", termToString(a.Term), termToString(b.Term))
+	add("number", sortedNumberString(a), sortedNumberString(b))
+	add("awardOpenDate", a.AwardOpenDate.Format("2006-01-02"), b.AwardOpenDate.Format("2006-01-02"))
+	if !a.DeadlineDate.IsZero() && !b.DeadlineDate.IsZero() {
+		add("deadlineDate", a.DeadlineDate.Format("2006-01-02"), b.DeadlineDate.Format("2006-01-02"))
+	}
+	addUint("salesVolume", a.SalesVolume, b.SalesVolume)
+	addUint("remainBonus", a.RemainBonus, b.RemainBonus)
+
+	for level := FirstAward; level <= SixthAward; level++ {
+		pa, pb := a.Piece(level), b.Piece(level)
+		if pa == nil || pb == nil {
+			continue
+		}
+		addUint(fmt.Sprintf("pieces[%d].count", level), uint64(pa.Count), uint64(pb.Count))
+		addUint(fmt.Sprintf("pieces[%d].bonus", level), uint64(pa.Bonus), uint64(pb.Bonus))
+	}
+	return diffs
+}
+
+func sortedNumberString(a *Award) string {
+	reds := append([]uint8(nil), a.Reds()...)
+	sort.Slice(reds, func(i, j int) bool {
+		return reds[i] < reds[j]
+	})
+	return formatNumber(reds, a.Blue())
+}
+
+// Reconcile will fetch term from sources a and b concurrently, validate and compare them.
+// The error is returned if any source fetch failed.
+func Reconcile(ctx context.Context, term uint32, a Fetcher, b Fetcher) (*Reconciliation, error) {
+	type result struct {
+		award *Award
+		err   error
+	}
+	sources := [2]Fetcher{a, b}
+	results := [2]chan result{make(chan result, 1), make(chan result, 1)}
+	for i := range sources {
+		go func(i int) {
+			award, err := sources[i].FetchFromTerm(ctx, term)
+			results[i] <- result{award: award, err: err}
+		}(i)
+	}
+
+	r := &Reconciliation{
+		Term:    term,
+		Sources: [2]string{a.Name(), b.Name()},
+	}
+	var awards [2]*Award
+	for i := range results {
+		v := <-results[i]
+		if v.err != nil {
+			return nil, fmt.Errorf("reconcile term[%05d]: source[%s] fetch failed: %v", term, sources[i].Name(), v.err)
+		}
+		awards[i] = v.award
+		if err := Validate(v.award); err != nil {
+			r.Problems[i] = err.(*ValidationError).Problems
+		}
+		r.Warnings[i] = Warnings(v.award)
+	}
+
+	r.Diffs = Compare(awards[0], awards[1])
+	return r, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type reconcileTestSuite struct {
+	suite.Suite
+}
+
+// stubFetcher is a Fetcher return the award or error
+type stubFetcher struct {
+	name  string
+	award *Award
+	err   error
+}
+
+func (f *stubFetcher) Name() string {
+	return f.name
+}
+
+func (f *stubFetcher) FetchTermList(ctx context.Context) ([]uint32, error) {
+	return []uint32{f.award.Term}, f.err
+}
+
+func (f *stubFetcher) FetchFromTerm(ctx context.Context, term uint32) (*Award, error) {
+	return f.award, f.err
+}
+
+func (p *reconcileTestSuite) TestReconcileAgreed() {
+	a := newCompleteAward()
+	b := newCompleteAward()
+	// reds in different order and prize not published yet
+	b.Number = []uint8{6, 5, 4, 3, 2, 1, 7}
+	b.SalesVolume, b.Pieces = 0, nil
+
+	r, err := Reconcile(context.Background(), 18077, &stubFetcher{name: "a", award: a}, &stubFetcher{name: "b", award: b})
+	p.NoError(err)
+	p.False(r.Flagged())
+	p.Equal([2]string{"a", "b"}, r.Sources)
+}
+
+func (p *reconcileTestSuite) TestReconcileFlagged() {
+	a := newCompleteAward()
+	b := newCompleteAward()
+	b.Number[6] = 8
+	b.Pieces[1].Count = 101
+
+	r, err := Reconcile(context.Background(), 18077, &stubFetcher{name: "a", award: a}, &stubFetcher{name: "b", award: b})
+	p.NoError(err)
+	p.True(r.Flagged())
+	p.Equal([]Diff{
+		{Field: "number", A: "01 02 03 04 05 06 + 07", B: "01 02 03 04 05 06 + 08"},
+		{Field: "pieces[2].count", A: "100", B: "101"},
+	}, r.Diffs)
+
+	// the invalid data is flagged even if the sources agree
+	b = newCompleteAward()
+	b.Number[0] = 34
+	r, err = Reconcile(context.Background(), 18077, &stubFetcher{name: "a", award: b}, &stubFetcher{name: "b", award: b})
+	p.NoError(err)
+	p.True(r.Flagged())
+	p.Empty(r.Diffs)
+	p.Len(r.Problems[0], 1)
+}
+
+func (p *reconcileTestSuite) TestReconcileFetchFailed() {
+	_, err := Reconcile(context.Background(), 18077,
+		&stubFetcher{name: "a", award: newCompleteAward()},
+		&stubFetcher{name: "b", err: errors.New("timeout")})
+	p.Error(err)
+}
+
+func TestReconcileTestSuite(t *testing.T) {
+	p := &reconcileTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DrawHour 是开奖时间 (北京时间 21:15)
+	DrawHour = 21
+	// DrawMinute 是开奖时间的分钟
+	DrawMinute = 15
+)
+
+var (
+	// DrawLocation 是开奖时区 (北京时间)
+	DrawLocation = time.FixedZone("CST", 8*60*60)
+)
+
+// Suspension is the draw days from From to To (both included, in DrawDate format) which are suspended,
+// such as the Spring Festival
+type Suspension struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Contains return whether the draw date of t is within the suspension
+func (s Suspension) Contains(t time.Time) bool {
+	d := DrawDate(t)
+	return !d.Before(s.From) && !d.After(s.To)
+}
+
+// ParseSuspension parse the suspension like "2018-02-15~2018-02-21"
+func ParseSuspension(s string) (Suspension, error) {
+	v := strings.SplitN(s, "~", 2)
+	if len(v) != 2 {
+		return Suspension{}, fmt.Errorf("suspension[%s] should be from~to", s)
+	}
+	from, err := time.Parse("2006-01-02", strings.TrimSpace(v[0]))
+	if err != nil {
+		return Suspension{}, fmt.Errorf("suspension[%s] is invalid: %s", s, err)
+	}
+	to, err := time.Parse("2006-01-02", strings.TrimSpace(v[1]))
+	if err != nil {
+		return Suspension{}, fmt.Errorf("suspension[%s] is invalid: %s", s, err)
+	}
+	if to.Before(from) {
+		return Suspension{}, fmt.Errorf("suspension[%s] ends before it starts", s)
+	}
+	return Suspension{From: from, To: to}, nil
+}
+
+// defaultSuspensions 是已公布的春节休市期间
+var defaultSuspensions = []Suspension{
+	{From: time.Date(2017, 1, 27, 0, 0, 0, 0, time.UTC), To: time.Date(2017, 2, 2, 0, 0, 0, 0, time.UTC)},
+	{From: time.Date(2018, 2, 15, 0, 0, 0, 0, time.UTC), To: time.Date(2018, 2, 21, 0, 0, 0, 0, time.UTC)},
+	{From: time.Date(2019, 2, 4, 0, 0, 0, 0, time.UTC), To: time.Date(2019, 2, 10, 0, 0, 0, 0, time.UTC)},
+}
+
+var (
+	suspensionsMu sync.RWMutex
+	// suspensions is the configured suspensions besides the default ones
+	suspensions []Suspension
+)
+
+// SetSuspensions will set the suspensions which are not known by default
+func SetSuspensions(v []Suspension) {
+	suspensionsMu.Lock()
+	defer suspensionsMu.Unlock()
+
+	suspensions = append([]Suspension(nil), v...)
+}
+
+// IsSuspended return whether the draw at the day of t is suspended
+func IsSuspended(t time.Time) bool {
+	for _, s := range defaultSuspensions {
+		if s.Contains(t) {
+			return true
+		}
+	}
+
+	suspensionsMu.RLock()
+	defer suspensionsMu.RUnlock()
+	for _, s := range suspensions {
+		if s.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDrawDay return whether t is draw day (每周二、四、日) in DrawLocation.
+// The suspension is not considered, see IsSuspended.
+func IsDrawDay(t time.Time) bool {
+	switch t.In(DrawLocation).Weekday() {
+	case time.Tuesday, time.Thursday, time.Sunday:
+		return true
+	}
+	return false
+}
+
+// isScheduled return whether the draw is held at the day of t
+func isScheduled(t time.Time) bool {
+	return IsDrawDay(t) && !IsSuspended(t)
+}
+
+// drawTimeOf return the draw time at the day of t in DrawLocation
+func drawTimeOf(t time.Time) time.Time {
+	t = t.In(DrawLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), DrawHour, DrawMinute, 0, 0, DrawLocation)
+}
+
+// PrevDraw return the latest draw time not after t, the suspended draws are skipped
+func PrevDraw(t time.Time) time.Time {
+	v := drawTimeOf(t)
+	if v.After(t) {
+		v = v.AddDate(0, 0, -1)
+	}
+	for !isScheduled(v) {
+		v = v.AddDate(0, 0, -1)
+	}
+	return v
+}
+
+// NextDraw return the earliest draw time after t, the suspended draws are skipped
+func NextDraw(t time.Time) time.Time {
+	v := drawTimeOf(t)
+	if !v.After(t) {
+		v = v.AddDate(0, 0, 1)
+	}
+	for !isScheduled(v) {
+		v = v.AddDate(0, 0, 1)
+	}
+	return v
+}
+
+// DrawDate return the draw date like Award.AwardOpenDate of the draw time t
+func DrawDate(t time.Time) time.Time {
+	t = t.In(DrawLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
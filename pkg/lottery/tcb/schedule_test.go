@@ -0,0 +1,88 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type scheduleTestSuite struct {
+	suite.Suite
+}
+
+func (p *scheduleTestSuite) at(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, DrawLocation)
+	p.NoError(err)
+	return t
+}
+
+func (p *scheduleTestSuite) TestIsDrawDay() {
+	p.True(IsDrawDay(p.at("2018-07-01 10:00")))  // sunday
+	p.False(IsDrawDay(p.at("2018-07-02 10:00"))) // monday
+	p.True(IsDrawDay(p.at("2018-07-03 10:00")))  // tuesday
+	p.True(IsDrawDay(p.at("2018-07-05 10:00")))  // thursday
+	p.False(IsDrawDay(p.at("2018-07-06 10:00"))) // friday
+
+	// 2018-07-02 20:00 UTC is tuesday in Beijing
+	p.True(IsDrawDay(time.Date(2018, 7, 2, 20, 0, 0, 0, time.UTC)))
+}
+
+func (p *scheduleTestSuite) TestPrevDraw() {
+	p.Equal(p.at("2018-07-01 21:15"), PrevDraw(p.at("2018-07-03 21:14")))
+	p.Equal(p.at("2018-07-03 21:15"), PrevDraw(p.at("2018-07-03 21:15")))
+	p.Equal(p.at("2018-07-05 21:15"), PrevDraw(p.at("2018-07-08 09:00")))
+}
+
+func (p *scheduleTestSuite) TestNextDraw() {
+	p.Equal(p.at("2018-07-03 21:15"), NextDraw(p.at("2018-07-03 21:14")))
+	p.Equal(p.at("2018-07-05 21:15"), NextDraw(p.at("2018-07-03 21:15")))
+	p.Equal(p.at("2018-07-08 21:15"), NextDraw(p.at("2018-07-06 09:00")))
+}
+
+func (p *scheduleTestSuite) TestSuspension() {
+	// the Spring Festival of 2018
+	p.True(IsSuspended(p.at("2018-02-15 21:15")))
+	p.True(IsSuspended(p.at("2018-02-21 23:00")))
+	p.False(IsSuspended(p.at("2018-02-22 21:15")))
+	p.Equal(p.at("2018-02-13 21:15"), PrevDraw(p.at("2018-02-20 09:00")))
+	p.Equal(p.at("2018-02-22 21:15"), NextDraw(p.at("2018-02-13 21:15")))
+
+	s, err := ParseSuspension("2020-01-24~2020-01-30")
+	p.NoError(err)
+	p.Equal(time.Date(2020, 1, 24, 0, 0, 0, 0, time.UTC), s.From)
+	p.Equal(time.Date(2020, 1, 30, 0, 0, 0, 0, time.UTC), s.To)
+	_, err = ParseSuspension("2020-01-24")
+	p.Error(err)
+	_, err = ParseSuspension("2020-01-30~2020-01-24")
+	p.Error(err)
+
+	p.False(IsSuspended(p.at("2020-01-26 21:15")))
+	SetSuspensions([]Suspension{s})
+	defer SetSuspensions(nil)
+	p.True(IsSuspended(p.at("2020-01-26 21:15")))
+	p.Equal(p.at("2020-02-02 21:15"), NextDraw(p.at("2020-01-23 21:15")))
+}
+
+func (p *scheduleTestSuite) TestDrawDate() {
+	p.Equal(time.Date(2018, 7, 3, 0, 0, 0, 0, time.UTC), DrawDate(p.at("2018-07-03 21:15")))
+}
+
+func TestScheduleTestSuite(t *testing.T) {
+	p := &scheduleTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/lsytj0413/tyche/pkg/ierror"
+)
+
+const (
+	// MaxRed 是红球最大号码
+	MaxRed = 33
+	// MaxBlue 是蓝球最大号码
+	MaxBlue = 16
+)
+
+// NoAward 是未中奖
+const NoAward = AwardLevel(0)
+
+// Ticket 是一注单式投注号码
+type Ticket struct {
+	Reds []uint8 `json:"reds"`
+	Blue uint8   `json:"blue"`
+}
+
+var ticketRegexp = regexp.MustCompile(`^([0-9]{1,2})[ ,，]+([0-9]{1,2})[ ,，]+([0-9]{1,2})[ ,，]+([0-9]{1,2})[ ,，]+([0-9]{1,2})[ ,，]+([0-9]{1,2})[ ]*[+＋|][ ]*([0-9]{1,2})$`)
+
+// ParseTicket will parse ticket from string like "01 02 03 04 05 06 + 07"
+func ParseTicket(s string) (*Ticket, error) {
+	v := ticketRegexp.FindStringSubmatch(s)
+	if len(v) != 2+RedCount {
+		return nil, ierror.NewError(ierror.EcodeInvalidBet, fmt.Sprintf("ticket[%s] is unexpected format", s))
+	}
+
+	t := &Ticket{
+		Reds: make([]uint8, RedCount),
+	}
+	for i := 0; i < RedCount; i++ {
+		n, _ := strconv.Atoi(v[i+1])
+		t.Reds[i] = uint8(n)
+	}
+	n, _ := strconv.Atoi(v[RedCount+1])
+	t.Blue = uint8(n)
+	sort.Slice(t.Reds, func(i, j int) bool {
+		return t.Reds[i] < t.Reds[j]
+	})
+
+	if err := t.Validate(); err != nil {
+		return nil, ierror.Wrap(ierror.EcodeInvalidBet, err)
+	}
+	return t, nil
+}
+
+// Validate will check the ticket has 6 distinct reds in 1-33 and blue in 1-16
+func (t *Ticket) Validate() error {
+	if len(t.Reds) != RedCount {
+		return fmt.Errorf("ticket red count[%d] doesnot equal %d", len(t.Reds), RedCount)
+	}
+
+	seen := make(map[uint8]bool, RedCount)
+	for _, n := range t.Reds {
+		if n < 1 || n > MaxRed {
+			return fmt.Errorf("ticket red[%d] out of range 1-%d", n, MaxRed)
+		}
+		if seen[n] {
+			return fmt.Errorf("ticket red[%d] duplicated", n)
+		}
+		seen[n] = true
+	}
+	if t.Blue < 1 || t.Blue > MaxBlue {
+		return fmt.Errorf("ticket blue[%d] out of range 1-%d", t.Blue, MaxBlue)
+	}
+
+	return nil
+}
+
+// String return the ticket like "01 02 03 04 05 06 + 07"
+func (t *Ticket) String() string {
+	return formatNumber(t.Reds, t.Blue)
+}
+
+// Check will return the award level and hit count of the ticket at award
+func (t *Ticket) Check(award *Award) (level AwardLevel, redHits int, blueHit bool) {
+	reds := make(map[uint8]bool, RedCount)
+	for _, n := range award.Reds() {
+		reds[n] = true
+	}
+	for _, n := range t.Reds {
+		if reds[n] {
+			redHits++
+		}
+	}
+	blueHit = t.Blue == award.Blue()
+
+	switch {
+	case redHits == 6 && blueHit:
+		level = FirstAward
+	case redHits == 6:
+		level = SecondAward
+	case redHits == 5 && blueHit:
+		level = ThirdAward
+	case redHits == 5 || (redHits == 4 && blueHit):
+		level = FourthAward
+	case redHits == 4 || (redHits == 3 && blueHit):
+		level = FifthAward
+	case blueHit:
+		level = SixthAward
+	default:
+		level = NoAward
+	}
+	return
+}
+
+var levelNames = map[AwardLevel]string{
+	NoAward:     "未中奖",
+	FirstAward:  "一等奖",
+	SecondAward: "二等奖",
+	ThirdAward:  "三等奖",
+	FourthAward: "四等奖",
+	FifthAward:  "五等奖",
+	SixthAward:  "六等奖",
+}
+
+// String return the chinese name of award level
+func (l AwardLevel) String() string {
+	if v, ok := levelNames[l]; ok {
+		return v
+	}
+	return fmt.Sprintf("AwardLevel(%d)", uint8(l))
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ticketTestSuite struct {
+	suite.Suite
+}
+
+func (p *ticketTestSuite) TestParseTicketOk() {
+	for _, s := range []string{
+		"01 02 03 04 05 06 + 07",
+		"6 5 4 3 2 1+7",
+		"01,02,03,04,05,06+07",
+		"01，02，03，04，05，06＋07",
+	} {
+		t, err := ParseTicket(s)
+		p.NoError(err, s)
+		p.Equal("01 02 03 04 05 06 + 07", t.String())
+	}
+}
+
+func (p *ticketTestSuite) TestParseTicketInvalid() {
+	for _, s := range []string{
+		"",
+		"01 02 03 04 05 + 07",
+		"01 02 03 04 05 06 07",
+		"01 01 03 04 05 06 + 07",
+		"01 02 03 04 05 34 + 07",
+		"00 02 03 04 05 06 + 07",
+		"01 02 03 04 05 06 + 17",
+		"01 02 03 04 05 06 + 00",
+	} {
+		_, err := ParseTicket(s)
+		p.Error(err, s)
+	}
+}
+
+func (p *ticketTestSuite) TestCheckOk() {
+	award := &Award{
+		Number: []uint8{1, 2, 3, 4, 5, 6, 7},
+	}
+	testcases := []struct {
+		reds  []uint8
+		blue  uint8
+		level AwardLevel
+	}{
+		{[]uint8{1, 2, 3, 4, 5, 6}, 7, FirstAward},
+		{[]uint8{1, 2, 3, 4, 5, 6}, 8, SecondAward},
+		{[]uint8{1, 2, 3, 4, 5, 16}, 7, ThirdAward},
+		{[]uint8{1, 2, 3, 4, 5, 16}, 8, FourthAward},
+		{[]uint8{1, 2, 3, 4, 15, 16}, 7, FourthAward},
+		{[]uint8{1, 2, 3, 4, 15, 16}, 8, FifthAward},
+		{[]uint8{1, 2, 3, 14, 15, 16}, 7, FifthAward},
+		{[]uint8{1, 2, 3, 14, 15, 16}, 8, NoAward},
+		{[]uint8{1, 2, 13, 14, 15, 16}, 7, SixthAward},
+		{[]uint8{11, 12, 13, 14, 15, 16}, 7, SixthAward},
+		{[]uint8{11, 12, 13, 14, 15, 16}, 8, NoAward},
+	}
+
+	for _, tc := range testcases {
+		t := &Ticket{Reds: tc.reds, Blue: tc.blue}
+		level, _, _ := t.Check(award)
+		p.Equal(tc.level, level, t.String())
+	}
+}
+
+func TestTicketTestSuite(t *testing.T) {
+	p := &ticketTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DeadlineDays 是兑奖期限, 自开奖之日起 60 天
+	DeadlineDays = 60
+	// MaxDeadlineExtensionDays 是兑奖截止日遇法定节假日顺延的最大天数
+	MaxDeadlineExtensionDays = 15
+
+	// MaxTermSequence 是每年最大期号, 每周 3 期
+	MaxTermSequence = 160
+	// FirstTermYear 是双色球开始发行的年份 (2003)
+	FirstTermYear = 3
+
+	// MaxFirstBonus 是一等奖单注奖金上限 (奖池超过 1 亿元时)
+	MaxFirstBonus = 10000000
+)
+
+// fixedBonus 是三至六等奖的固定奖金
+var fixedBonus = map[AwardLevel]uint32{
+	ThirdAward:  3000,
+	FourthAward: 200,
+	FifthAward:  10,
+	SixthAward:  5,
+}
+
+// ValidationError 是开奖结果违反的规则
+type ValidationError struct {
+	Term     uint32
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("award of term[%05d] is invalid: %s", e.Term, strings.Join(e.Problems, "; "))
+}
+
+// Validate will check award for the rules, return *ValidationError with all problems found.
+// The bonus, count and deadline not published yet or by the source (zero) are not checked.
+func Validate(award *Award) error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	year, seq := award.Term/1000, award.Term%1000
+	if year < FirstTermYear || seq < 1 || seq > MaxTermSequence {
+		addf("term[%05d] is not format yyNNN", award.Term)
+	} else if !award.AwardOpenDate.IsZero() && uint32(award.AwardOpenDate.Year()%100) != year {
+		addf("term[%05d] year doesnot match open date[%s]", award.Term, award.AwardOpenDate.Format("2006-01-02"))
+	}
+
+	if len(award.Number) != RedCount+1 {
+		addf("number count[%d] doesnot equal %d", len(award.Number), RedCount+1)
+	} else {
+		t := &Ticket{Reds: award.Reds(), Blue: award.Blue()}
+		if err := t.Validate(); err != nil {
+			addf("number: %v", err)
+		}
+	}
+
+	if award.AwardOpenDate.IsZero() {
+		addf("open date is empty")
+	} else {
+		if !IsDrawDay(award.AwardOpenDate) {
+			addf("open date[%s] is not draw day", award.AwardOpenDate.Format("2006-01-02"))
+		}
+		// the deadline is postponed if it is in statutory holidays
+		deadline := award.AwardOpenDate.AddDate(0, 0, DeadlineDays)
+		if !award.DeadlineDate.IsZero() &&
+			(award.DeadlineDate.Before(deadline) || award.DeadlineDate.After(deadline.AddDate(0, 0, MaxDeadlineExtensionDays))) {
+			addf("deadline[%s] is not within open date + %d~%d days", award.DeadlineDate.Format("2006-01-02"),
+				DeadlineDays, DeadlineDays+MaxDeadlineExtensionDays)
+		}
+	}
+
+	problems = append(problems, validatePieces(award.Pieces)...)
+	if len(problems) > 0 {
+		return &ValidationError{Term: award.Term, Problems: problems}
+	}
+	return nil
+}
+
+// Warnings return the unusual but possible data of award, which should be reviewed but not rejected:
+// the winners count is decreasing with level
+func Warnings(award *Award) []string {
+	var warnings []string
+	var prev *Piece
+	for i := range award.Pieces {
+		p := &award.Pieces[i]
+		if p.Count == 0 {
+			continue
+		}
+		if prev != nil && prev.Count > p.Count {
+			warnings = append(warnings, fmt.Sprintf("%s count[%d] is less than %s count[%d]", p.Level, p.Count, prev.Level, prev.Count))
+		}
+		prev = p
+	}
+	return warnings
+}
+
+// validatePieces check the levels are 1-6 in order, the fixed bonus and the float bonus range
+func validatePieces(pieces []Piece) []string {
+	var problems []string
+	if len(pieces) == 0 {
+		return problems
+	}
+	if len(pieces) != int(SixthAward) {
+		return append(problems, fmt.Sprintf("pieces count[%d] doesnot equal %d", len(pieces), SixthAward))
+	}
+
+	for i := range pieces {
+		p := &pieces[i]
+		if p.Level != AwardLevel(i+1) {
+			problems = append(problems, fmt.Sprintf("piece[%d] level[%d] is out of order", i, p.Level))
+			continue
+		}
+
+		if v, ok := fixedBonus[p.Level]; ok {
+			if p.Bonus != 0 && p.Bonus != v {
+				problems = append(problems, fmt.Sprintf("%s bonus[%d] doesnot equal %d", p.Level, p.Bonus, v))
+			}
+		} else if p.Bonus > MaxFirstBonus {
+			problems = append(problems, fmt.Sprintf("%s bonus[%d] exceeds %d", p.Level, p.Bonus, MaxFirstBonus))
+		}
+	}
+
+	first, second := &pieces[0], &pieces[1]
+	if first.Bonus > 0 && second.Bonus > first.Bonus {
+		problems = append(problems, fmt.Sprintf("%s bonus[%d] exceeds %s bonus[%d]", second.Level, second.Bonus, first.Level, first.Bonus))
+	}
+	return problems
+}
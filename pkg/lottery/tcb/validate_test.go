@@ -0,0 +1,98 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type validateTestSuite struct {
+	suite.Suite
+}
+
+func (p *validateTestSuite) TestValidateOk() {
+	p.NoError(Validate(newCompleteAward()))
+
+	// the award which bonus is not published yet
+	v := newCompleteAward()
+	v.SalesVolume, v.Pieces = 0, nil
+	p.NoError(Validate(v))
+
+	// the deadline 2018-02-17 is in spring festival holidays, postponed to 2018-02-22
+	v = newCompleteAward()
+	v.Term = 17150
+	v.AwardOpenDate = time.Date(2017, 12, 19, 0, 0, 0, 0, time.UTC)
+	v.DeadlineDate = time.Date(2018, 2, 22, 0, 0, 0, 0, time.UTC)
+	p.NoError(Validate(v))
+}
+
+func (p *validateTestSuite) TestValidateInvalid() {
+	testCases := []struct {
+		desc   string
+		modify func(a *Award)
+	}{
+		{"term sequence", func(a *Award) { a.Term = 18000 }},
+		{"term year", func(a *Award) { a.Term = 17077 }},
+		{"number count", func(a *Award) { a.Number = a.Number[:6] }},
+		{"red range", func(a *Award) { a.Number[0] = 34 }},
+		{"red duplicated", func(a *Award) { a.Number[1] = 1 }},
+		{"blue range", func(a *Award) { a.Number[6] = 17 }},
+		{"open date weekday", func(a *Award) {
+			a.AwardOpenDate = a.AwardOpenDate.AddDate(0, 0, 1)
+			a.DeadlineDate = a.DeadlineDate.AddDate(0, 0, 1)
+		}},
+		{"deadline early", func(a *Award) { a.DeadlineDate = a.DeadlineDate.AddDate(0, 0, -1) }},
+		{"deadline late", func(a *Award) { a.DeadlineDate = a.DeadlineDate.AddDate(0, 0, MaxDeadlineExtensionDays+1) }},
+		{"pieces count", func(a *Award) { a.Pieces = a.Pieces[:5] }},
+		{"pieces order", func(a *Award) { a.Pieces[0].Level, a.Pieces[1].Level = SecondAward, FirstAward }},
+		{"fixed bonus", func(a *Award) { a.Pieces[2].Bonus = 2000 }},
+		{"first bonus", func(a *Award) { a.Pieces[0].Count, a.Pieces[0].Bonus = 1, MaxFirstBonus+1 }},
+		{"second bonus", func(a *Award) { a.Pieces[0].Count, a.Pieces[0].Bonus = 1, 100000 }},
+	}
+	for _, tc := range testCases {
+		v := newCompleteAward()
+		tc.modify(v)
+		err := Validate(v)
+		p.Error(err, tc.desc)
+		p.IsType(&ValidationError{}, err, tc.desc)
+	}
+
+	v := newCompleteAward()
+	v.Number[0], v.DeadlineDate = 34, v.DeadlineDate.AddDate(0, 0, -1)
+	p.Len(Validate(v).(*ValidationError).Problems, 2)
+
+	// the deadline is not published by source
+	v = newCompleteAward()
+	v.DeadlineDate = time.Time{}
+	p.NoError(Validate(v))
+}
+
+func (p *validateTestSuite) TestWarnings() {
+	p.Empty(Warnings(newCompleteAward()))
+
+	// less winners of lower level is unusual, but not against the rules
+	v := newCompleteAward()
+	v.Pieces[4].Count = 10
+	p.NoError(Validate(v))
+	p.Equal([]string{"五等奖 count[10] is less than 四等奖 count[50000]"}, Warnings(v))
+}
+
+func TestValidateTestSuite(t *testing.T) {
+	p := &validateTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify push the draw result to wechat subscribers
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/wechat/client"
+)
+
+const (
+	defaultWorkers     = 4
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+	// the deliveries of older terms are pruned, only the recent ones are checked for resend
+	defaultKeepTerms = 10
+)
+
+// errcode which the message will never be delivered
+var permanentErrCodes = map[int]bool{
+	40003: true, // invalid openid
+	40037: true, // invalid template_id
+	43004: true, // require subscribe
+}
+
+// Sender send the template message to wechat user
+type Sender interface {
+	SendTemplateMessage(msg *client.TemplateMessage) (int64, error)
+}
+
+// TicketChecker return the result of tracked tickets of openid at award, one line per ticket
+type TicketChecker func(openid string, award *tcb.Award) []string
+
+// Notifier fan out the draw result to all subscribers
+type Notifier struct {
+	st         *store.Store
+	sender     Sender
+	templateID string
+	checker    TicketChecker
+
+	interval    time.Duration
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// New will construct a Notifier instance, at most rate messages are sent per second
+func New(st *store.Store, sender Sender, templateID string, rate int) *Notifier {
+	if rate <= 0 {
+		rate = 1
+	}
+
+	return &Notifier{
+		st:          st,
+		sender:      sender,
+		templateID:  templateID,
+		interval:    time.Second / time.Duration(rate),
+		workers:     defaultWorkers,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+	}
+}
+
+// SetTicketChecker will set the checker which fill the tracked tickets result of subscriber
+func (n *Notifier) SetTicketChecker(checker TicketChecker) {
+	n.checker = checker
+}
+
+// Notify will push award to all subscribers, the subscribers already sent at the term are skipped.
+// It blocks until all deliveries are finished or ctx is done, then the deliveries of old terms are pruned.
+func (n *Notifier) Notify(ctx context.Context, award *tcb.Award) error {
+	subscribers := n.st.Subscribers()
+	logger.Infof("Notify award term[%d] to %d subscribers", award.Term, len(subscribers))
+
+	limiter := time.NewTicker(n.interval)
+	defer limiter.Stop()
+
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < n.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for openid := range ch {
+				n.deliver(ctx, limiter.C, award, openid)
+			}
+		}()
+	}
+
+loop:
+	for _, subscriber := range subscribers {
+		if d, ok := n.st.Delivery(award.Term, subscriber.OpenID); ok && d.Status == store.DeliverySent {
+			continue
+		}
+
+		select {
+		case ch <- subscriber.OpenID:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if count, err := n.st.PruneDeliveries(defaultKeepTerms); err != nil {
+		logger.Errorf("Prune deliveries failed: %s", err)
+	} else if count > 0 {
+		logger.Infof("Prune %d deliveries of old terms", count)
+	}
+	return ctx.Err()
+}
+
+func (n *Notifier) deliver(ctx context.Context, limiter <-chan time.Time, award *tcb.Award, openid string) {
+	d, ok := n.st.Delivery(award.Term, openid)
+	if !ok {
+		d = store.Delivery{
+			Term:   award.Term,
+			OpenID: openid,
+		}
+	}
+	d.Status = store.DeliveryPending
+
+	msg := n.message(openid, award)
+	backoff := n.backoff
+	for attempt := 0; attempt < n.maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				n.save(&d)
+				return
+			}
+			backoff *= 2
+		}
+
+		select {
+		case <-limiter:
+		case <-ctx.Done():
+			n.save(&d)
+			return
+		}
+
+		d.Attempts++
+		msgID, err := n.sender.SendTemplateMessage(msg)
+		if err == nil {
+			d.Status = store.DeliverySent
+			d.MsgID = msgID
+			d.LastError = ""
+			n.save(&d)
+			return
+		}
+
+		logger.Debugf("Send award term[%d] to %s failed: %s", award.Term, openid, err)
+		d.LastError = err.Error()
+		if !isRetryable(err) {
+			break
+		}
+		n.save(&d)
+	}
+
+	d.Status = store.DeliveryFailed
+	n.save(&d)
+}
+
+func (n *Notifier) save(d *store.Delivery) {
+	if err := n.st.SaveDelivery(d); err != nil {
+		logger.Errorf("Save delivery term[%d] of %s failed: %s", d.Term, d.OpenID, err)
+	}
+}
+
+func isRetryable(err error) bool {
+	if apiErr, ok := err.(*client.Error); ok {
+		return !permanentErrCodes[apiErr.ErrCode]
+	}
+	return true
+}
+
+func (n *Notifier) message(openid string, award *tcb.Award) *client.TemplateMessage {
+	remark := "回复「我的号码」查看您的号码中奖情况"
+	if n.checker != nil {
+		if lines := n.checker(openid, award); len(lines) != 0 {
+			remark = strings.Join(lines, "\n")
+		}
+	}
+
+	return &client.TemplateMessage{
+		ToUser:     openid,
+		TemplateID: n.templateID,
+		Data: map[string]client.TemplateData{
+			"first":    {Value: fmt.Sprintf("双色球第 %05d 期开奖结果", award.Term)},
+			"keyword1": {Value: award.AwardOpenDate.Format("2006-01-02")},
+			"keyword2": {Value: award.NumberString(), Color: "#FF0000"},
+			"keyword3": {Value: fmt.Sprintf("%d 元", award.RemainBonus)},
+			"remark":   {Value: remark},
+		},
+	}
+}
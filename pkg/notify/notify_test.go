@@ -0,0 +1,152 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/wechat/client"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []*client.TemplateMessage
+	errs     map[string][]error
+}
+
+func (f *fakeSender) SendTemplateMessage(msg *client.TemplateMessage) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if errs := f.errs[msg.ToUser]; len(errs) != 0 {
+		f.errs[msg.ToUser] = errs[1:]
+		return 0, errs[0]
+	}
+	f.messages = append(f.messages, msg)
+	return int64(len(f.messages)), nil
+}
+
+type notifyTestSuite struct {
+	suite.Suite
+
+	st     *store.Store
+	sender *fakeSender
+	n      *Notifier
+	award  *tcb.Award
+}
+
+func (p *notifyTestSuite) SetupTest() {
+	var err error
+	p.st, err = store.Open("")
+	p.NoError(err)
+	for _, openid := range []string{"u1", "u2", "u3"} {
+		_, err = p.st.AddSubscriber(openid)
+		p.NoError(err)
+	}
+
+	p.sender = &fakeSender{errs: make(map[string][]error)}
+	p.n = New(p.st, p.sender, "template", 1000)
+	p.n.backoff = time.Millisecond
+	p.award = &tcb.Award{
+		Term:        18077,
+		Number:      []uint8{3, 7, 12, 19, 25, 31, 9},
+		RemainBonus: 800000000,
+	}
+}
+
+func (p *notifyTestSuite) TestNotifyOk() {
+	p.n.SetTicketChecker(func(openid string, award *tcb.Award) []string {
+		if openid == "u1" {
+			return []string{"01 02 03 04 05 06 + 07 未中奖"}
+		}
+		return nil
+	})
+
+	p.NoError(p.n.Notify(context.Background(), p.award))
+	p.Len(p.sender.messages, 3)
+	for _, msg := range p.sender.messages {
+		p.Equal("template", msg.TemplateID)
+		p.Equal("03 07 12 19 25 31 + 09", msg.Data["keyword2"].Value)
+		if msg.ToUser == "u1" {
+			p.Equal("01 02 03 04 05 06 + 07 未中奖", msg.Data["remark"].Value)
+		}
+	}
+
+	for _, d := range p.st.Deliveries(18077) {
+		p.Equal(store.DeliverySent, d.Status)
+		p.Equal(1, d.Attempts)
+	}
+}
+
+func (p *notifyTestSuite) TestNotifyRetry() {
+	p.sender.errs["u1"] = []error{errors.New("timeout"), &client.Error{ErrCode: 45009}}
+	p.sender.errs["u2"] = []error{&client.Error{ErrCode: 43004, ErrMsg: "require subscribe"}}
+
+	p.NoError(p.n.Notify(context.Background(), p.award))
+	p.Len(p.sender.messages, 2)
+
+	d, ok := p.st.Delivery(18077, "u1")
+	p.True(ok)
+	p.Equal(store.DeliverySent, d.Status)
+	p.Equal(3, d.Attempts)
+
+	d, ok = p.st.Delivery(18077, "u2")
+	p.True(ok)
+	p.Equal(store.DeliveryFailed, d.Status)
+	p.Equal(1, d.Attempts)
+	p.Contains(d.LastError, "43004")
+}
+
+func (p *notifyTestSuite) TestNotifySkipSent() {
+	p.NoError(p.st.SaveDelivery(&store.Delivery{Term: 18077, OpenID: "u1", Status: store.DeliverySent}))
+
+	p.NoError(p.n.Notify(context.Background(), p.award))
+	p.Len(p.sender.messages, 2)
+	for _, msg := range p.sender.messages {
+		p.NotEqual("u1", msg.ToUser)
+	}
+}
+
+func (p *notifyTestSuite) TestNotifyPrune() {
+	for i := 0; i < defaultKeepTerms; i++ {
+		p.NoError(p.st.SaveDelivery(&store.Delivery{Term: 18066 + uint32(i), OpenID: "u1", Status: store.DeliverySent}))
+	}
+
+	// the oldest term is pruned, the current term is kept
+	p.NoError(p.n.Notify(context.Background(), p.award))
+	p.Empty(p.st.Deliveries(18066))
+	p.NotEmpty(p.st.Deliveries(18067))
+	p.NotEmpty(p.st.Deliveries(p.award.Term))
+}
+
+func (p *notifyTestSuite) TestNotifyCanceled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p.Equal(context.Canceled, p.n.Notify(ctx, p.award))
+	p.Empty(p.sender.messages)
+}
+
+func TestNotifyTestSuite(t *testing.T) {
+	p := &notifyTestSuite{}
+	suite.Run(t, p)
+}
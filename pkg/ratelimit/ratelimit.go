@@ -0,0 +1,166 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provide the token bucket rate limiter
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket which is refilled at rate tokens per second, up to burst tokens
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// New will construct a Limiter which is full at first
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// refill must be called with l.mu held
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// Allow will take a token if available, otherwise return the duration until the next token
+func (l *Limiter) Allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(l.now())
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, l.wait()
+}
+
+// Available return whether a token is available without taking it, otherwise the duration until the next token
+func (l *Limiter) Available() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(l.now())
+	if l.tokens >= 1 {
+		return true, 0
+	}
+	return false, l.wait()
+}
+
+// wait must be called with l.mu held
+func (l *Limiter) wait() time.Duration {
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Wait will block until a token is taken or ctx done
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		ok, d := l.Allow()
+		if ok {
+			return nil
+		}
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// full return whether the bucket is full at now
+func (l *Limiter) full(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	return l.tokens >= l.burst
+}
+
+// Keyed hold a Limiter for each key, e.g. client or host.
+// The full limiters are removed periodically, it is the same as a new one.
+type Keyed struct {
+	rate  float64
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*Limiter
+	swept    time.Time
+	now      func() time.Time
+}
+
+const sweepInterval = time.Minute
+
+// NewKeyed will construct a Keyed, every key has rate and burst
+func NewKeyed(rate float64, burst int) *Keyed {
+	return &Keyed{
+		rate:     rate,
+		burst:    burst,
+		limiters: make(map[string]*Limiter),
+		now:      time.Now,
+	}
+}
+
+// Get return the Limiter of key
+func (k *Keyed) Get(key string) *Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	if now.Sub(k.swept) >= sweepInterval {
+		for key, l := range k.limiters {
+			if l.full(now) {
+				delete(k.limiters, key)
+			}
+		}
+		k.swept = now
+	}
+
+	l, ok := k.limiters[key]
+	if !ok {
+		l = New(k.rate, k.burst)
+		l.now = k.now
+		k.limiters[key] = l
+	}
+	return l
+}
+
+// Allow will take a token of key, see Limiter.Allow
+func (k *Keyed) Allow(key string) (bool, time.Duration) {
+	return k.Get(key).Allow()
+}
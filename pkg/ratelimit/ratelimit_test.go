@@ -0,0 +1,113 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ratelimitTestSuite struct {
+	suite.Suite
+
+	now time.Time
+}
+
+func (p *ratelimitTestSuite) SetupTest() {
+	p.now = time.Date(2018, 7, 3, 21, 15, 0, 0, time.UTC)
+}
+
+func (p *ratelimitTestSuite) clock() time.Time {
+	return p.now
+}
+
+func (p *ratelimitTestSuite) TestAllow() {
+	l := New(2, 3)
+	l.now = p.clock
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow()
+		p.True(ok)
+	}
+	ok, d := l.Allow()
+	p.False(ok)
+	p.Equal(500*time.Millisecond, d)
+
+	p.now = p.now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow()
+	p.True(ok)
+
+	// refill up to burst
+	p.now = p.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow()
+		p.True(ok)
+	}
+	ok, _ = l.Allow()
+	p.False(ok)
+}
+
+func (p *ratelimitTestSuite) TestAvailable() {
+	l := New(2, 1)
+	l.now = p.clock
+
+	ok, _ := l.Available()
+	p.True(ok)
+	ok, _ = l.Available()
+	p.True(ok)
+
+	ok, _ = l.Allow()
+	p.True(ok)
+	ok, d := l.Available()
+	p.False(ok)
+	p.Equal(500*time.Millisecond, d)
+}
+
+func (p *ratelimitTestSuite) TestWait() {
+	l := New(1000, 1)
+	p.NoError(l.Wait(context.Background()))
+	p.NoError(l.Wait(context.Background()))
+
+	l = New(0.001, 1)
+	p.NoError(l.Wait(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p.Equal(context.DeadlineExceeded, l.Wait(ctx))
+}
+
+func (p *ratelimitTestSuite) TestKeyed() {
+	k := NewKeyed(1, 1)
+	k.now = p.clock
+
+	ok, _ := k.Allow("a")
+	p.True(ok)
+	ok, _ = k.Allow("a")
+	p.False(ok)
+	ok, _ = k.Allow("b")
+	p.True(ok)
+
+	// full limiters are swept
+	p.now = p.now.Add(sweepInterval)
+	k.Get("c")
+	p.Len(k.limiters, 1)
+}
+
+func TestRatelimitTestSuite(t *testing.T) {
+	p := &ratelimitTestSuite{}
+	suite.Run(t, p)
+}
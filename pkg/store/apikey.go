@@ -0,0 +1,74 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// APIKey is the api key created by api, only the sha256 hash of key is kept
+type APIKey struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SaveAPIKey will add the api key, return false if the name already exists
+func (s *Store) SaveAPIKey(k *APIKey) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.APIKeys[k.Name]; ok {
+		return false, nil
+	}
+
+	v := *k
+	v.Scopes = append([]string{}, k.Scopes...)
+	s.data.APIKeys[k.Name] = &v
+	return true, s.flush()
+}
+
+// DeleteAPIKey will remove the api key of name, return false if not exists
+func (s *Store) DeleteAPIKey(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.APIKeys[name]; !ok {
+		return false, nil
+	}
+
+	delete(s.data.APIKeys, name)
+	return true, s.flush()
+}
+
+// APIKeys return all api keys order by name
+func (s *Store) APIKeys() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.data.APIKeys))
+	for _, v := range s.data.APIKeys {
+		k := *v
+		k.Scopes = append([]string{}, v.Scopes...)
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+
+	return keys
+}
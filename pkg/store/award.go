@@ -0,0 +1,100 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+)
+
+// SaveAward will create or update the award at term
+func (s *Store) SaveAward(award *tcb.Award) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := *award
+	s.data.Awards[award.Term] = &v
+	return s.flush()
+}
+
+// Award return the award at term
+func (s *Store) Award(term uint32) (*tcb.Award, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data.Awards[term]
+	if !ok {
+		return nil, false
+	}
+	award := *v
+	return &award, true
+}
+
+// LatestAward return the award with max term
+func (s *Store) LatestAward() (*tcb.Award, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *tcb.Award
+	for _, v := range s.data.Awards {
+		if latest == nil || v.Term > latest.Term {
+			latest = v
+		}
+	}
+	if latest == nil {
+		return nil, false
+	}
+	award := *latest
+	return &award, true
+}
+
+// PublishedTerm return the latest term which the complete award is published, 0 if none
+func (s *Store) PublishedTerm() uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.PublishedTerm
+}
+
+// SetPublishedTerm will mark the award at term is published
+func (s *Store) SetPublishedTerm(term uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if term <= s.data.PublishedTerm {
+		return nil
+	}
+	s.data.PublishedTerm = term
+	return s.flush()
+}
+
+// DrawPublishedTerm return the latest term which the numbers are published, 0 if none
+func (s *Store) DrawPublishedTerm() uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.DrawPublishedTerm
+}
+
+// SetDrawPublishedTerm will mark the numbers of the award at term is published
+func (s *Store) SetDrawPublishedTerm(term uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if term <= s.data.DrawPublishedTerm {
+		return nil
+	}
+	s.data.DrawPublishedTerm = term
+	return s.flush()
+}
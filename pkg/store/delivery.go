@@ -0,0 +1,124 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DeliveryStatus is the status of notification delivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is the delivery which is not sent or retrying
+	DeliveryPending = DeliveryStatus("pending")
+	// DeliverySent is the delivery which is accepted by wechat
+	DeliverySent = DeliveryStatus("sent")
+	// DeliveryFailed is the delivery which is failed after all attempts
+	DeliveryFailed = DeliveryStatus("failed")
+)
+
+// Delivery is the draw result notification delivery of a subscriber at term
+type Delivery struct {
+	Term      uint32         `json:"term"`
+	OpenID    string         `json:"openid"`
+	Status    DeliveryStatus `json:"status"`
+	Attempts  int            `json:"attempts"`
+	MsgID     int64          `json:"msgId,omitempty"`
+	LastError string         `json:"lastError,omitempty"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+func deliveryKey(term uint32, openid string) string {
+	return fmt.Sprintf("%d/%s", term, openid)
+}
+
+// SaveDelivery will create or update the delivery
+func (s *Store) SaveDelivery(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := *d
+	v.UpdatedAt = time.Now()
+	s.data.Deliveries[deliveryKey(d.Term, d.OpenID)] = &v
+	return s.flushLater()
+}
+
+// Delivery return the delivery of openid at term
+func (s *Store) Delivery(term uint32, openid string) (Delivery, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data.Deliveries[deliveryKey(term, openid)]
+	if !ok {
+		return Delivery{}, false
+	}
+	return *v, true
+}
+
+// Deliveries return all deliveries at term order by openid
+func (s *Store) Deliveries(term uint32) []Delivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := make([]Delivery, 0)
+	for _, v := range s.data.Deliveries {
+		if v.Term == term {
+			deliveries = append(deliveries, *v)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].OpenID < deliveries[j].OpenID
+	})
+
+	return deliveries
+}
+
+// PruneDeliveries will remove the deliveries except the latest keep (at least 1) terms, return the count removed
+func (s *Store) PruneDeliveries(keep int) (int, error) {
+	if keep < 1 {
+		keep = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[uint32]bool)
+	terms := make([]uint32, 0)
+	for _, v := range s.data.Deliveries {
+		if !seen[v.Term] {
+			seen[v.Term] = true
+			terms = append(terms, v.Term)
+		}
+	}
+	if len(terms) <= keep {
+		return 0, nil
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i] > terms[j]
+	})
+
+	oldest := terms[keep-1]
+	count := 0
+	for k, v := range s.data.Deliveries {
+		if v.Term < oldest {
+			delete(s.data.Deliveries, k)
+			count++
+		}
+	}
+	return count, s.flush()
+}
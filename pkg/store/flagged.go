@@ -0,0 +1,76 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+)
+
+// FlaggedDraw is the draw which the sources disagree or data is invalid, it is held from subscribers until reviewed
+type FlaggedDraw struct {
+	tcb.Reconciliation
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+// FlagDraw will create or update the flagged draw of the reconciliation term
+func (s *Store) FlagDraw(r *tcb.Reconciliation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.FlaggedDraws[r.Term] = &FlaggedDraw{Reconciliation: *r, FlaggedAt: time.Now()}
+	return s.flush()
+}
+
+// FlaggedDraw return the flagged draw at term
+func (s *Store) FlaggedDraw(term uint32) (FlaggedDraw, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data.FlaggedDraws[term]
+	if !ok {
+		return FlaggedDraw{}, false
+	}
+	return *v, true
+}
+
+// FlaggedDraws return all flagged draws order by term
+func (s *Store) FlaggedDraws() []FlaggedDraw {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	draws := make([]FlaggedDraw, 0, len(s.data.FlaggedDraws))
+	for _, v := range s.data.FlaggedDraws {
+		draws = append(draws, *v)
+	}
+	sort.Slice(draws, func(i, j int) bool {
+		return draws[i].Term < draws[j].Term
+	})
+	return draws
+}
+
+// UnflagDraw will remove the flagged draw at term, return false if not exists
+func (s *Store) UnflagDraw(term uint32) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.FlaggedDraws[term]; !ok {
+		return false, nil
+	}
+	delete(s.data.FlaggedDraws, term)
+	return true, s.flush()
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// OutboxEvent is the event published but not delivered to all handlers yet
+type OutboxEvent struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+	// Delivered is the names of handlers which the event is delivered to
+	Delivered []string `json:"delivered"`
+}
+
+func (e *OutboxEvent) clone() OutboxEvent {
+	v := *e
+	v.Data = append(json.RawMessage(nil), e.Data...)
+	v.Delivered = append([]string(nil), e.Delivered...)
+	return v
+}
+
+// SaveOutboxEvent will add or update the event
+func (s *Store) SaveOutboxEvent(e *OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := e.clone()
+	s.data.Outbox[e.ID] = &v
+	return s.flushLater()
+}
+
+// DeleteOutboxEvent will remove the event of id
+func (s *Store) DeleteOutboxEvent(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Outbox[id]; !ok {
+		return nil
+	}
+	delete(s.data.Outbox, id)
+	return s.flushLater()
+}
+
+// OutboxEvents return the events order by publish time
+func (s *Store) OutboxEvents() []OutboxEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v := make([]OutboxEvent, 0, len(s.data.Outbox))
+	for _, e := range s.data.Outbox {
+		v = append(v, e.clone())
+	}
+	sort.Slice(v, func(i, j int) bool {
+		if !v[i].Time.Equal(v[j].Time) {
+			return v[i].Time.Before(v[j].Time)
+		}
+		return v[i].ID < v[j].ID
+	})
+	return v
+}
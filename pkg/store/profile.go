@@ -0,0 +1,103 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+)
+
+// Bet is a ticket bought at term
+type Bet struct {
+	Term   uint32     `json:"term"`
+	Ticket tcb.Ticket `json:"ticket"`
+}
+
+// Preferences is the user preferences
+type Preferences struct {
+	// NotifyTickets 开奖推送中是否包含我的号码的中奖情况
+	NotifyTickets bool `json:"notifyTickets"`
+}
+
+// Profile is the wechat user profile
+type Profile struct {
+	OpenID      string       `json:"openid"`
+	Favourites  []tcb.Ticket `json:"favourites"`
+	Bets        []Bet        `json:"bets"`
+	Preferences Preferences  `json:"preferences"`
+}
+
+func newProfile(openid string) *Profile {
+	return &Profile{
+		OpenID: openid,
+		Preferences: Preferences{
+			NotifyTickets: true,
+		},
+	}
+}
+
+func (p *Profile) clone() Profile {
+	v := *p
+	v.Favourites = append([]tcb.Ticket(nil), p.Favourites...)
+	v.Bets = append([]Bet(nil), p.Bets...)
+	return v
+}
+
+// Profiles return all saved profiles order by openid
+func (s *Store) Profiles() []Profile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v := make([]Profile, 0, len(s.data.Profiles))
+	for _, p := range s.data.Profiles {
+		v = append(v, p.clone())
+	}
+	sort.Slice(v, func(i, j int) bool {
+		return v[i].OpenID < v[j].OpenID
+	})
+	return v
+}
+
+// Profile return the profile of openid, a default profile is returned if not exists
+func (s *Store) Profile(openid string) Profile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.data.Profiles[openid]
+	if !ok {
+		p = newProfile(openid)
+	}
+	return p.clone()
+}
+
+// UpdateProfile will update the profile of openid by f, the change is discarded if f return error
+func (s *Store) UpdateProfile(openid string, f func(p *Profile) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.data.Profiles[openid]
+	if !ok {
+		p = newProfile(openid)
+	}
+
+	v := p.clone()
+	if err := f(&v); err != nil {
+		return err
+	}
+
+	s.data.Profiles[openid] = &v
+	return s.flush()
+}
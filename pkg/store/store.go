@@ -0,0 +1,228 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package store provide tyche persistent storage
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+)
+
+// batchDelay is the max delay of the batched changes, see flushLater
+const batchDelay = time.Second
+
+// Store is a json file backed storage, all data are kept in memory and flushed to file on every change,
+// except the frequent changes of outbox and deliveries which are batched within batchDelay.
+// A Store with empty path is memory only.
+type Store struct {
+	mu   sync.RWMutex
+	path string
+	data *data
+
+	// dirty is true if the batched changes are not flushed
+	dirty bool
+	timer *time.Timer
+	// batchDelay is the delay of flushLater, 0 to flush at once
+	batchDelay time.Duration
+}
+
+type data struct {
+	Subscribers map[string]*Subscriber  `json:"subscribers"`
+	Deliveries  map[string]*Delivery    `json:"deliveries"`
+	Profiles    map[string]*Profile     `json:"profiles"`
+	Awards      map[uint32]*tcb.Award   `json:"awards"`
+	APIKeys     map[string]*APIKey      `json:"apiKeys"`
+	Outbox      map[string]*OutboxEvent `json:"outbox"`
+	Webhooks    map[string]*Webhook     `json:"webhooks"`
+	// WebhookDeliveries is the delivery log of each webhook id, the oldest first
+	WebhookDeliveries map[string][]WebhookDelivery `json:"webhookDeliveries"`
+	// FlaggedDraws is the draws held by reconciliation of each term
+	FlaggedDraws map[uint32]*FlaggedDraw `json:"flaggedDraws"`
+	// PublishedTerm is the latest term which the complete award is published by draw watcher
+	PublishedTerm uint32 `json:"publishedTerm"`
+	// DrawPublishedTerm is the latest term which the numbers are published by draw watcher
+	DrawPublishedTerm uint32 `json:"drawPublishedTerm"`
+}
+
+func newData() *data {
+	return &data{
+		Subscribers: make(map[string]*Subscriber),
+		Deliveries:  make(map[string]*Delivery),
+		Profiles:    make(map[string]*Profile),
+		Awards:      make(map[uint32]*tcb.Award),
+		APIKeys:     make(map[string]*APIKey),
+		Outbox:      make(map[string]*OutboxEvent),
+		Webhooks:    make(map[string]*Webhook),
+
+		WebhookDeliveries: make(map[string][]WebhookDelivery),
+		FlaggedDraws:      make(map[uint32]*FlaggedDraw),
+	}
+}
+
+// Open will load the Store from path, the file will be created at first flush if not exists
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:       path,
+		data:       newData(),
+		batchDelay: batchDelay,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("store: read %s failed: %v", path, err)
+	}
+
+	if err := json.Unmarshal(content, s.data); err != nil {
+		return nil, fmt.Errorf("store: parse %s failed: %v", path, err)
+	}
+	s.data.fill()
+
+	return s, nil
+}
+
+// fill will init the nil map after unmarshal from older file
+func (d *data) fill() {
+	v := newData()
+	if d.Subscribers == nil {
+		d.Subscribers = v.Subscribers
+	}
+	if d.Deliveries == nil {
+		d.Deliveries = v.Deliveries
+	}
+	if d.Profiles == nil {
+		d.Profiles = v.Profiles
+	}
+	if d.Awards == nil {
+		d.Awards = v.Awards
+	}
+	if d.APIKeys == nil {
+		d.APIKeys = v.APIKeys
+	}
+	if d.Outbox == nil {
+		d.Outbox = v.Outbox
+	}
+	if d.Webhooks == nil {
+		d.Webhooks = v.Webhooks
+	}
+	if d.WebhookDeliveries == nil {
+		d.WebhookDeliveries = v.WebhookDeliveries
+	}
+	if d.FlaggedDraws == nil {
+		d.FlaggedDraws = v.FlaggedDraws
+	}
+}
+
+// flush must be called with s.mu held
+func (s *Store) flush() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("store: create dir failed: %v", err)
+	}
+	// write to temp file then rename, so the file is never half written
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("store: write %s failed: %v", tmp, err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// flushLater will flush within batchDelay, the change is lost if the process crash before it.
+// It is used by the frequent changes which rewriting the file for each is too expensive,
+// must be called with s.mu held
+func (s *Store) flushLater() error {
+	if s.path == "" {
+		return nil
+	}
+	if s.batchDelay <= 0 {
+		return s.flush()
+	}
+
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.batchDelay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.timer = nil
+			if !s.dirty {
+				return
+			}
+			if err := s.flush(); err != nil {
+				logger.Errorf("Flush the batched changes failed: %s", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Close will flush the batched changes, the Store can be used after Close
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.dirty {
+		return nil
+	}
+	return s.flush()
+}
+
+// Ping will check the Store is available: the file can be written
+func (s *Store) Ping() error {
+	if s.path == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("store: create dir failed: %v", err)
+	}
+	f, err := ioutil.TempFile(dir, ".ping")
+	if err != nil {
+		return fmt.Errorf("store: %s is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
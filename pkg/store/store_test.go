@@ -0,0 +1,318 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/stretchr/testify/suite"
+)
+
+type storeTestSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (p *storeTestSuite) SetupTest() {
+	var err error
+	p.dir, err = ioutil.TempDir("", "tyche-store")
+	p.NoError(err)
+}
+
+func (p *storeTestSuite) TearDownTest() {
+	os.RemoveAll(p.dir)
+}
+
+func (p *storeTestSuite) TestPersistOk() {
+	path := filepath.Join(p.dir, "data", "tyche.json")
+	s, err := Open(path)
+	p.NoError(err)
+
+	added, err := s.AddSubscriber("u1")
+	p.NoError(err)
+	p.True(added)
+	added, err = s.AddSubscriber("u1")
+	p.NoError(err)
+	p.False(added)
+	p.NoError(s.SaveDelivery(&Delivery{Term: 18077, OpenID: "u1", Status: DeliverySent}))
+	p.NoError(s.SetPublishedTerm(18077))
+	p.NoError(s.SetPublishedTerm(18076))
+	p.NoError(s.SetDrawPublishedTerm(18078))
+	p.NoError(s.SetDrawPublishedTerm(18077))
+
+	s, err = Open(path)
+	p.NoError(err)
+	p.Equal(uint32(18077), s.PublishedTerm())
+	p.Equal(uint32(18078), s.DrawPublishedTerm())
+	p.True(s.IsSubscribed("u1"))
+	p.Len(s.Subscribers(), 1)
+	d, ok := s.Delivery(18077, "u1")
+	p.True(ok)
+	p.Equal(DeliverySent, d.Status)
+
+	removed, err := s.RemoveSubscriber("u1")
+	p.NoError(err)
+	p.True(removed)
+	p.False(s.IsSubscribed("u1"))
+}
+
+func (p *storeTestSuite) TestPruneDeliveries() {
+	s, err := Open(filepath.Join(p.dir, "tyche.json"))
+	p.NoError(err)
+
+	for _, term := range []uint32{18153, 18154, 19001} {
+		p.NoError(s.SaveDelivery(&Delivery{Term: term, OpenID: "u1", Status: DeliverySent}))
+		p.NoError(s.SaveDelivery(&Delivery{Term: term, OpenID: "u2", Status: DeliveryFailed}))
+	}
+	count, err := s.PruneDeliveries(3)
+	p.NoError(err)
+	p.Equal(0, count)
+
+	count, err = s.PruneDeliveries(2)
+	p.NoError(err)
+	p.Equal(2, count)
+	p.Empty(s.Deliveries(18153))
+	p.Len(s.Deliveries(18154), 2)
+	p.Len(s.Deliveries(19001), 2)
+
+	s, err = Open(filepath.Join(p.dir, "tyche.json"))
+	p.NoError(err)
+	_, ok := s.Delivery(18153, "u1")
+	p.False(ok)
+}
+
+func (p *storeTestSuite) TestFlaggedDraw() {
+	s, err := Open(filepath.Join(p.dir, "tyche.json"))
+	p.NoError(err)
+
+	r := &tcb.Reconciliation{Term: 18078, Sources: [2]string{"a", "b"}, Diffs: []tcb.Diff{{Field: "number", A: "1", B: "2"}}}
+	p.NoError(s.FlagDraw(r))
+	p.NoError(s.FlagDraw(&tcb.Reconciliation{Term: 18077}))
+
+	s, err = Open(filepath.Join(p.dir, "tyche.json"))
+	p.NoError(err)
+	v, ok := s.FlaggedDraw(18078)
+	p.True(ok)
+	p.Equal(*r, v.Reconciliation)
+	p.False(v.FlaggedAt.IsZero())
+	draws := s.FlaggedDraws()
+	p.Len(draws, 2)
+	p.Equal(uint32(18077), draws[0].Term)
+
+	ok, err = s.UnflagDraw(18078)
+	p.NoError(err)
+	p.True(ok)
+	ok, err = s.UnflagDraw(18078)
+	p.NoError(err)
+	p.False(ok)
+	_, ok = s.FlaggedDraw(18078)
+	p.False(ok)
+}
+
+func (p *storeTestSuite) TestProfileOk() {
+	path := filepath.Join(p.dir, "tyche.json")
+	s, err := Open(path)
+	p.NoError(err)
+
+	v := s.Profile("u1")
+	p.True(v.Preferences.NotifyTickets)
+
+	ticket := tcb.Ticket{Reds: []uint8{1, 2, 3, 4, 5, 6}, Blue: 7}
+	p.NoError(s.UpdateProfile("u1", func(v *Profile) error {
+		v.Favourites = append(v.Favourites, ticket)
+		v.Bets = append(v.Bets, Bet{Term: 18078, Ticket: ticket})
+		return nil
+	}))
+	p.Error(s.UpdateProfile("u1", func(v *Profile) error {
+		v.Favourites = nil
+		return errors.New("discard")
+	}))
+
+	s, err = Open(path)
+	p.NoError(err)
+	v = s.Profile("u1")
+	p.Equal([]tcb.Ticket{ticket}, v.Favourites)
+	p.Equal([]Bet{{Term: 18078, Ticket: ticket}}, v.Bets)
+}
+
+func (p *storeTestSuite) TestOpenInvalidFile() {
+	path := filepath.Join(p.dir, "tyche.json")
+	p.NoError(ioutil.WriteFile(path, []byte("{"), 0600))
+
+	_, err := Open(path)
+	p.Error(err)
+}
+
+func (p *storeTestSuite) TestPing() {
+	s, err := Open(filepath.Join(p.dir, "data", "tyche.json"))
+	p.NoError(err)
+	p.NoError(s.Ping())
+
+	// data dir is replaced by a regular file
+	s, err = Open(filepath.Join(p.dir, "file", "tyche.json"))
+	p.NoError(err)
+	p.NoError(ioutil.WriteFile(filepath.Join(p.dir, "file"), []byte{}, 0600))
+	p.Error(s.Ping())
+}
+
+func (p *storeTestSuite) TestAPIKey() {
+	path := filepath.Join(p.dir, "tyche.json")
+	s, err := Open(path)
+	p.NoError(err)
+
+	added, err := s.SaveAPIKey(&APIKey{Name: "b", Hash: "hb", Scopes: []string{"read"}})
+	p.NoError(err)
+	p.True(added)
+	added, err = s.SaveAPIKey(&APIKey{Name: "a", Hash: "ha"})
+	p.NoError(err)
+	p.True(added)
+	added, err = s.SaveAPIKey(&APIKey{Name: "a", Hash: "hc"})
+	p.NoError(err)
+	p.False(added)
+
+	s, err = Open(path)
+	p.NoError(err)
+	keys := s.APIKeys()
+	p.Len(keys, 2)
+	p.Equal("a", keys[0].Name)
+	p.Equal("ha", keys[0].Hash)
+	p.Equal([]string{"read"}, keys[1].Scopes)
+
+	removed, err := s.DeleteAPIKey("a")
+	p.NoError(err)
+	p.True(removed)
+	removed, err = s.DeleteAPIKey("a")
+	p.NoError(err)
+	p.False(removed)
+	p.Len(s.APIKeys(), 1)
+}
+
+func (p *storeTestSuite) TestOutbox() {
+	path := filepath.Join(p.dir, "tyche.json")
+	s, err := Open(path)
+	p.NoError(err)
+
+	now := time.Now().UTC()
+	p.NoError(s.SaveOutboxEvent(&OutboxEvent{ID: "b", Type: "SyncFailed", Time: now, Data: []byte(`{}`)}))
+	p.NoError(s.SaveOutboxEvent(&OutboxEvent{ID: "a", Type: "SyncFailed", Time: now.Add(time.Second), Data: []byte(`{}`)}))
+	p.NoError(s.SaveOutboxEvent(&OutboxEvent{ID: "b", Type: "SyncFailed", Time: now, Data: []byte(`{}`), Delivered: []string{"h"}}))
+	p.NoError(s.Close())
+
+	s, err = Open(path)
+	p.NoError(err)
+	events := s.OutboxEvents()
+	p.Len(events, 2)
+	p.Equal("b", events[0].ID)
+	p.Equal([]string{"h"}, events[0].Delivered)
+	p.Equal("a", events[1].ID)
+
+	p.NoError(s.DeleteOutboxEvent("b"))
+	p.NoError(s.DeleteOutboxEvent("b"))
+	p.Len(s.OutboxEvents(), 1)
+}
+
+func (p *storeTestSuite) TestBatched() {
+	path := filepath.Join(p.dir, "tyche.json")
+	s, err := Open(path)
+	p.NoError(err)
+	s.batchDelay = 20 * time.Millisecond
+
+	p.NoError(s.SaveOutboxEvent(&OutboxEvent{ID: "a", Type: "SyncFailed", Data: []byte(`{}`)}))
+	p.NoError(s.SaveDelivery(&Delivery{Term: 18077, OpenID: "u1", Status: DeliverySent}))
+	_, err = os.Stat(path)
+	p.True(os.IsNotExist(err))
+
+	// the batched changes are flushed together after the delay
+	p.Eventually(func() bool {
+		v, err := Open(path)
+		return err == nil && len(v.OutboxEvents()) == 1
+	}, time.Second, 5*time.Millisecond)
+	v, err := Open(path)
+	p.NoError(err)
+	_, ok := v.Delivery(18077, "u1")
+	p.True(ok)
+
+	// or by Close
+	s.batchDelay = time.Hour
+	p.NoError(s.DeleteOutboxEvent("a"))
+	v, err = Open(path)
+	p.NoError(err)
+	p.Len(v.OutboxEvents(), 1)
+	p.NoError(s.Close())
+	v, err = Open(path)
+	p.NoError(err)
+	p.Empty(v.OutboxEvents())
+	p.NoError(s.Close())
+}
+
+func (p *storeTestSuite) TestWebhook() {
+	path := filepath.Join(p.dir, "tyche.json")
+	s, err := Open(path)
+	p.NoError(err)
+
+	now := time.Now()
+	added, err := s.SaveWebhook(&Webhook{ID: "b", URL: "http://b", Events: []string{"TicketWon"}, CreatedAt: now})
+	p.NoError(err)
+	p.True(added)
+	added, err = s.SaveWebhook(&Webhook{ID: "a", URL: "http://a", CreatedAt: now.Add(time.Second)})
+	p.NoError(err)
+	p.True(added)
+	added, err = s.SaveWebhook(&Webhook{ID: "a", URL: "http://c"})
+	p.NoError(err)
+	p.False(added)
+
+	for i := 1; i <= MaxWebhookDeliveries+1; i++ {
+		p.NoError(s.AddWebhookDelivery(&WebhookDelivery{Webhook: "b", Event: "e", Attempt: i}))
+	}
+	// deliveries of unknown webhook are dropped
+	p.NoError(s.AddWebhookDelivery(&WebhookDelivery{Webhook: "x", Event: "e", Attempt: 1}))
+	p.NoError(s.Close())
+
+	s, err = Open(path)
+	p.NoError(err)
+	hooks := s.Webhooks()
+	p.Len(hooks, 2)
+	p.Equal("b", hooks[0].ID)
+	p.Equal([]string{"TicketWon"}, hooks[0].Events)
+	w, ok := s.Webhook("a")
+	p.True(ok)
+	p.Equal("http://a", w.URL)
+
+	deliveries := s.WebhookDeliveries("b")
+	p.Len(deliveries, MaxWebhookDeliveries)
+	p.Equal(MaxWebhookDeliveries+1, deliveries[0].Attempt)
+	p.Equal(2, deliveries[MaxWebhookDeliveries-1].Attempt)
+	p.Empty(s.WebhookDeliveries("x"))
+
+	removed, err := s.DeleteWebhook("b")
+	p.NoError(err)
+	p.True(removed)
+	removed, err = s.DeleteWebhook("b")
+	p.NoError(err)
+	p.False(removed)
+	p.Empty(s.WebhookDeliveries("b"))
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	p := &storeTestSuite{}
+	suite.Run(t, p)
+}
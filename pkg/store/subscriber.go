@@ -0,0 +1,80 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// Subscriber is wechat user who opted in the draw result notification
+type Subscriber struct {
+	OpenID       string    `json:"openid"`
+	SubscribedAt time.Time `json:"subscribedAt"`
+}
+
+// AddSubscriber will add openid to subscribers, return false if it already subscribed
+func (s *Store) AddSubscriber(openid string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Subscribers[openid]; ok {
+		return false, nil
+	}
+
+	s.data.Subscribers[openid] = &Subscriber{
+		OpenID:       openid,
+		SubscribedAt: time.Now(),
+	}
+	return true, s.flush()
+}
+
+// RemoveSubscriber will remove openid from subscribers, return false if it doesnot subscribed
+func (s *Store) RemoveSubscriber(openid string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Subscribers[openid]; !ok {
+		return false, nil
+	}
+
+	delete(s.data.Subscribers, openid)
+	return true, s.flush()
+}
+
+// IsSubscribed return whether openid subscribed
+func (s *Store) IsSubscribed(openid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.data.Subscribers[openid]
+	return ok
+}
+
+// Subscribers return all subscribers order by subscribe time
+func (s *Store) Subscribers() []Subscriber {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscribers := make([]Subscriber, 0, len(s.data.Subscribers))
+	for _, v := range s.data.Subscribers {
+		subscribers = append(subscribers, *v)
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].SubscribedAt.Before(subscribers[j].SubscribedAt)
+	})
+
+	return subscribers
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// MaxWebhookDeliveries is the number of recent deliveries kept for each webhook
+const MaxWebhookDeliveries = 100
+
+// Webhook is the url which the events are posted to, signed by Secret
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is an attempt to post the event to webhook
+type WebhookDelivery struct {
+	Webhook    string        `json:"webhook"`
+	Event      string        `json:"event"`
+	Type       string        `json:"type"`
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"statusCode"`
+	Error      string        `json:"error,omitempty"`
+	Succeeded  bool          `json:"succeeded"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+}
+
+func (w *Webhook) clone() Webhook {
+	v := *w
+	v.Events = append([]string{}, w.Events...)
+	return v
+}
+
+// SaveWebhook will add the webhook, return false if the id already exists
+func (s *Store) SaveWebhook(w *Webhook) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Webhooks[w.ID]; ok {
+		return false, nil
+	}
+
+	v := w.clone()
+	s.data.Webhooks[w.ID] = &v
+	return true, s.flush()
+}
+
+// DeleteWebhook will remove the webhook of id and its deliveries, return false if not exists
+func (s *Store) DeleteWebhook(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Webhooks[id]; !ok {
+		return false, nil
+	}
+
+	delete(s.data.Webhooks, id)
+	delete(s.data.WebhookDeliveries, id)
+	return true, s.flush()
+}
+
+// Webhook return the webhook of id
+func (s *Store) Webhook(id string) (Webhook, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.data.Webhooks[id]
+	if !ok {
+		return Webhook{}, false
+	}
+	return w.clone(), true
+}
+
+// Webhooks return all webhooks order by create time
+func (s *Store) Webhooks() []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v := make([]Webhook, 0, len(s.data.Webhooks))
+	for _, w := range s.data.Webhooks {
+		v = append(v, w.clone())
+	}
+	sort.Slice(v, func(i, j int) bool {
+		if !v[i].CreatedAt.Equal(v[j].CreatedAt) {
+			return v[i].CreatedAt.Before(v[j].CreatedAt)
+		}
+		return v[i].ID < v[j].ID
+	})
+	return v
+}
+
+// AddWebhookDelivery will append d to the delivery log of its webhook, only the
+// recent MaxWebhookDeliveries are kept
+func (s *Store) AddWebhookDelivery(d *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Webhooks[d.Webhook]; !ok {
+		return nil
+	}
+
+	v := append(s.data.WebhookDeliveries[d.Webhook], *d)
+	if len(v) > MaxWebhookDeliveries {
+		v = append([]WebhookDelivery(nil), v[len(v)-MaxWebhookDeliveries:]...)
+	}
+	s.data.WebhookDeliveries[d.Webhook] = v
+	return s.flushLater()
+}
+
+// WebhookDeliveries return the delivery log of webhook, the latest first
+func (s *Store) WebhookDeliveries(id string) []WebhookDelivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := s.data.WebhookDeliveries[id]
+	v := make([]WebhookDelivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		v = append(v, deliveries[i])
+	}
+	return v
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package structlog write structured log lines in logfmt-like text or json format
+package structlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatText output key=value pairs
+	FormatText = "text"
+	// FormatJSON output one json object per line
+	FormatJSON = "json"
+)
+
+// Field is a key value pair of log line
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F will construct a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger write structured log lines to writer
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	now    func() time.Time
+}
+
+// New will construct a Logger with format
+func New(w io.Writer, format string) (*Logger, error) {
+	l := &Logger{
+		w:   w,
+		now: time.Now,
+	}
+	if err := l.SetFormat(format); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ValidFormat return error if format is not FormatText or FormatJSON
+func ValidFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("log format should be %s or %s", FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// SetFormat will change the output format
+func (l *Logger) SetFormat(format string) error {
+	if err := ValidFormat(format); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.format = format
+	l.mu.Unlock()
+	return nil
+}
+
+// SetOutput will change the writer
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	l.w = w
+	l.mu.Unlock()
+}
+
+// Log will write a line with msg and fields, the time and msg fields are always the first
+func (l *Logger) Log(msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fields = append([]Field{F("time", l.now().Format(time.RFC3339Nano)), F("msg", msg)}, fields...)
+
+	buf := &bytes.Buffer{}
+	if l.format == FormatJSON {
+		writeJSON(buf, fields)
+	} else {
+		writeText(buf, fields)
+	}
+	buf.WriteByte('\n')
+	l.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, fields []Field) {
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.Key)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(jsonValue(f.Value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.Value))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.Seconds()
+	}
+	return v
+}
+
+func writeText(buf *bytes.Buffer, fields []Field) {
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+
+		s := fmt.Sprint(f.Value)
+		if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+var std, _ = New(os.Stdout, FormatText)
+
+// SetFormat will change the output format of the standard Logger
+func SetFormat(format string) error {
+	return std.SetFormat(format)
+}
+
+// SetOutput will change the writer of the standard Logger
+func SetOutput(w io.Writer) {
+	std.SetOutput(w)
+}
+
+// Log will write a line with the standard Logger
+func Log(msg string, fields ...Field) {
+	std.Log(msg, fields...)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structlog
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type structlogTestSuite struct {
+	suite.Suite
+
+	buf *bytes.Buffer
+	l   *Logger
+}
+
+func (p *structlogTestSuite) SetupTest() {
+	p.buf = &bytes.Buffer{}
+
+	var err error
+	p.l, err = New(p.buf, FormatText)
+	p.NoError(err)
+	p.l.now = func() time.Time {
+		return time.Date(2018, 7, 3, 21, 15, 0, 0, time.UTC)
+	}
+}
+
+func (p *structlogTestSuite) TestText() {
+	p.l.Log("access", F("status", 200), F("path", "/a b"), F("error", ""), F("latency", 1500*time.Millisecond))
+
+	p.Equal(`time=2018-07-03T21:15:00Z msg=access status=200 path="/a b" error="" latency=1.5s`+"\n", p.buf.String())
+}
+
+func (p *structlogTestSuite) TestJSON() {
+	p.NoError(p.l.SetFormat(FormatJSON))
+	p.l.Log("upstream", F("status", 200), F("error", errors.New("timeout")), F("latency", 1500*time.Millisecond))
+
+	p.Equal(`{"time":"2018-07-03T21:15:00Z","msg":"upstream","status":200,"error":"timeout","latency":1.5}`+"\n", p.buf.String())
+}
+
+func (p *structlogTestSuite) TestInvalidFormat() {
+	p.Error(p.l.SetFormat("xml"))
+	_, err := New(p.buf, "")
+	p.Error(err)
+}
+
+func TestStructlogTestSuite(t *testing.T) {
+	p := &structlogTestSuite{}
+	suite.Run(t, p)
+}
@@ -15,21 +15,22 @@
 package svs
 
 import (
-	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/xml"
-	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
-	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/version"
+	"github.com/lsytj0413/tyche/pkg/wechat"
 )
 
 func (s *server) Version(c *gin.Context) (interface{}, error) {
 	return map[string]string{
-		"Version":     "1.0.0",
+		"Version":     version.Version,
 		"Name":        "svs",
 		"Description": "svs website server.",
 	}, nil
@@ -39,26 +40,21 @@ func (s *server) Index(c *gin.Context) (interface{}, error) {
 	return s.Version(c)
 }
 
-func (s *server) WxVerify(c *gin.Context) {
+// checkWxSignature will panic if the signature query of wechat request mismatch,
+// it is sent with both the url verification and the messages
+func checkWxSignature(c *gin.Context, token string) {
 	signature := c.Query("signature")
-	timestamp := c.Query("timestamp")
-	nonce := c.Query("nonce")
-	echostr := c.Query("echostr")
-
-	params := []string{s.c.WxToken, timestamp, nonce}
-	sort.Strings(params)
-	h := sha1.New()
-	for _, s := range params {
-		io.WriteString(h, s)
-	}
-	if signature == fmt.Sprintf("%x", h.Sum(nil)) {
-		c.Writer.Write([]byte(echostr))
-		c.Writer.WriteHeader(http.StatusOK)
-		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		return
+	expected := wechat.Signature(token, c.Query("timestamp"), c.Query("nonce"))
+	if signature == "" || subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) != 1 {
+		panic(ierror.NewError(ierror.EcodeWxSignatureInvalid, "signature mismatch"))
 	}
+}
+
+func (s *server) WxVerify(c *gin.Context) {
+	c.Set(keyOpenID, c.Query("openid"))
+	checkWxSignature(c, s.wechat().token)
 
-	c.Writer.WriteHeader(http.StatusBadRequest)
+	writeWxText(c, c.Query("echostr"))
 }
 
 // TextMessage struct
@@ -83,33 +79,93 @@ type TextReply struct {
 }
 
 func (s *server) WxEntry(c *gin.Context) {
+	start := time.Now()
+	wxs := s.wechat()
+	c.Set(keyOpenID, c.Query("openid"))
+	checkWxSignature(c, wxs.token)
+
 	body, err := ioutil.ReadAll(c.Request.Body)
 	if err != nil {
 		c.Writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	crypter := wxs.crypter
+	encrypted := c.Query("encrypt_type") == wechat.EncryptTypeAES
+	if !encrypted && crypter != nil {
+		// wechat always send encrypted messages in compatible and safe mode
+		panic(ierror.NewError(ierror.EcodeWxSignatureInvalid, "receive plaintext wechat message with wx-aeskey set"))
+	}
+	if encrypted {
+		if crypter == nil {
+			panic(ierror.NewError(ierror.EcodeRequestParam, "receive encrypted wechat message without wx-aeskey set"))
+		}
+
+		body, err = crypter.DecryptMessage(c.Query("msg_signature"), c.Query("timestamp"), c.Query("nonce"), body)
+		if err == wechat.ErrInvalidSignature {
+			panic(ierror.Wrap(ierror.EcodeWxSignatureInvalid, err))
+		}
+		if err != nil {
+			panic(ierror.Wrapf(ierror.EcodeRequestParam, err, "decrypt wechat message"))
+		}
+	}
+
 	text := TextMessage{}
 	err = xml.Unmarshal(body, &text)
 	if err != nil {
 		c.Writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	c.Set(keyOpenID, text.FromUserName)
+
+	r, created := s.replies.acquire(wxMessageKey(&text))
+	if created {
+		command := ""
+		if text.MsgType == "text" {
+			command = commandName(text.Content)
+		}
+		s.metrics.wxMessages.WithLabelValues(text.MsgType, command).Inc()
+
+		// the reply may outlive the request, only the request id is kept
+		s.replyText(c.GetString(keyRequestID), r, text.FromUserName, text.Content)
+	} else {
+		logger.Infof("Receive duplicate wechat message[%s] of %s", wxMessageKey(&text), text.FromUserName)
+	}
+
+	content, ok := r.wait(passiveReplyTimeout - time.Since(start))
+	if !ok {
+		// reply will be sent by customer service message, "success" tell wechat not to retry
+		writeWxText(c, "success")
+		return
+	}
 
 	reply := &TextReply{
 		ToUserName:   text.FromUserName,
 		FromUserName: text.ToUserName,
-		CreateTime:   uint64(time.Now().Second()) * 1000,
+		CreateTime:   uint64(time.Now().Unix()),
 		MsgType:      "text",
-		Content:      text.Content + " reply from tyche",
+		Content:      content,
 	}
 	replyByte, err := xml.Marshal(reply)
 	if err != nil {
 		c.Writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	fmt.Println(replyByte)
-	c.Writer.Write(replyByte)
+	if encrypted {
+		replyByte, err = crypter.EncryptMessage(replyByte, c.Query("timestamp"), c.Query("nonce"))
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	c.Writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Write(replyByte)
+}
+
+func writeWxText(c *gin.Context, content string) {
+	c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Write([]byte(content))
 }
@@ -0,0 +1,109 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/wechat"
+	"github.com/stretchr/testify/suite"
+)
+
+const testWxMessage = `<xml><ToUserName>gh_1</ToUserName><FromUserName>u1</FromUserName><CreateTime>1</CreateTime>` +
+	`<MsgType>text</MsgType><Content>订阅开奖</Content><MsgId>1</MsgId></xml>`
+
+type apiTestSuite struct {
+	suite.Suite
+
+	s *server
+	r *gin.Engine
+}
+
+func (p *apiTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+
+	st, err := store.Open("")
+	p.NoError(err)
+	p.s = &server{
+		c:       conf.New(),
+		st:      st,
+		wxs:     &wxState{token: "token"},
+		replies: newWxReplies(),
+	}
+	p.s.metrics = newServerMetrics(p.s)
+	p.r = p.s.newRouter()
+}
+
+// query return the wechat query signed by token
+func (p *apiTestSuite) query(token string) url.Values {
+	v := url.Values{}
+	v.Set("timestamp", "1530000000")
+	v.Set("nonce", "42")
+	v.Set("signature", wechat.Signature(token, "1530000000", "42"))
+	return v
+}
+
+func (p *apiTestSuite) do(method string, query url.Values, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/wx/mainEntry?"+query.Encode(), strings.NewReader(body))
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, req)
+	return w
+}
+
+func (p *apiTestSuite) TestVerify() {
+	query := p.query("token")
+	query.Set("echostr", "echo")
+	w := p.do(http.MethodGet, query, "")
+	p.Equal(http.StatusOK, w.Code)
+	p.Equal("echo", w.Body.String())
+
+	p.Equal(http.StatusForbidden, p.do(http.MethodGet, p.query("forged"), "").Code)
+	p.Equal(http.StatusForbidden, p.do(http.MethodGet, url.Values{"echostr": {"echo"}}, "").Code)
+}
+
+func (p *apiTestSuite) TestEntrySignature() {
+	p.Equal(http.StatusForbidden, p.do(http.MethodPost, url.Values{}, testWxMessage).Code)
+	p.Equal(http.StatusForbidden, p.do(http.MethodPost, p.query("forged"), testWxMessage).Code)
+	p.False(p.s.st.IsSubscribed("u1"))
+
+	w := p.do(http.MethodPost, p.query("token"), testWxMessage)
+	p.Equal(http.StatusOK, w.Code)
+	reply := &TextReply{}
+	p.NoError(xml.Unmarshal(w.Body.Bytes(), reply))
+	p.Equal("u1", reply.ToUserName)
+	p.True(p.s.st.IsSubscribed("u1"))
+}
+
+func (p *apiTestSuite) TestEntryPlaintextWithAESKey() {
+	crypter, err := wechat.NewMsgCrypter("token", "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG", "wx1")
+	p.NoError(err)
+	p.s.wxs.crypter = crypter
+
+	p.Equal(http.StatusForbidden, p.do(http.MethodPost, p.query("token"), testWxMessage).Code)
+	p.False(p.s.st.IsSubscribed("u1"))
+}
+
+func TestAPITestSuite(t *testing.T) {
+	p := &apiTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/ratelimit"
+	"github.com/lsytj0413/tyche/pkg/store"
+)
+
+// api key scopes
+const (
+	// scopeRead allow reading lottery data
+	scopeRead = "read"
+	// scopeMetrics allow scraping /metrics
+	scopeMetrics = "metrics"
+	// scopeAdmin allow managing api keys, cache and webhooks
+	scopeAdmin = "admin"
+)
+
+var knownScopes = map[string]bool{
+	scopeRead:    true,
+	scopeMetrics: true,
+	scopeAdmin:   true,
+}
+
+const (
+	headerAPIKey = "X-API-Key"
+	keyAPIKey    = "apiKey"
+
+	// the auth failures of each client ip, the client is rejected before auth after burst failures
+	authFailureRate  = 0.1
+	authFailureBurst = 10
+)
+
+// hashAPIKey return the hex sha256 of key, only the hash is kept in config and store
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "tyk_" + hex.EncodeToString(b)
+}
+
+// validateAPIKeys return error if the api keys in config is invalid
+func validateAPIKeys(keys []conf.APIKey) error {
+	names := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Name == "" {
+			return fmt.Errorf("api key name should not be empty")
+		}
+		if names[k.Name] {
+			return fmt.Errorf("api key[%s] is duplicated", k.Name)
+		}
+		names[k.Name] = true
+
+		if b, err := hex.DecodeString(k.Hash); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("api key[%s] hash should be hex encoded sha256", k.Name)
+		}
+		if err := validateScopes(k.Scopes); err != nil {
+			return fmt.Errorf("api key[%s]: %s", k.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown scope %s", scope)
+		}
+	}
+	return nil
+}
+
+// requestAPIKey return the api key of request from X-API-Key or Authorization: Bearer header
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader(headerAPIKey); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// lookupAPIKey return the api key of key from config and store, ok is false if not found
+func (s *server) lookupAPIKey(key string) (name string, scopes []string, ok bool) {
+	hash := []byte(hashAPIKey(key))
+	for _, k := range s.config().APIKeys {
+		if subtle.ConstantTimeCompare(hash, []byte(k.Hash)) == 1 {
+			return k.Name, k.Scopes, true
+		}
+	}
+	for _, k := range s.st.APIKeys() {
+		if subtle.ConstantTimeCompare(hash, []byte(k.Hash)) == 1 {
+			return k.Name, k.Scopes, true
+		}
+	}
+	return "", nil, false
+}
+
+// hasAPIKeys return whether any api key exists in config or store
+func (s *server) hasAPIKeys() bool {
+	return len(s.config().APIKeys) > 0 || len(s.st.APIKeys()) > 0
+}
+
+// authMiddleware will authenticate the api key of request and check it has scope.
+// The key is optional for empty scope, and the scope is not required until any key exists,
+// except scopeAdmin which always requires a key.
+func (s *server) authMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// the client guessing keys is throttled before auth, the rate limit by key is after it
+		client := "ip:" + c.ClientIP()
+		if s.authFailures != nil {
+			if ok, wait := s.authFailures.Get(client).Available(); !ok {
+				rateLimited(c, wait)
+			}
+		}
+		unauthorized := func(message string) {
+			if s.authFailures != nil {
+				s.authFailures.Allow(client)
+			}
+			panic(ierror.NewError(ierror.EcodeUnauthorized, message))
+		}
+
+		key := requestAPIKey(c)
+		if key == "" {
+			if scope == "" || (scope != scopeAdmin && !s.hasAPIKeys()) {
+				c.Next()
+				return
+			}
+			unauthorized("api key is required")
+		}
+
+		name, scopes, ok := s.lookupAPIKey(key)
+		if !ok {
+			unauthorized("api key is invalid")
+		}
+		c.Set(keyAPIKey, name)
+
+		if scope != "" && !hasScope(scopes, scope) {
+			panic(ierror.NewError(ierror.EcodeForbidden, fmt.Sprintf("api key[%s] has no scope %s", name, scope)))
+		}
+		c.Next()
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, v := range scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitMiddleware will limit the requests of each api key, or client ip without key
+func rateLimitMiddleware(limiter *ratelimit.Keyed) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		client := "ip:" + c.ClientIP()
+		if name := c.GetString(keyAPIKey); name != "" {
+			client = "key:" + name
+		}
+
+		if ok, wait := limiter.Allow(client); !ok {
+			rateLimited(c, wait)
+		}
+		c.Next()
+	}
+}
+
+// rateLimited will abort the request with Retry-After of wait
+func rateLimited(c *gin.Context, wait time.Duration) {
+	c.Writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	panic(ierror.NewError(ierror.EcodeRateLimited, fmt.Sprintf("retry after %s", wait)))
+}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type apiKeyResponse struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListAPIKeys return the api keys in store, the keys in config are not listed
+func (s *server) ListAPIKeys(c *gin.Context) (interface{}, error) {
+	keys := s.st.APIKeys()
+	v := make([]apiKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		v = append(v, apiKeyResponse{
+			Name:      k.Name,
+			Scopes:    k.Scopes,
+			CreatedAt: k.CreatedAt,
+		})
+	}
+	return v, nil
+}
+
+// CreateAPIKey will generate a api key and save its hash, the key is only returned here
+func (s *server) CreateAPIKey(c *gin.Context) (interface{}, error) {
+	req := &apiKeyRequest{}
+	if err := json.NewDecoder(c.Request.Body).Decode(req); err != nil {
+		return nil, ierror.Wrapf(ierror.EcodeRequestParam, err, "decode api key request")
+	}
+	if req.Name == "" {
+		return nil, ierror.NewError(ierror.EcodeRequestParam, "name is required")
+	}
+	if err := validateScopes(req.Scopes); err != nil {
+		return nil, ierror.Wrap(ierror.EcodeRequestParam, err)
+	}
+	for _, k := range s.config().APIKeys {
+		if k.Name == req.Name {
+			return nil, ierror.NewError(ierror.EcodeRequestParam, fmt.Sprintf("api key[%s] exists in config", req.Name))
+		}
+	}
+
+	key := newAPIKey()
+	v := &store.APIKey{
+		Name:      req.Name,
+		Hash:      hashAPIKey(key),
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+	added, err := s.st.SaveAPIKey(v)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ierror.NewError(ierror.EcodeRequestParam, fmt.Sprintf("api key[%s] exists", req.Name))
+	}
+
+	c.Status(http.StatusCreated)
+	return &apiKeyResponse{
+		Name:      v.Name,
+		Key:       key,
+		Scopes:    v.Scopes,
+		CreatedAt: v.CreatedAt,
+	}, nil
+}
+
+// DeleteAPIKey will remove the api key in store
+func (s *server) DeleteAPIKey(c *gin.Context) (interface{}, error) {
+	name := c.Param("name")
+	removed, err := s.st.DeleteAPIKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ierror.NewError(ierror.EcodeNotFound, fmt.Sprintf("api key[%s] not found", name))
+	}
+	return map[string]string{"name": name}, nil
+}
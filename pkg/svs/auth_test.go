@@ -0,0 +1,320 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/util"
+	"github.com/lsytj0413/tyche/pkg/webhook"
+	"github.com/stretchr/testify/suite"
+)
+
+type authTestSuite struct {
+	suite.Suite
+
+	s *server
+}
+
+func (p *authTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+
+	st, err := store.Open("")
+	p.NoError(err)
+	p.s = &server{
+		c:  conf.New(),
+		st: st,
+	}
+	p.s.metrics = newServerMetrics(p.s)
+}
+
+func (p *authTestSuite) do(r *gin.Engine, method string, path string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func (p *authTestSuite) code(w *httptest.ResponseRecorder) int {
+	resp := &errorResponse{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), resp))
+	return resp.Code
+}
+
+func (p *authTestSuite) TestNoKeys() {
+	r := p.s.newRouter()
+
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/metrics", "", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/version", "", "").Code)
+
+	w := p.do(r, http.MethodGet, "/api/keys", "", "")
+	p.Equal(http.StatusUnauthorized, w.Code)
+	p.Equal(ierror.EcodeUnauthorized, p.code(w))
+}
+
+func (p *authTestSuite) TestConfigKeys() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "prometheus", Hash: hashAPIKey("metrics-key"), Scopes: []string{scopeMetrics}},
+		{Name: "dashboard", Hash: hashAPIKey("read-key"), Scopes: []string{scopeRead}},
+	}
+	p.NoError(validateAPIKeys(p.s.c.APIKeys))
+	r := p.s.newRouter()
+
+	p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/metrics", "", "").Code)
+	p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/metrics", "wrong", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/metrics", "metrics-key", "").Code)
+
+	w := p.do(r, http.MethodGet, "/metrics", "read-key", "")
+	p.Equal(http.StatusForbidden, w.Code)
+	p.Equal(ierror.EcodeForbidden, p.code(w))
+
+	// key is optional for public routes, but must be valid if present
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/version", "", "").Code)
+	p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/version", "wrong", "").Code)
+
+	// probes and wechat routes are exempted
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/healthz", "wrong", "").Code)
+	p.s.wxs = &wxState{}
+	p.Equal(ierror.EcodeWxSignatureInvalid, p.code(p.do(r, http.MethodGet, "/api/wx/mainEntry?signature=x", "wrong", "")))
+}
+
+func (p *authTestSuite) TestWechatUnsigned() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "root", Hash: hashAPIKey("admin-key"), Scopes: []string{scopeAdmin}},
+	}
+	p.s.wxs = &wxState{token: "token"}
+	p.s.replies = newWxReplies()
+	r := p.s.newRouter()
+
+	body := `<xml><FromUserName>u1</FromUserName><MsgType>text</MsgType><Content>订阅开奖</Content></xml>`
+	for _, key := range []string{"", "admin-key"} {
+		w := p.do(r, http.MethodPost, "/api/wx/mainEntry", key, body)
+		p.Equal(http.StatusForbidden, w.Code)
+		p.Equal(ierror.EcodeWxSignatureInvalid, p.code(w))
+	}
+	p.False(p.s.st.IsSubscribed("u1"))
+}
+
+func (p *authTestSuite) TestManageKeys() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "root", Hash: hashAPIKey("admin-key"), Scopes: []string{scopeAdmin}},
+	}
+	r := p.s.newRouter()
+
+	p.Equal(http.StatusBadRequest, p.do(r, http.MethodPost, "/api/keys", "admin-key", `{"name":"x","scopes":["unknown"]}`).Code)
+	p.Equal(http.StatusBadRequest, p.do(r, http.MethodPost, "/api/keys", "admin-key", `{"name":"root"}`).Code)
+
+	w := p.do(r, http.MethodPost, "/api/keys", "admin-key", `{"name":"prometheus","scopes":["metrics"]}`)
+	p.Equal(http.StatusCreated, w.Code)
+	created := &apiKeyResponse{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), created))
+	p.NotEmpty(created.Key)
+	p.Equal(hashAPIKey(created.Key), p.s.st.APIKeys()[0].Hash)
+
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/metrics", created.Key, "").Code)
+	p.Equal(http.StatusForbidden, p.do(r, http.MethodGet, "/api/keys", created.Key, "").Code)
+
+	w = p.do(r, http.MethodGet, "/api/keys", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	list := []apiKeyResponse{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), &list))
+	p.Len(list, 1)
+	p.Empty(list[0].Key)
+
+	p.Equal(http.StatusOK, p.do(r, http.MethodDelete, "/api/keys/prometheus", "admin-key", "").Code)
+	p.Equal(http.StatusNotFound, p.do(r, http.MethodDelete, "/api/keys/prometheus", "admin-key", "").Code)
+	p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/metrics", created.Key, "").Code)
+}
+
+func (p *authTestSuite) TestRateLimit() {
+	p.s.c.RateLimit = 0.5
+	p.s.c.RateBurst = 2
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "dashboard", Hash: hashAPIKey("read-key"), Scopes: []string{scopeRead}},
+	}
+	r := p.s.newRouter()
+
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/version", "", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/version", "", "").Code)
+	w := p.do(r, http.MethodGet, "/version", "", "")
+	p.Equal(http.StatusTooManyRequests, w.Code)
+	p.Equal(ierror.EcodeRateLimited, p.code(w))
+	p.Equal("2", w.Header().Get("Retry-After"))
+
+	// api key has its own bucket, probes are not limited
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/version", "read-key", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodGet, "/healthz", "", "").Code)
+}
+
+func (p *authTestSuite) TestAuthFailureLimit() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "dashboard", Hash: hashAPIKey("read-key"), Scopes: []string{scopeRead}},
+	}
+	r := p.s.newRouter()
+
+	// the invalid keys are throttled by client ip, though each key has its own rate limit bucket
+	for i := 0; i < authFailureBurst; i++ {
+		p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/version", fmt.Sprintf("guess-%d", i), "").Code)
+	}
+	w := p.do(r, http.MethodGet, "/version", "guess", "")
+	p.Equal(http.StatusTooManyRequests, w.Code)
+	p.Equal(ierror.EcodeRateLimited, p.code(w))
+	p.Equal("10", w.Header().Get("Retry-After"))
+	// the key is not checked until the failures are refilled
+	p.Equal(http.StatusTooManyRequests, p.do(r, http.MethodGet, "/version", "read-key", "").Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	req.Header.Set("Authorization", "Bearer read-key")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	p.Equal(http.StatusOK, w.Code)
+}
+
+func (p *authTestSuite) TestValidateAPIKeys() {
+	hash := hashAPIKey("key")
+	p.NoError(validateAPIKeys([]conf.APIKey{{Name: "a", Hash: hash, Scopes: []string{scopeRead}}}))
+	p.Error(validateAPIKeys([]conf.APIKey{{Name: "", Hash: hash}}))
+	p.Error(validateAPIKeys([]conf.APIKey{{Name: "a", Hash: hash}, {Name: "a", Hash: hash}}))
+	p.Error(validateAPIKeys([]conf.APIKey{{Name: "a", Hash: "key"}}))
+	p.Error(validateAPIKeys([]conf.APIKey{{Name: "a", Hash: hash, Scopes: []string{"write"}}}))
+}
+
+func (p *authTestSuite) TestCache() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "root", Hash: hashAPIKey("admin-key"), Scopes: []string{scopeAdmin}},
+	}
+	r := p.s.newRouter()
+
+	w := p.do(r, http.MethodGet, "/api/cache", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	p.Equal(http.StatusBadRequest, p.do(r, http.MethodDelete, "/api/cache", "admin-key", "").Code)
+
+	dir, err := ioutil.TempDir("", "tyche-cache")
+	p.NoError(err)
+	defer os.RemoveAll(dir)
+	p.s.cache, err = util.NewCache(dir)
+	p.NoError(err)
+	_, err = p.s.cache.Put("http://a/ssq/1", nil, []byte("1"), util.CacheForever)
+	p.NoError(err)
+	_, err = p.s.cache.Put("http://a/dlt/1", nil, []byte("22"), util.CacheForever)
+	p.NoError(err)
+
+	p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/api/cache", "", "").Code)
+	w = p.do(r, http.MethodGet, "/api/cache?prefix=http://a/ssq/", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	v := &cacheResponse{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), v))
+	p.Len(v.Entries, 1)
+	p.Equal(int64(1), v.Size)
+
+	p.Equal(http.StatusNotFound, p.do(r, http.MethodDelete, "/api/cache?url=http://a/x", "admin-key", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodDelete, "/api/cache?url=http://a/ssq/1", "admin-key", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodDelete, "/api/cache", "admin-key", "").Code)
+	entries, err := p.s.cache.Entries()
+	p.NoError(err)
+	p.Len(entries, 0)
+}
+
+func (p *authTestSuite) TestFlaggedDraws() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "root", Hash: hashAPIKey("admin-key"), Scopes: []string{scopeAdmin}},
+		{Name: "reader", Hash: hashAPIKey("read-key"), Scopes: []string{scopeRead}},
+	}
+	r := p.s.newRouter()
+	p.NoError(p.s.st.FlagDraw(&tcb.Reconciliation{Term: 18077, Diffs: []tcb.Diff{{Field: "number", A: "1", B: "2"}}}))
+
+	p.Equal(http.StatusForbidden, p.do(r, http.MethodGet, "/api/draws/flagged", "read-key", "").Code)
+	w := p.do(r, http.MethodGet, "/api/draws/flagged", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	draws := []store.FlaggedDraw{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), &draws))
+	p.Len(draws, 1)
+	p.Equal(uint32(18077), draws[0].Term)
+	p.Len(draws[0].Diffs, 1)
+}
+
+func (p *authTestSuite) TestWebhooks() {
+	p.s.c.APIKeys = []conf.APIKey{
+		{Name: "root", Hash: hashAPIKey("admin-key"), Scopes: []string{scopeAdmin}},
+	}
+	p.s.webhooks = webhook.New(p.s.st)
+	r := p.s.newRouter()
+
+	var signature string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(webhook.HeaderSignature)
+	}))
+	defer hook.Close()
+
+	p.Equal(http.StatusUnauthorized, p.do(r, http.MethodGet, "/api/webhooks", "", "").Code)
+	p.Equal(http.StatusBadRequest, p.do(r, http.MethodPost, "/api/webhooks", "admin-key", `{"url":"/hook"}`).Code)
+	p.Equal(http.StatusBadRequest, p.do(r, http.MethodPost, "/api/webhooks", "admin-key", `{"url":"`+hook.URL+`","events":["unknown"]}`).Code)
+
+	w := p.do(r, http.MethodPost, "/api/webhooks", "admin-key", `{"url":"`+hook.URL+`","events":["TicketWon"]}`)
+	p.Equal(http.StatusCreated, w.Code)
+	created := &webhookResponse{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), created))
+	p.NotEmpty(created.ID)
+	p.NotEmpty(created.Secret)
+
+	w = p.do(r, http.MethodGet, "/api/webhooks", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	listed := []*webhookResponse{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), &listed))
+	p.Len(listed, 1)
+	p.Empty(listed[0].Secret)
+	p.Equal([]string{"TicketWon"}, listed[0].Events)
+
+	w = p.do(r, http.MethodPost, "/api/webhooks/"+created.ID+"/test", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	delivery := &store.WebhookDelivery{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), delivery))
+	p.True(delivery.Succeeded)
+	p.Equal(http.StatusOK, delivery.StatusCode)
+	p.NotEmpty(signature)
+
+	w = p.do(r, http.MethodGet, "/api/webhooks/"+created.ID+"/deliveries", "admin-key", "")
+	p.Equal(http.StatusOK, w.Code)
+	deliveries := []store.WebhookDelivery{}
+	p.NoError(json.Unmarshal(w.Body.Bytes(), &deliveries))
+	p.Len(deliveries, 1)
+	p.Equal(string(webhook.TypePing), deliveries[0].Type)
+
+	p.Equal(http.StatusNotFound, p.do(r, http.MethodPost, "/api/webhooks/unknown/test", "admin-key", "").Code)
+	p.Equal(http.StatusOK, p.do(r, http.MethodDelete, "/api/webhooks/"+created.ID, "admin-key", "").Code)
+	p.Equal(http.StatusNotFound, p.do(r, http.MethodDelete, "/api/webhooks/"+created.ID, "admin-key", "").Code)
+	p.Empty(p.s.st.Webhooks())
+}
+
+func TestAuthTestSuite(t *testing.T) {
+	p := &authTestSuite{}
+	suite.Run(t, p)
+}
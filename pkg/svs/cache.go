@@ -0,0 +1,77 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/util"
+)
+
+type cacheResponse struct {
+	Entries []*util.CacheEntry `json:"entries"`
+	Size    int64              `json:"size"`
+}
+
+// ListCache return the upstream page cache entries, filter by url prefix with ?prefix=
+func (s *server) ListCache(c *gin.Context) (interface{}, error) {
+	v := &cacheResponse{
+		Entries: make([]*util.CacheEntry, 0),
+	}
+	if s.cache == nil {
+		return v, nil
+	}
+
+	entries, err := s.cache.Entries()
+	if err != nil {
+		return nil, err
+	}
+	prefix := c.Query("prefix")
+	for _, e := range entries {
+		if !strings.HasPrefix(e.URL, prefix) {
+			continue
+		}
+		v.Entries = append(v.Entries, e)
+		v.Size += e.Size
+	}
+	return v, nil
+}
+
+// PurgeCache will remove the entry of ?url=, or the expired entries with ?expired=true, or all entries
+func (s *server) PurgeCache(c *gin.Context) (interface{}, error) {
+	if s.cache == nil {
+		return nil, ierror.NewError(ierror.EcodeRequestParam, "cache is disabled")
+	}
+
+	if url := c.Query("url"); url != "" {
+		removed, err := s.cache.Delete(url)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			return nil, ierror.NewError(ierror.EcodeNotFound, fmt.Sprintf("cache of url[%s] not found", url))
+		}
+		return map[string]int{"removed": 1}, nil
+	}
+
+	count, err := s.cache.Purge(c.Query("expired") == "true")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"removed": count}, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+)
+
+// crlList is the revoked certificate serials of a CRL
+type crlList struct {
+	// issuer is the raw subject of the CA which signed the CRL
+	issuer     string
+	revoked    map[string]struct{}
+	nextUpdate time.Time
+}
+
+// parseCAs will parse all certificates in the PEM encoded data
+func parseCAs(data []byte) ([]*x509.Certificate, error) {
+	cas := make([]*x509.Certificate, 0, 1)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, ca)
+	}
+
+	return cas, nil
+}
+
+// loadCRL will load the CRL file (PEM or DER) and verify it is signed by one of cas
+func loadCRL(path string, cas []*x509.Certificate) (*crlList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("client crlfile read error: %s", err.Error())
+	}
+
+	// the CRL file may be PEM or DER encoded
+	if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("client crlfile parse error: %s", err.Error())
+	}
+
+	var issuer *x509.Certificate
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			issuer = ca
+			break
+		}
+	}
+	if issuer == nil {
+		return nil, fmt.Errorf("client crlfile is not signed by client-trusted-ca-file")
+	}
+
+	v := &crlList{
+		issuer:     string(issuer.RawSubject),
+		revoked:    make(map[string]struct{}, len(crl.RevokedCertificateEntries)),
+		nextUpdate: crl.NextUpdate,
+	}
+	for _, r := range crl.RevokedCertificateEntries {
+		v.revoked[r.SerialNumber.String()] = struct{}{}
+	}
+	if !v.nextUpdate.IsZero() && time.Now().After(v.nextUpdate) {
+		logger.Errorf("Client crlfile %s has expired at %s", path, v.nextUpdate)
+	}
+
+	return v, nil
+}
+
+// isRevoked return whether the certificate is revoked by the CRL
+func (l *crlList) isRevoked(cert *x509.Certificate) bool {
+	if string(cert.RawIssuer) != l.issuer {
+		return false
+	}
+
+	_, ok := l.revoked[cert.SerialNumber.String()]
+	return ok
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/webhook"
+)
+
+// storeOutbox is the event.Outbox saved in store
+type storeOutbox struct {
+	st *store.Store
+}
+
+func (o storeOutbox) Save(r *event.Record) error {
+	return o.st.SaveOutboxEvent(&store.OutboxEvent{
+		ID:        r.ID,
+		Type:      string(r.Type),
+		Time:      r.Time,
+		Data:      r.Data,
+		Delivered: r.Delivered,
+	})
+}
+
+func (o storeOutbox) Delete(id string) error {
+	return o.st.DeleteOutboxEvent(id)
+}
+
+func (o storeOutbox) Records() ([]*event.Record, error) {
+	events := o.st.OutboxEvents()
+	v := make([]*event.Record, 0, len(events))
+	for _, e := range events {
+		v = append(v, &event.Record{
+			Event: event.Event{
+				ID:   e.ID,
+				Type: event.Type(e.Type),
+				Time: e.Time,
+				Data: e.Data,
+			},
+			Delivered: e.Delivered,
+		})
+	}
+	return v, nil
+}
+
+// publish will construct and publish the event of typ with payload v
+func publish(bus *event.Bus, typ event.Type, v interface{}) error {
+	e, err := event.NewEvent(typ, v)
+	if err != nil {
+		return err
+	}
+	return bus.Publish(e)
+}
+
+// drawOf return the award in payload of DrawPublished or PrizeTableUpdated
+func drawOf(e *event.Event) (*tcb.Award, error) {
+	var v event.Draw
+	if err := e.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.Award, nil
+}
+
+// subscribe will register the handlers of the draw events which the watcher publish,
+// the wechat subscribers are notified of the complete draw if notifier is configured
+func (s *server) subscribe() {
+	s.bus.Subscribe("draw-stream", s.stream.Handle, streamTypes...)
+	s.bus.Subscribe("ticket-checker", s.checkBets, event.TypePrizeTableUpdated)
+	s.bus.Subscribe("webhooks", s.webhooks.Handle, webhook.Types...)
+	if s.notifier != nil {
+		s.bus.Subscribe("wechat-notifier", s.notifyDraw, event.TypePrizeTableUpdated)
+	}
+}
+
+// notifyDraw push the complete draw to wechat subscribers
+func (s *server) notifyDraw(ctx context.Context, e *event.Event) error {
+	award, err := drawOf(e)
+	if err != nil {
+		return err
+	}
+	return s.notifier.Notify(ctx, award)
+}
+
+// checkBets publish TicketWon for each bet which won at the complete draw
+func (s *server) checkBets(ctx context.Context, e *event.Event) error {
+	award, err := drawOf(e)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range s.st.Profiles() {
+		for _, bet := range p.Bets {
+			if bet.Term != award.Term {
+				continue
+			}
+			level, _, _ := bet.Ticket.Check(award)
+			if level == tcb.NoAward {
+				continue
+			}
+
+			v := &event.TicketWon{
+				OpenID: p.OpenID,
+				Term:   award.Term,
+				Ticket: bet.Ticket,
+				Level:  level,
+			}
+			if piece := award.Piece(level); piece != nil {
+				v.Bonus = piece.Bonus
+			}
+			if err := publish(s.bus, event.TypeTicketWon, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
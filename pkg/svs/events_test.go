@@ -0,0 +1,137 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/notify"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/webhook"
+	"github.com/lsytj0413/tyche/pkg/wechat/client"
+	"github.com/stretchr/testify/suite"
+)
+
+type eventsTestSuite struct {
+	suite.Suite
+
+	s *server
+}
+
+func (p *eventsTestSuite) SetupTest() {
+	st, err := store.Open("")
+	p.NoError(err)
+	p.s = &server{st: st}
+	p.s.bus = event.New(storeOutbox{st})
+}
+
+func (p *eventsTestSuite) TestStoreOutbox() {
+	o := storeOutbox{p.s.st}
+	e, err := event.NewEvent(event.TypeSyncFailed, &event.SyncFailed{Error: "timeout"})
+	p.NoError(err)
+	p.NoError(o.Save(&event.Record{Event: *e, Delivered: []string{"a"}}))
+
+	records, err := o.Records()
+	p.NoError(err)
+	p.Len(records, 1)
+	p.Equal(e.ID, records[0].ID)
+	p.Equal(event.TypeSyncFailed, records[0].Type)
+	p.Equal([]string{"a"}, records[0].Delivered)
+
+	p.NoError(o.Delete(e.ID))
+	records, err = o.Records()
+	p.NoError(err)
+	p.Empty(records)
+}
+
+func (p *eventsTestSuite) TestCheckBets() {
+	won := tcb.Ticket{Reds: []uint8{1, 2, 3, 4, 5, 6}, Blue: 8}
+	lost := tcb.Ticket{Reds: []uint8{11, 12, 13, 14, 15, 16}, Blue: 8}
+	p.NoError(p.s.st.UpdateProfile("u1", func(v *store.Profile) error {
+		v.Bets = append(v.Bets, store.Bet{Term: 18077, Ticket: won}, store.Bet{Term: 18077, Ticket: lost},
+			store.Bet{Term: 18076, Ticket: won})
+		return nil
+	}))
+
+	award := testAward(18077, 8, true)
+	e, err := event.NewEvent(event.TypePrizeTableUpdated, &event.Draw{Award: award})
+	p.NoError(err)
+	p.NoError(p.s.checkBets(context.Background(), e))
+
+	records := p.s.st.OutboxEvents()
+	p.Len(records, 1)
+	p.Equal(string(event.TypeTicketWon), records[0].Type)
+	v := event.TicketWon{}
+	p.NoError((&event.Event{Data: records[0].Data}).Decode(&v))
+	p.Equal(event.TicketWon{OpenID: "u1", Term: 18077, Ticket: won, Level: tcb.SecondAward, Bonus: 120000}, v)
+}
+
+// recordSender is notify.Sender which record the sent messages
+type recordSender struct {
+	ch chan *client.TemplateMessage
+}
+
+func (r *recordSender) SendTemplateMessage(msg *client.TemplateMessage) (int64, error) {
+	r.ch <- msg
+	return 1, nil
+}
+
+func (p *eventsTestSuite) TestNotifyDraw() {
+	sender := &recordSender{ch: make(chan *client.TemplateMessage, 1)}
+	p.s.stream = newDrawStream()
+	p.s.webhooks = webhook.New(p.s.st)
+	p.s.notifier = notify.New(p.s.st, sender, "tmpl", 100)
+	p.s.notifier.SetTicketChecker(p.s.checkSubscriberTickets)
+	p.s.subscribe()
+	_, err := p.s.st.AddSubscriber("u1")
+	p.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.s.bus.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		p.s.stream.close(context.Background())
+	}()
+
+	// the complete draw found by watcher is pushed to the subscriber
+	w := newDrawWatcher(p.s.st, &fakeFetcher{awards: []*tcb.Award{testAward(18077, 8, true)}}, p.s.bus.Publish)
+	draw := time.Date(2018, 7, 8, tcb.DrawHour, tcb.DrawMinute, 0, 0, tcb.DrawLocation)
+	ok, err := w.check(context.Background(), draw)
+	p.NoError(err)
+	p.True(ok)
+
+	select {
+	case msg := <-sender.ch:
+		p.Equal("u1", msg.ToUser)
+		p.Equal("tmpl", msg.TemplateID)
+		p.Equal("双色球第 18077 期开奖结果", msg.Data["first"].Value)
+	case <-time.After(5 * time.Second):
+		p.Fail("draw is not notified")
+	}
+}
+
+func TestEventsTestSuite(t *testing.T) {
+	p := &eventsTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/version"
+)
+
+const (
+	// the draw result should be stored within delay after draw time
+	drawPublishDelay = 2 * time.Hour
+
+	statusOk          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type buildInfo struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"goVersion"`
+	StartTime time.Time `json:"startTime"`
+	Uptime    string    `json:"uptime"`
+}
+
+// Healthz report the process is alive
+func (s *server) Healthz(c *gin.Context) (interface{}, error) {
+	return map[string]string{"status": statusOk}, nil
+}
+
+// Readyz report whether the store is available and the latest draw is stored, 503 if not ready
+func (s *server) Readyz(c *gin.Context) (interface{}, error) {
+	v := &readiness{
+		Status: statusOk,
+		Checks: make(map[string]string, 2),
+	}
+
+	for name, check := range map[string]func() error{
+		"store": s.st.Ping,
+		"draw": func() error {
+			return s.checkDrawFreshness(time.Now())
+		},
+	} {
+		v.Checks[name] = statusOk
+		if err := check(); err != nil {
+			v.Checks[name] = err.Error()
+			v.Status = statusUnavailable
+		}
+	}
+
+	if v.Status != statusOk {
+		c.Status(http.StatusServiceUnavailable)
+	}
+	return v, nil
+}
+
+// checkDrawFreshness return error if the latest stored draw is older than the last scheduled draw
+func (s *server) checkDrawFreshness(now time.Time) error {
+	award, ok := s.st.LatestAward()
+	if !ok {
+		return fmt.Errorf("no draw stored")
+	}
+
+	expected := tcb.DrawDate(tcb.PrevDraw(now.Add(-drawPublishDelay)))
+	if award.AwardOpenDate.Before(expected) {
+		return fmt.Errorf("latest stored term[%d] opened at %s, expect the draw at %s",
+			award.Term, award.AwardOpenDate.Format("2006-01-02"), expected.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// BuildInfo report the version, commit, go version and start time
+func (s *server) BuildInfo(c *gin.Context) (interface{}, error) {
+	return &buildInfo{
+		Version:   version.Version,
+		Commit:    version.Commit,
+		GoVersion: runtime.Version(),
+		StartTime: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Truncate(time.Second).String(),
+	}, nil
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/version"
+	"github.com/stretchr/testify/suite"
+)
+
+type healthTestSuite struct {
+	suite.Suite
+
+	s *server
+	r *gin.Engine
+}
+
+func (p *healthTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+
+	st, err := store.Open("")
+	p.NoError(err)
+	p.s = &server{
+		c:         conf.New(),
+		st:        st,
+		startedAt: time.Now(),
+	}
+	p.s.metrics = newServerMetrics(p.s)
+	p.r = p.s.newRouter()
+}
+
+func (p *healthTestSuite) get(path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	p.NoError(json.Unmarshal(w.Body.Bytes(), v))
+	return w.Code
+}
+
+func (p *healthTestSuite) status(path string) int {
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func (p *healthTestSuite) TestHealthz() {
+	v := map[string]string{}
+	p.Equal(http.StatusOK, p.get("/healthz", &v))
+	p.Equal(statusOk, v["status"])
+}
+
+func (p *healthTestSuite) TestReadyz() {
+	v := &readiness{}
+	p.Equal(http.StatusServiceUnavailable, p.get("/readyz", v))
+	p.Equal(statusUnavailable, v.Status)
+	p.Equal(statusOk, v.Checks["store"])
+	p.Equal("no draw stored", v.Checks["draw"])
+
+	p.NoError(p.s.st.SaveAward(&tcb.Award{
+		Term:          18077,
+		AwardOpenDate: tcb.DrawDate(tcb.PrevDraw(time.Now())),
+	}))
+	v = &readiness{}
+	p.Equal(http.StatusOK, p.get("/readyz", v))
+	p.Equal(statusOk, v.Status)
+}
+
+func (p *healthTestSuite) TestDrawFreshness() {
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, tcb.DrawLocation)
+		p.NoError(err)
+		return t
+	}
+	p.NoError(p.s.st.SaveAward(&tcb.Award{
+		Term:          18076,
+		AwardOpenDate: time.Date(2018, 7, 3, 0, 0, 0, 0, time.UTC),
+	}))
+
+	p.NoError(p.s.checkDrawFreshness(at("2018-07-05 21:30")))
+	p.NoError(p.s.checkDrawFreshness(at("2018-07-05 23:14")))
+	p.Error(p.s.checkDrawFreshness(at("2018-07-05 23:16")))
+}
+
+func (p *healthTestSuite) TestBuildInfo() {
+	v := &buildInfo{}
+	p.Equal(http.StatusOK, p.get("/buildinfo", v))
+	p.Equal(version.Version, v.Version)
+	p.Equal(version.Commit, v.Commit)
+	p.NotEmpty(v.GoVersion)
+	p.True(p.s.startedAt.Equal(v.StartTime))
+}
+
+func (p *healthTestSuite) TestMetrics() {
+	v := map[string]string{}
+	p.Equal(http.StatusOK, p.get("/healthz", &v))
+	p.Equal(http.StatusNotFound, p.status("/notexists"))
+	p.NoError(p.s.st.SaveAward(&tcb.Award{
+		Term:          18077,
+		AwardOpenDate: time.Date(2018, 7, 3, 0, 0, 0, 0, time.UTC),
+	}))
+
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	p.Equal(http.StatusOK, w.Code)
+	body := w.Body.String()
+	p.Contains(body, `tyche_http_requests_total{method="GET",route="/healthz",status="200"} 1`)
+	p.Contains(body, `tyche_http_requests_total{method="GET",route="unknown",status="404"} 1`)
+	p.Contains(body, `tyche_http_request_duration_seconds_count{method="GET",route="/healthz"} 1`)
+	p.Contains(body, "tyche_latest_term 18077\n")
+	p.Contains(body, "tyche_subscribers 0\n")
+	// the default registry is exposed too
+	p.Contains(body, "# TYPE go_goroutines gauge")
+}
+
+func (p *healthTestSuite) TestSyncLag() {
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, tcb.DrawLocation)
+		p.NoError(err)
+		return t
+	}
+	p.Equal(time.Duration(0), p.s.syncLag(at("2018-07-05 21:00")))
+
+	p.NoError(p.s.st.SaveAward(&tcb.Award{
+		Term:          18077,
+		AwardOpenDate: time.Date(2018, 7, 3, 0, 0, 0, 0, time.UTC),
+	}))
+	p.Equal(time.Duration(0), p.s.syncLag(at("2018-07-05 21:00")))
+	p.Equal(30*time.Minute, p.s.syncLag(at("2018-07-05 21:45")))
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	p := &healthTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+)
+
+const (
+	// the stored latest award is refreshed from upstream after ttl
+	latestAwardTTL = 10 * time.Minute
+	// the refresh is retried after the delay if upstream failed
+	latestAwardRetry = time.Minute
+)
+
+// awardCall is a in-flight latest award fetch
+type awardCall struct {
+	done  chan struct{}
+	award *tcb.Award
+	err   error
+}
+
+// latestAward return the latest award, it is fetched from upstream when the stored one is stale.
+// The concurrent callers share one fetch, and the stored award is returned if the fetch failed or ctx done.
+func (s *server) latestAward(ctx context.Context) (*tcb.Award, error) {
+	s.awardMu.Lock()
+	stored, ok := s.st.LatestAward()
+	if ok && time.Now().Before(s.awardCheckAt) {
+		s.awardMu.Unlock()
+		return stored, nil
+	}
+	call := s.awardCall
+	if call == nil {
+		call = s.startAwardFetch()
+	}
+	s.awardMu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		if ok {
+			return stored, nil
+		}
+		return nil, ctx.Err()
+	}
+
+	if call.err != nil {
+		if ok {
+			logger.Errorf("Fetch latest award failed, use stored term[%d]: %s", stored.Term, call.err)
+			return stored, nil
+		}
+		return nil, call.err
+	}
+	return call.award, nil
+}
+
+// startAwardFetch will fetch the latest award in background, must be called with s.awardMu held.
+// It is not bound to the caller's ctx, so the other callers are not failed by the cancel of first one.
+func (s *server) startAwardFetch() *awardCall {
+	call := &awardCall{done: make(chan struct{})}
+	s.awardCall = call
+
+	go func() {
+		award, err := fetchLatest(context.Background(), s.fetcher)
+		if err == nil {
+			// do not store the wrong data scraped
+			if verr := tcb.Validate(award); verr != nil {
+				award, err = nil, ierror.Wrap(ierror.EcodeUpstreamInvalid, verr)
+			} else if _, flagged := s.st.FlaggedDraw(award.Term); flagged {
+				// the term is held by the draw watcher until the sources agree
+				award, err = nil, ierror.Wrapf(ierror.EcodeUpstreamInvalid, errors.New("flagged by reconciliation"), "term[%d]", award.Term)
+			}
+		}
+		if err == nil {
+			if serr := s.st.SaveAward(award); serr != nil {
+				logger.Errorf("Save latest award term[%d] failed: %s", award.Term, serr)
+			}
+		}
+
+		s.awardMu.Lock()
+		if err == nil {
+			s.awardCheckAt = time.Now().Add(latestAwardTTL)
+		} else {
+			s.awardCheckAt = time.Now().Add(latestAwardRetry)
+		}
+		s.awardCall = nil
+		s.awardMu.Unlock()
+
+		call.award, call.err = award, err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// fetchLatest will fetch the award at the latest term of f
+func fetchLatest(ctx context.Context, f tcb.Fetcher) (*tcb.Award, error) {
+	terms, err := f.FetchTermList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("term list of %s is empty", f.Name())
+	}
+	return f.FetchFromTerm(ctx, terms[len(terms)-1])
+}
+
+// checkTicket return the result line of ticket at award
+func checkTicket(t *tcb.Ticket, award *tcb.Award) string {
+	level, _, _ := t.Check(award)
+	result := level.String()
+	if level != tcb.NoAward {
+		if piece := award.Piece(level); piece != nil && piece.Bonus > 0 {
+			result = fmt.Sprintf("%s %d 元", result, piece.Bonus)
+		}
+	}
+	return fmt.Sprintf("%s  %s", t, result)
+}
+
+// ticketResults return the result lines of favourites and bets at award
+func ticketResults(p *store.Profile, award *tcb.Award) []string {
+	lines := make([]string, 0, len(p.Favourites)+len(p.Bets))
+	for i := range p.Favourites {
+		lines = append(lines, checkTicket(&p.Favourites[i], award))
+	}
+	for i := range p.Bets {
+		if p.Bets[i].Term == award.Term {
+			lines = append(lines, checkTicket(&p.Bets[i].Ticket, award))
+		}
+	}
+	return lines
+}
+
+// checkSubscriberTickets is the notify.TicketChecker of subscribers
+func (s *server) checkSubscriberTickets(openid string, award *tcb.Award) []string {
+	p := s.st.Profile(openid)
+	if !p.Preferences.NotifyTickets {
+		return nil
+	}
+	return ticketResults(&p, award)
+}
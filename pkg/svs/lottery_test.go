@@ -0,0 +1,165 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/stretchr/testify/suite"
+)
+
+// gateFetcher is fakeFetcher which block FetchFromTerm until gate closed
+type gateFetcher struct {
+	*fakeFetcher
+	gate chan struct{}
+}
+
+func (f *gateFetcher) FetchFromTerm(ctx context.Context, term uint32) (*tcb.Award, error) {
+	<-f.gate
+	return f.fakeFetcher.FetchFromTerm(ctx, term)
+}
+
+type lotteryTestSuite struct {
+	suite.Suite
+
+	s *server
+}
+
+func (p *lotteryTestSuite) SetupTest() {
+	st, err := store.Open("")
+	p.NoError(err)
+	p.s = &server{st: st}
+}
+
+func (p *lotteryTestSuite) calls(f *fakeFetcher) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (p *lotteryTestSuite) TestLatestAwardShared() {
+	p.NoError(p.s.st.SaveAward(testAward(18076, 5, true)))
+	f := &gateFetcher{
+		fakeFetcher: &fakeFetcher{awards: []*tcb.Award{testAward(18077, 8, true)}},
+		gate:        make(chan struct{}),
+	}
+	p.s.fetcher = f
+
+	// the stored award is returned if ctx done before the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	award, err := p.s.latestAward(ctx)
+	p.NoError(err)
+	p.Equal(uint32(18076), award.Term)
+
+	// the concurrent callers wait for the same fetch, the lock is not held while fetching
+	var wg sync.WaitGroup
+	terms := make([]uint32, 3)
+	for i := range terms {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			award, err := p.s.latestAward(context.Background())
+			p.NoError(err)
+			terms[i] = award.Term
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	p.s.awardMu.Lock()
+	p.NotNil(p.s.awardCall)
+	p.s.awardMu.Unlock()
+	close(f.gate)
+	wg.Wait()
+
+	p.Equal([]uint32{18077, 18077, 18077}, terms)
+	p.Equal(1, p.calls(f.fakeFetcher))
+	stored, ok := p.s.st.LatestAward()
+	p.True(ok)
+	p.Equal(uint32(18077), stored.Term)
+
+	// fresh until ttl
+	award, err = p.s.latestAward(context.Background())
+	p.NoError(err)
+	p.Equal(uint32(18077), award.Term)
+	p.Equal(1, p.calls(f.fakeFetcher))
+}
+
+func (p *lotteryTestSuite) TestLatestAwardFailed() {
+	p.NoError(p.s.st.SaveAward(testAward(18076, 5, true)))
+	f := &fakeFetcher{
+		awards: []*tcb.Award{testAward(18077, 8, true), testAward(18077, 8, true)},
+		errs:   []error{errors.New("timeout")},
+	}
+	p.s.fetcher = f
+
+	award, err := p.s.latestAward(context.Background())
+	p.NoError(err)
+	p.Equal(uint32(18076), award.Term)
+	p.Equal(1, p.calls(f))
+
+	// the failed fetch is not retried on each call
+	award, err = p.s.latestAward(context.Background())
+	p.NoError(err)
+	p.Equal(uint32(18076), award.Term)
+	p.Equal(1, p.calls(f))
+	p.True(p.s.awardCheckAt.Before(time.Now().Add(latestAwardRetry + time.Second)))
+
+	p.s.awardCheckAt = time.Time{}
+	award, err = p.s.latestAward(context.Background())
+	p.NoError(err)
+	p.Equal(uint32(18077), award.Term)
+}
+
+func (p *lotteryTestSuite) TestLatestAwardFlagged() {
+	p.NoError(p.s.st.SaveAward(testAward(18076, 5, true)))
+	p.NoError(p.s.st.FlagDraw(&tcb.Reconciliation{Term: 18077, Diffs: []tcb.Diff{{Field: "number"}}}))
+	p.s.fetcher = &fakeFetcher{awards: []*tcb.Award{testAward(18077, 8, true)}}
+
+	award, err := p.s.latestAward(context.Background())
+	p.NoError(err)
+	p.Equal(uint32(18076), award.Term)
+	_, ok := p.s.st.Award(18077)
+	p.False(ok)
+}
+
+func (p *lotteryTestSuite) TestLatestAwardSaveFailed() {
+	dir, err := ioutil.TempDir("", "tyche-lottery")
+	p.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// the store dir is replaced by a file, so the flush failed
+	p.s.st, err = store.Open(filepath.Join(dir, "data", "tyche.json"))
+	p.NoError(err)
+	p.NoError(ioutil.WriteFile(filepath.Join(dir, "data"), nil, 0600))
+	p.s.fetcher = &fakeFetcher{awards: []*tcb.Award{testAward(18077, 8, true)}}
+
+	award, err := p.s.latestAward(context.Background())
+	p.NoError(err)
+	p.Equal(uint32(18077), award.Term)
+}
+
+func TestLotteryTestSuite(t *testing.T) {
+	p := &lotteryTestSuite{}
+	suite.Run(t, p)
+}
@@ -28,5 +28,8 @@ func Main() {
 		os.Exit(1)
 	}
 
-	<-ch
+	if err := <-ch; err != nil {
+		fmt.Fprintf(os.Stderr, "Error At Server Shutdown: %s", err.Error())
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	keyRoute = "route"
+
+	// route label of requests which match no registered route
+	routeUnknown = "unknown"
+)
+
+// serverMetrics is registered in its own registry, the package level metrics are in the default one
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	requests   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	wxMessages *prometheus.CounterVec
+}
+
+func newServerMetrics(s *server) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tyche_http_requests_total",
+			Help: "Total http requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "tyche_http_request_duration_seconds",
+			Help: "Http request latency by route and method.",
+		}, []string{"route", "method"}),
+		wxMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tyche_wechat_messages_total",
+			Help: "Total wechat messages by MsgType and command.",
+		}, []string{"msg_type", "command"}),
+	}
+
+	m.registry.MustRegister(m.requests, m.duration, m.wxMessages,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tyche_latest_term",
+			Help: "Term of the latest stored draw.",
+		}, func() float64 {
+			award, ok := s.st.LatestAward()
+			if !ok {
+				return 0
+			}
+			return float64(award.Term)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tyche_sync_lag_seconds",
+			Help: "Seconds since the earliest scheduled draw which is not stored.",
+		}, func() float64 {
+			return s.syncLag(time.Now()).Seconds()
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tyche_subscribers",
+			Help: "Number of draw result subscribers.",
+		}, func() float64 {
+			return float64(len(s.st.Subscribers()))
+		}),
+	)
+	return m
+}
+
+// syncLag return the duration since the earliest scheduled draw which is not stored
+func (s *server) syncLag(now time.Time) time.Duration {
+	award, ok := s.st.LatestAward()
+	if !ok {
+		return 0
+	}
+
+	opened := award.AwardOpenDate
+	next := tcb.NextDraw(time.Date(opened.Year(), opened.Month(), opened.Day(), tcb.DrawHour, tcb.DrawMinute, 0, 0, tcb.DrawLocation))
+	if next.After(now) {
+		return 0
+	}
+	return now.Sub(next)
+}
+
+// route will register handlers with the route path recorded for metrics,
+// gin of this version can not report the matched route path of request
+func route(g *gin.RouterGroup, method string, relativePath string, handlers ...gin.HandlerFunc) {
+	full := path.Join(g.BasePath(), relativePath)
+	g.Handle(method, relativePath, append([]gin.HandlerFunc{func(c *gin.Context) {
+		c.Set(keyRoute, full)
+	}}, handlers...)...)
+}
+
+func metricsMiddleware(m *serverMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		r := c.GetString(keyRoute)
+		if r == "" {
+			r = routeUnknown
+		}
+		m.requests.WithLabelValues(r, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.duration.WithLabelValues(r, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Metrics expose the metrics of default registry and server in prometheus text format
+func (s *server) Metrics(c *gin.Context) {
+	promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, s.metrics.registry},
+		promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
@@ -15,24 +15,94 @@
 package svs
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
 	"github.com/gin-gonic/gin"
-	"github.com/lsytj0413/ena/cerror"
 	"github.com/lsytj0413/ena/logger"
 	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/structlog"
+)
+
+const (
+	headerRequestID = "X-Request-ID"
+	keyRequestID    = "requestId"
+	keyOpenID       = "openid"
+	keyErrorCode    = "errorCode"
 )
 
+// errorResponse is the error envelope of json api
+type errorResponse struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	Cause     string `json:"cause,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(headerRequestID)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		c.Set(keyRequestID, id)
+		c.Writer.Header().Set(headerRequestID, id)
+
+		c.Next()
+	}
+}
+
+// requestLang return the message language of request, from lang query or Accept-Language header
+func requestLang(c *gin.Context) string {
+	if lang := c.Query("lang"); lang != "" {
+		return lang
+	}
+	return c.GetHeader("Accept-Language")
+}
+
 func errorMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if nerr := recover(); nerr != nil {
-				if err, ok := nerr.(error); ok {
-					var cerr *cerror.Error
-					var ok bool
-					if cerr, ok = err.(*cerror.Error); !ok {
-						cerr = ierror.NewError(ierror.EcodeUnknown, err.Error())
-					}
-					cerr.WriteTo(c.Writer)
+				err, ok := nerr.(error)
+				if !ok {
+					err = ierror.NewError(ierror.EcodeUnknown, fmt.Sprint(nerr))
 				}
+				code, cause := ierror.Code(err)
+				c.Set(keyErrorCode, code)
+				c.Error(err)
+
+				id := c.GetString(keyRequestID)
+				status := ierror.StatusCode(code)
+				if status >= 500 {
+					logger.Errorf("Request[%s] %s failed: %s\n%s", id, c.Request.URL.String(), err, debug.Stack())
+				}
+				if c.Writer.Written() {
+					logger.Errorf("Request[%s] %s failed after response written: %s", id, c.Request.URL.String(), err)
+					c.Abort()
+					return
+				}
+
+				data, _ := json.Marshal(&errorResponse{
+					Code:      code,
+					Message:   ierror.MessageIn(code, requestLang(c)),
+					Cause:     cause,
+					RequestID: id,
+				})
+				c.Writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				c.Writer.WriteHeader(status)
+				c.Writer.Write(data)
+				c.Abort()
 			}
 		}()
 
@@ -40,11 +110,43 @@ func errorMiddleware() gin.HandlerFunc {
 	}
 }
 
-func logMiddleware() gin.HandlerFunc {
+// accessLogMiddleware will log the request after completion, the errorCode and error fields
+// are set when errorMiddleware handles an error
+func accessLogMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		logger.Infof("Accept Request, url=%s", c.Request.URL.String())
+		start := time.Now()
 
 		c.Next()
+
+		r := c.GetString(keyRoute)
+		if r == "" {
+			r = routeUnknown
+		}
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		fields := []structlog.Field{
+			structlog.F("requestId", c.GetString(keyRequestID)),
+			structlog.F("method", c.Request.Method),
+			structlog.F("route", r),
+			structlog.F("path", c.Request.URL.Path),
+			structlog.F("status", c.Writer.Status()),
+			structlog.F("latency", time.Since(start)),
+			structlog.F("bytes", size),
+			structlog.F("clientIp", c.ClientIP()),
+		}
+		if name := c.GetString(keyAPIKey); name != "" {
+			fields = append(fields, structlog.F("apiKey", name))
+		}
+		if openid := c.GetString(keyOpenID); openid != "" {
+			fields = append(fields, structlog.F("openid", openid))
+		}
+		if code, ok := c.Get(keyErrorCode); ok {
+			fields = append(fields, structlog.F("errorCode", code), structlog.F("error", c.Errors.Last().Err))
+		}
+
+		structlog.Log("access", fields...)
 	}
 }
 
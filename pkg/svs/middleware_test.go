@@ -0,0 +1,192 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/structlog"
+	"github.com/stretchr/testify/suite"
+)
+
+type middlewareTestSuite struct {
+	suite.Suite
+
+	r   *gin.Engine
+	log *bytes.Buffer
+}
+
+func (p *middlewareTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+
+	p.log = &bytes.Buffer{}
+	structlog.SetOutput(p.log)
+	p.NoError(structlog.SetFormat(structlog.FormatJSON))
+
+	p.r = gin.New()
+	p.r.Use(requestIDMiddleware(), accessLogMiddleware(), errorMiddleware())
+	api := p.r.Group("/", jsonRespMiddleware())
+	api.GET("/ok", wrapperHandler(func(c *gin.Context) (interface{}, error) {
+		return map[string]string{"Name": "svs"}, nil
+	}))
+	api.GET("/ierror", wrapperHandler(func(c *gin.Context) (interface{}, error) {
+		return nil, ierror.NewError(ierror.EcodeRequestParam, "term is required")
+	}))
+	api.GET("/wrap", wrapperHandler(func(c *gin.Context) (interface{}, error) {
+		return nil, ierror.Wrap(ierror.EcodeUpstreamFetch, errors.New("connection refused"))
+	}))
+	api.GET("/error", wrapperHandler(func(c *gin.Context) (interface{}, error) {
+		return nil, errors.New("unexpected")
+	}))
+	api.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	api.GET("/written", func(c *gin.Context) {
+		c.Writer.WriteHeader(http.StatusAccepted)
+		c.Writer.Write([]byte("partial"))
+		panic(errors.New("after written"))
+	})
+}
+
+func (p *middlewareTestSuite) TearDownTest() {
+	structlog.SetOutput(os.Stdout)
+	structlog.SetFormat(structlog.FormatText)
+}
+
+// accessLog return the last access log line
+func (p *middlewareTestSuite) accessLog() map[string]interface{} {
+	lines := strings.Split(strings.TrimSpace(p.log.String()), "\n")
+	v := map[string]interface{}{}
+	p.NoError(json.Unmarshal([]byte(lines[len(lines)-1]), &v))
+	p.Equal("access", v["msg"])
+	return v
+}
+
+func (p *middlewareTestSuite) TestAccessLog() {
+	w, _ := p.do("/ok", map[string]string{headerRequestID: "req-1"})
+	v := p.accessLog()
+	p.Equal("req-1", v["requestId"])
+	p.Equal("GET", v["method"])
+	p.Equal("/ok", v["path"])
+	p.Equal(float64(http.StatusOK), v["status"])
+	p.Equal(float64(w.Body.Len()), v["bytes"])
+	p.NotContains(v, "errorCode")
+
+	_, resp := p.do("/ierror", map[string]string{headerRequestID: "req-2"})
+	v = p.accessLog()
+	p.Equal(resp.RequestID, v["requestId"])
+	p.Equal(float64(http.StatusBadRequest), v["status"])
+	p.Equal(float64(resp.Code), v["errorCode"])
+	p.Contains(v["error"], "term is required")
+}
+
+func (p *middlewareTestSuite) do(path string, header map[string]string) (*httptest.ResponseRecorder, *errorResponse) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, req)
+
+	resp := &errorResponse{}
+	if w.Code >= 400 {
+		p.NoError(json.Unmarshal(w.Body.Bytes(), resp))
+		p.Equal(w.Header().Get(headerRequestID), resp.RequestID)
+	}
+	return w, resp
+}
+
+func (p *middlewareTestSuite) TestOk() {
+	w, _ := p.do("/ok", nil)
+	p.Equal(http.StatusOK, w.Code)
+	p.Equal("application/json; charset=UTF-8", w.Header().Get("Content-Type"))
+	p.JSONEq(`{"Name":"svs"}`, w.Body.String())
+	p.Len(w.Header().Get(headerRequestID), 32)
+}
+
+func (p *middlewareTestSuite) TestRequestIDPropagated() {
+	w, _ := p.do("/ok", map[string]string{headerRequestID: "abc"})
+	p.Equal("abc", w.Header().Get(headerRequestID))
+}
+
+func (p *middlewareTestSuite) TestIError() {
+	w, resp := p.do("/ierror", nil)
+	p.Equal(http.StatusBadRequest, w.Code)
+	p.Equal("application/json; charset=UTF-8", w.Header().Get("Content-Type"))
+	p.Equal(ierror.EcodeRequestParam, resp.Code)
+	p.Equal(ierror.Message(ierror.EcodeRequestParam), resp.Message)
+	p.Equal("term is required", resp.Cause)
+}
+
+func (p *middlewareTestSuite) TestWrappedError() {
+	w, resp := p.do("/wrap", nil)
+	p.Equal(http.StatusBadGateway, w.Code)
+	p.Equal(ierror.EcodeUpstreamFetch, resp.Code)
+	p.Equal("connection refused", resp.Cause)
+}
+
+func (p *middlewareTestSuite) TestMessageLang() {
+	_, resp := p.do("/ierror?lang=zh", nil)
+	p.Equal(ierror.MessageIn(ierror.EcodeRequestParam, ierror.LangZH), resp.Message)
+
+	_, resp = p.do("/ierror", map[string]string{"Accept-Language": "zh-CN,zh;q=0.9"})
+	p.Equal(ierror.MessageIn(ierror.EcodeRequestParam, ierror.LangZH), resp.Message)
+
+	_, resp = p.do("/ierror", map[string]string{"Accept-Language": "en-US"})
+	p.Equal(ierror.Message(ierror.EcodeRequestParam), resp.Message)
+}
+
+func (p *middlewareTestSuite) TestError() {
+	w, resp := p.do("/error", nil)
+	p.Equal(http.StatusInternalServerError, w.Code)
+	p.Equal(ierror.EcodeUnknown, resp.Code)
+	p.Equal("unexpected", resp.Cause)
+}
+
+func (p *middlewareTestSuite) TestNonErrorPanic() {
+	w, resp := p.do("/panic", nil)
+	p.Equal(http.StatusInternalServerError, w.Code)
+	p.Equal(ierror.EcodeUnknown, resp.Code)
+	p.Equal("boom", resp.Cause)
+}
+
+func (p *middlewareTestSuite) TestPanicAfterWritten() {
+	req := httptest.NewRequest(http.MethodGet, "/written", nil)
+	w := httptest.NewRecorder()
+	p.r.ServeHTTP(w, req)
+
+	p.Equal(http.StatusAccepted, w.Code)
+	p.Equal("partial", w.Body.String())
+}
+
+func (p *middlewareTestSuite) TestStatusMapping() {
+	p.Equal(http.StatusNotFound, ierror.StatusCode(ierror.EcodeTermNotFound))
+	p.Equal(http.StatusTooManyRequests, ierror.StatusCode(ierror.EcodeRateLimited))
+	p.Equal(http.StatusInternalServerError, ierror.StatusCode(0))
+}
+
+func TestMiddlewareTestSuite(t *testing.T) {
+	p := &middlewareTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/structlog"
+)
+
+const (
+	// interval to check the config, certificate and CRL files modification
+	reloadInterval = 10 * time.Second
+)
+
+// config return the current config
+func (s *server) config() *conf.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.c
+}
+
+// loadConfig will load and validate a new config from args, env and config file
+func (s *server) loadConfig() (*conf.Config, error) {
+	v := &server{
+		c: conf.New(),
+	}
+	v.fs = newFlagSet(v)
+	if err := parseArgs(v, s.args); err != nil {
+		return nil, err
+	}
+	if err := validateConfig(v.c); err != nil {
+		return nil, err
+	}
+
+	return v.c, nil
+}
+
+// reload will apply the reloadable config: tls certificates, wechat secrets, debug log and draw suspensions.
+// The others need restart to take effect.
+func (s *server) reload() error {
+	c, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	old := s.config()
+
+	if changed := restartRequired(old, c); len(changed) > 0 {
+		logger.Infof("Changes of %s need restart to take effect", strings.Join(changed, ", "))
+	}
+
+	if s.tls != nil {
+		if err := s.tls.reload(c.ClientTLSInfo); err != nil {
+			return err
+		}
+	}
+
+	wxs, err := newWxState(c, s.wechat())
+	if err != nil {
+		return err
+	}
+
+	if c.IsDebug != old.IsDebug {
+		if c.IsDebug {
+			logger.SetLogLevel(logger.DebugLevel)
+		} else {
+			logger.SetLogLevel(logger.InfoLevel)
+		}
+	}
+
+	structlog.SetFormat(c.LogFormat)
+	// it is validated by loadConfig
+	suspensions, _ := parseSuspensions(c)
+	tcb.SetSuspensions(suspensions)
+
+	s.mu.Lock()
+	s.c = c
+	s.wxs = wxs
+	s.mu.Unlock()
+
+	logger.Infof("Reload config: %s", c)
+	return nil
+}
+
+// restartRequired return the changed flag names which can not be reloaded
+func restartRequired(old *conf.Config, c *conf.Config) []string {
+	changed := make([]string, 0)
+	for name, v := range map[string]bool{
+		"listen-client-url":   c.DefaultListenClientURL != old.DefaultListenClientURL,
+		"pprof":               c.IsPprof != old.IsPprof,
+		"data-dir":            c.DataDir != old.DataDir,
+		"cache-dir":           c.CacheDir != old.CacheDir,
+		"notify-rate":         c.NotifyRate != old.NotifyRate,
+		"event-outbox":        c.EventOutbox != old.EventOutbox,
+		"reconcile":           c.Reconcile != old.Reconcile,
+		"wx-draw-template-id": c.WxDrawTemplateID != old.WxDrawTemplateID,
+		"rate-limit":          c.RateLimit != old.RateLimit,
+		"rate-burst":          c.RateBurst != old.RateBurst,
+		"fetch-timeout":       c.FetchTimeout != old.FetchTimeout,
+		"fetch-retries":       c.FetchRetries != old.FetchRetries,
+		"fetch-rate":          c.FetchRate != old.FetchRate,
+		"fetch-concurrency":   c.FetchConcurrency != old.FetchConcurrency,
+		"user-agent":          c.UserAgent != old.UserAgent,
+		"ignore-robots":       c.IgnoreRobots != old.IgnoreRobots,
+		"fetch-proxy":         c.FetchProxy != old.FetchProxy,
+		"fetch-ca-file":       c.FetchCAFile != old.FetchCAFile,
+		"fetch-headers":       !reflect.DeepEqual(c.FetchHeaders, old.FetchHeaders),
+	} {
+		if v {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// watchedFiles return the config file and tls files
+func (s *server) watchedFiles() []string {
+	files := make([]string, 0, 5)
+	if s.configFile != "" {
+		files = append(files, s.configFile)
+	}
+	if s.tls != nil {
+		files = append(files, s.tls.files()...)
+	}
+	return files
+}
+
+func fileModTimes(files []string) map[string]time.Time {
+	v := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			v[f] = info.ModTime()
+		}
+	}
+	return v
+}
+
+func modTimesChanged(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for k, v := range a {
+		if !v.Equal(b[k]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchReload will reload config on SIGHUP or the watched files changed, until ctx done
+func (s *server) watchReload(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	modTimes := fileModTimes(s.watchedFiles())
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			logger.Infof("Receive SIGHUP, reloading config")
+		case <-ticker.C:
+			if !modTimesChanged(modTimes, fileModTimes(s.watchedFiles())) {
+				continue
+			}
+			logger.Infof("Config or certificate file changed, reloading config")
+		}
+
+		if err := s.reload(); err != nil {
+			logger.Errorf("Reload config failed, keep the current config: %s", err)
+		}
+		modTimes = fileModTimes(s.watchedFiles())
+	}
+}
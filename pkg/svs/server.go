@@ -16,73 +16,179 @@ package svs
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	"github.com/lsytj0413/ena/logger"
 	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/lifecycle"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/notify"
+	"github.com/lsytj0413/tyche/pkg/ratelimit"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/structlog"
+	"github.com/lsytj0413/tyche/pkg/util"
+	"github.com/lsytj0413/tyche/pkg/webhook"
 )
 
 // Server is svs proj server
 type Server interface {
-	Start() (chan struct{}, error)
+	// Start will start the server, the returned channel receive the error (nil if none) after shutdown
+	Start() (chan error, error)
 }
 
 type server struct {
-	c  *conf.Config
-	fs *flag.FlagSet
-
-	stop chan struct{}
+	c          *conf.Config
+	fs         *flag.FlagSet
+	configFile string
+	args       []string
+
+	// mu guards the reloadable c and wxs
+	mu  sync.RWMutex
+	wxs *wxState
+	tls *tlsReloader
+
+	st       *store.Store
+	cache    *util.Cache
+	bus      *event.Bus
+	webhooks *webhook.Dispatcher
+	stream   *drawStream
+	notifier *notify.Notifier
+	watcher  *drawWatcher
+	// fetcher is the upstream of latest award and draw watcher
+	fetcher tcb.Fetcher
+
+	awardMu sync.Mutex
+	// awardCheckAt is the time after which the stored latest award is refreshed
+	awardCheckAt time.Time
+	awardCall    *awardCall
+
+	replies *wxReplies
+	// authFailures limit the auth failures of each client ip, nil if rate limit is disabled
+	authFailures *ratelimit.Keyed
+
+	lc        *lifecycle.Manager
+	startedAt time.Time
+	metrics   *serverMetrics
 }
 
 var (
 	usageline = ``
 )
 
+const (
+	// max duration to drain http requests and stop background workers
+	shutdownTimeout = 60 * time.Second
+)
+
 // New will construct a Server instance
 func New() (Server, error) {
 	s := &server{
-		c:  conf.New(),
-		fs: flag.NewFlagSet("svs", flag.ContinueOnError),
+		c: conf.New(),
 	}
+	s.fs = newFlagSet(s)
 	s.fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, usageline)
+		fmt.Fprint(os.Stderr, usageline)
 	}
 
+	s.replies = newWxReplies()
+	s.metrics = newServerMetrics(s)
+	return s, nil
+}
+
+// newFlagSet will construct the FlagSet which bind to s.c
+func newFlagSet(s *server) *flag.FlagSet {
+	fs := flag.NewFlagSet("svs", flag.ContinueOnError)
+
 	c := s.c
-	s.fs.StringVar(&c.DefaultListenClientURL, "listen-client-url", "http://localhost:80", "URL to listen on for client traffic.")
-	s.fs.StringVar(&c.Name, "name", c.Name, "Human-readable name for this member.")
-	s.fs.StringVar(&c.ClientTLSInfo.CertFile, "client-cert-file", c.ClientTLSInfo.CertFile, "Path to the client server TLS cert file.")
-	s.fs.StringVar(&c.ClientTLSInfo.KeyFile, "client-key-file", c.ClientTLSInfo.KeyFile, "Path to the client server TLS key file.")
-	s.fs.StringVar(&c.ClientTLSInfo.TrustedCAFile, "client-trusted-ca-file", c.ClientTLSInfo.TrustedCAFile, "Path to the client server TLS trusted CA cert file.")
-	s.fs.BoolVar(&c.ClientTLSInfo.ClientCertAuth, "client-cert-auth", false, "Enable client cert authentication.")
-	s.fs.BoolVar(&c.ClientTLSInfo.InsecureSkipVerify, "client-auto-tls", false, "Client TLS using generated certificate.")
-	s.fs.StringVar(&c.ClientTLSInfo.CRLFile, "client-crl-file", "", "Path to the client certificate revocation list file.")
-	s.fs.BoolVar(&c.IsDebug, "debug", false, "enable debug log output")
-	s.fs.BoolVar(&c.IsPprof, "pprof", false, "enable pprof")
+	fs.StringVar(&s.configFile, "config-file", "", "Path to the yaml or json config file.")
+	fs.StringVar(&c.DefaultListenClientURL, "listen-client-url", "http://localhost:80", "URL to listen on for client traffic.")
+	fs.StringVar(&c.Name, "name", c.Name, "Human-readable name for this member.")
+	fs.StringVar(&c.ClientTLSInfo.CertFile, "client-cert-file", c.ClientTLSInfo.CertFile, "Path to the client server TLS cert file.")
+	fs.StringVar(&c.ClientTLSInfo.KeyFile, "client-key-file", c.ClientTLSInfo.KeyFile, "Path to the client server TLS key file.")
+	fs.StringVar(&c.ClientTLSInfo.TrustedCAFile, "client-trusted-ca-file", c.ClientTLSInfo.TrustedCAFile, "Path to the client server TLS trusted CA cert file.")
+	fs.BoolVar(&c.ClientTLSInfo.ClientCertAuth, "client-cert-auth", false, "Enable client cert authentication.")
+	fs.BoolVar(&c.ClientTLSInfo.InsecureSkipVerify, "client-auto-tls", false, "Client TLS using generated certificate.")
+	fs.StringVar(&c.ClientTLSInfo.CRLFile, "client-crl-file", "", "Path to the client certificate revocation list file.")
+	fs.BoolVar(&c.IsDebug, "debug", false, "enable debug log output")
+	fs.BoolVar(&c.IsPprof, "pprof", false, "enable pprof")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Access and upstream log format, text or json.")
 
 	// wechat config
-	s.fs.StringVar(&c.WxAppID, "wx-appid", "", "wechat appid")
-	s.fs.StringVar(&c.WxAppSecret, "wx-appsecret", "", "wechat appsecret")
-	s.fs.StringVar(&c.WxToken, "wx-token", "", "wechat token")
-	s.fs.StringVar(&c.WxEncodingAESKey, "wx-aeskey", "", "wechat encoding aes key")
+	fs.StringVar(&c.WxAppID, "wx-appid", "", "wechat appid")
+	fs.StringVar(&c.WxAppSecret, "wx-appsecret", "", "wechat appsecret")
+	fs.StringVar(&c.WxToken, "wx-token", "", "wechat token")
+	fs.StringVar(&c.WxEncodingAESKey, "wx-aeskey", "", "wechat encoding aes key")
+	fs.StringVar(&c.WxDrawTemplateID, "wx-draw-template-id", "", "wechat template id of draw result notification")
+
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "Path to the data directory, empty for memory only.")
+	fs.StringVar(&c.CacheDir, "cache-dir", c.CacheDir, "Path to the upstream page cache directory, empty for the cache dir in data-dir.")
+	fs.IntVar(&c.NotifyRate, "notify-rate", c.NotifyRate, "Max draw result notifications sent per second.")
+	fs.BoolVar(&c.EventOutbox, "event-outbox", c.EventOutbox, "Keep the undelivered events in store, so they are delivered after restart.")
+	fs.BoolVar(&c.Reconcile, "reconcile", c.Reconcile, "Reconcile the draw with cwl.gov.cn before publish, the disagreed draw is held.")
+	fs.IntVar(&c.FetchTimeout, "fetch-timeout", c.FetchTimeout, "Timeout in seconds of each upstream fetch attempt, 0 for no timeout.")
+	fs.IntVar(&c.FetchRetries, "fetch-retries", c.FetchRetries, "Max retries of upstream fetch on transient errors.")
+	fs.Float64Var(&c.FetchRate, "fetch-rate", c.FetchRate, "Max upstream requests per second of each host, 0 for unlimited.")
+	fs.IntVar(&c.FetchConcurrency, "fetch-concurrency", c.FetchConcurrency, "Max in-flight upstream requests of each host, 0 for unlimited.")
+	fs.StringVar(&c.UserAgent, "user-agent", c.UserAgent, "User-Agent of upstream requests, empty for the default.")
+	fs.BoolVar(&c.IgnoreRobots, "ignore-robots", c.IgnoreRobots, "Do not check robots.txt of upstream hosts.")
+	fs.StringVar(&c.FetchProxy, "fetch-proxy", c.FetchProxy, "Proxy url (http, https or socks5) of upstream requests, empty to use HTTP_PROXY and HTTPS_PROXY.")
+	fs.StringVar(&c.FetchCAFile, "fetch-ca-file", c.FetchCAFile, "Path to the PEM bundle of CAs trusted for upstream requests, in addition to the system roots.")
+	fs.Float64Var(&c.RateLimit, "rate-limit", c.RateLimit, "Max api requests per second of each api key or client ip, 0 for unlimited.")
+	fs.IntVar(&c.RateBurst, "rate-burst", c.RateBurst, "Max api requests burst of each api key or client ip.")
+
+	return fs
+}
 
-	s.stop = make(chan struct{}, 1)
-	return s, nil
+// configureTransport will set the proxy, CAs and per-host headers of upstream client
+func configureTransport(client *util.Client, c *conf.Config) error {
+	t, err := util.NewTransport(util.TransportConfig{
+		Proxy:  c.FetchProxy,
+		CAFile: c.FetchCAFile,
+	})
+	if err != nil {
+		return err
+	}
+	client.Transport = t
+
+	client.Headers = make(map[string]http.Header, len(c.FetchHeaders))
+	for host, headers := range c.FetchHeaders {
+		h := make(http.Header, len(headers))
+		for k, v := range headers {
+			h.Set(k, v)
+		}
+		client.Headers[host] = h
+	}
+	return nil
 }
 
-func parseArgs(s *server) error {
-	err := s.fs.Parse(os.Args[1:])
+// parseSuspensions will parse the draw suspensions of config
+func parseSuspensions(c *conf.Config) ([]tcb.Suspension, error) {
+	v := make([]tcb.Suspension, 0, len(c.DrawSuspensions))
+	for _, s := range c.DrawSuspensions {
+		suspension, err := tcb.ParseSuspension(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid drawSuspensions: %s", err.Error())
+		}
+		v = append(v, suspension)
+	}
+	return v, nil
+}
+
+// parseArgs will fill config with precedence: flags > env > config file > defaults
+func parseArgs(s *server, args []string) error {
+	err := s.fs.Parse(args)
 	if err != nil {
 		return err
 	}
@@ -91,14 +197,69 @@ func parseArgs(s *server) error {
 		return fmt.Errorf("'%s' is not a valid flag", s.fs.Arg(0))
 	}
 
+	explicit := make(map[string]string)
+	s.fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if _, ok := explicit["config-file"]; !ok {
+		if v, ok := os.LookupEnv(conf.EnvName("config-file")); ok {
+			s.configFile = v
+		}
+	}
+	if s.configFile != "" {
+		if err := conf.LoadFile(s.configFile, s.c); err != nil {
+			return err
+		}
+	}
+
+	s.fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := explicit[f.Name]; ok || err != nil {
+			return
+		}
+		if v, ok := os.LookupEnv(conf.EnvName(f.Name)); ok {
+			if serr := s.fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("invalid value %q for env %s: %v", v, conf.EnvName(f.Name), serr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// flags overwrite the value from config file
+	for name, v := range explicit {
+		s.fs.Set(name, v)
+	}
+
+	return nil
+}
+
+func validateFile(name, path string) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("invalid %s: %s", name, err.Error())
+	}
 	return nil
 }
 
 func validateConfig(c *conf.Config) error {
+	if c.Name == "" {
+		return fmt.Errorf("name should not be empty")
+	}
+
 	listenURL, err := url.Parse(c.DefaultListenClientURL)
 	if err != nil {
 		return fmt.Errorf("invalid listen-client-url: %s", err.Error())
 	}
+	if listenURL.Scheme != "http" && listenURL.Scheme != "https" {
+		return fmt.Errorf("invalid listen-client-url: scheme should be http or https")
+	}
+	if listenURL.Port() == "" {
+		return fmt.Errorf("invalid listen-client-url: port should be set")
+	}
 
 	if listenURL.Scheme == "https" {
 		if c.ClientTLSInfo.CertFile == "" || c.ClientTLSInfo.KeyFile == "" {
@@ -112,12 +273,79 @@ func validateConfig(c *conf.Config) error {
 			return fmt.Errorf("client auth enable without client-trusted-ca-file or client-auto-tls set")
 		}
 	}
+	if c.ClientTLSInfo.CRLFile != "" && (!c.ClientTLSInfo.ClientCertAuth || c.ClientTLSInfo.TrustedCAFile == "") {
+		return fmt.Errorf("client-crl-file set without client-cert-auth and client-trusted-ca-file")
+	}
+
+	for name, path := range map[string]string{
+		"client-cert-file":       c.ClientTLSInfo.CertFile,
+		"client-key-file":        c.ClientTLSInfo.KeyFile,
+		"client-trusted-ca-file": c.ClientTLSInfo.TrustedCAFile,
+		"client-crl-file":        c.ClientTLSInfo.CRLFile,
+	} {
+		if err := validateFile(name, path); err != nil {
+			return err
+		}
+	}
+
+	if c.WxAppSecret != "" && c.WxAppID == "" {
+		return fmt.Errorf("wx-appsecret set without wx-appid")
+	}
+	if c.WxEncodingAESKey != "" {
+		if c.WxAppID == "" || c.WxToken == "" {
+			return fmt.Errorf("wx-aeskey set without wx-appid or wx-token")
+		}
+		if len(c.WxEncodingAESKey) != 43 {
+			return fmt.Errorf("invalid wx-aeskey: length should be 43")
+		}
+	}
+	if c.WxDrawTemplateID != "" && (c.WxAppID == "" || c.WxAppSecret == "") {
+		return fmt.Errorf("wx-draw-template-id set without wx-appid or wx-appsecret")
+	}
+
+	if err := structlog.ValidFormat(c.LogFormat); err != nil {
+		return err
+	}
+
+	if err := validateAPIKeys(c.APIKeys); err != nil {
+		return err
+	}
+	if c.FetchTimeout < 0 || c.FetchRetries < 0 {
+		return fmt.Errorf("fetch-timeout and fetch-retries should not be negative")
+	}
+	if c.FetchRate < 0 || c.FetchConcurrency < 0 {
+		return fmt.Errorf("fetch-rate and fetch-concurrency should not be negative")
+	}
+	if c.FetchProxy != "" {
+		if _, err := util.ParseProxy(c.FetchProxy); err != nil {
+			return err
+		}
+	}
+	for host := range c.FetchHeaders {
+		if host == "" || strings.Contains(host, "/") {
+			return fmt.Errorf("fetchHeaders host[%s] should be host[:port]", host)
+		}
+	}
+	if _, err := parseSuspensions(c); err != nil {
+		return err
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate-limit should not be negative")
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		return fmt.Errorf("rate-burst should be positive")
+	}
+
+	if c.NotifyRate <= 0 {
+		return fmt.Errorf("notify-rate should be positive")
+	}
 
 	return nil
 }
 
-func (s *server) Start() (chan struct{}, error) {
-	if err := parseArgs(s); err != nil {
+func (s *server) Start() (chan error, error) {
+	s.args = os.Args[1:]
+	if err := parseArgs(s, s.args); err != nil {
 		return nil, err
 	}
 
@@ -128,103 +356,196 @@ func (s *server) Start() (chan struct{}, error) {
 	if err := validateConfig(s.c); err != nil {
 		return nil, err
 	}
+	structlog.SetFormat(s.c.LogFormat)
+	logger.Infof("Start %s with config: %s", s.c.Name, s.c)
 
 	return s.start()
 }
 
-func (s *server) newTLSConfig() (*tls.Config, error) {
-	tlsConfig := &tls.Config{}
-
-	if s.c.ClientTLSInfo.ClientCertAuth {
-		if s.c.ClientTLSInfo.InsecureSkipVerify {
-			tlsConfig.InsecureSkipVerify = true
-			tlsConfig.ClientAuth = tls.RequireAnyClientCert
-		} else {
-			pool := x509.NewCertPool()
-			caCrt, err := ioutil.ReadFile(s.c.ClientTLSInfo.TrustedCAFile)
-			if err != nil {
-				return nil, fmt.Errorf("client auth cafile read error: %s", err.Error())
-			}
+func (s *server) newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(requestIDMiddleware(), metricsMiddleware(s.metrics), accessLogMiddleware(), errorMiddleware())
 
-			pool.AppendCertsFromPEM(caCrt)
-			tlsConfig.ClientCAs = pool
-			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		}
+	var limiter *ratelimit.Keyed
+	if s.c.RateLimit > 0 {
+		limiter = ratelimit.NewKeyed(s.c.RateLimit, s.c.RateBurst)
+		s.authFailures = ratelimit.NewKeyed(authFailureRate, authFailureBurst)
 	}
 
-	if s.c.ClientTLSInfo.CRLFile != "" {
-		logger.Infof("ignore client crlfile: %s", s.c.ClientTLSInfo.CRLFile)
+	// probes are neither authenticated nor limited
+	probe := r.Group("/", jsonRespMiddleware())
+	route(probe, http.MethodGet, "/healthz", wrapperHandler(s.Healthz))
+	route(probe, http.MethodGet, "/readyz", wrapperHandler(s.Readyz))
+
+	api := r.Group("/", jsonRespMiddleware(), s.authMiddleware(""), rateLimitMiddleware(limiter))
+	route(api, http.MethodGet, "/version", wrapperHandler(s.Version))
+	route(api, http.MethodGet, "/", wrapperHandler(s.Index))
+	route(api, http.MethodGet, "/buildinfo", wrapperHandler(s.BuildInfo))
+	route(api, http.MethodGet, "/metrics", s.authMiddleware(scopeMetrics), s.Metrics)
+
+	keys := r.Group("/api/keys", jsonRespMiddleware(), s.authMiddleware(scopeAdmin), rateLimitMiddleware(limiter))
+	route(keys, http.MethodGet, "", wrapperHandler(s.ListAPIKeys))
+	route(keys, http.MethodPost, "", wrapperHandler(s.CreateAPIKey))
+	route(keys, http.MethodDelete, "/:name", wrapperHandler(s.DeleteAPIKey))
+
+	cache := r.Group("/api/cache", jsonRespMiddleware(), s.authMiddleware(scopeAdmin), rateLimitMiddleware(limiter))
+	route(cache, http.MethodGet, "", wrapperHandler(s.ListCache))
+	route(cache, http.MethodDelete, "", wrapperHandler(s.PurgeCache))
+
+	// the stream is not json and never ends, so it is not wrapped
+	draws := r.Group("/api/draws", s.authMiddleware(scopeRead), rateLimitMiddleware(limiter))
+	route(draws, http.MethodGet, "/stream", s.StreamDraws)
+
+	flagged := r.Group("/api/draws/flagged", jsonRespMiddleware(), s.authMiddleware(scopeAdmin), rateLimitMiddleware(limiter))
+	route(flagged, http.MethodGet, "", wrapperHandler(s.ListFlaggedDraws))
+
+	webhooks := r.Group("/api/webhooks", jsonRespMiddleware(), s.authMiddleware(scopeAdmin), rateLimitMiddleware(limiter))
+	route(webhooks, http.MethodGet, "", wrapperHandler(s.ListWebhooks))
+	route(webhooks, http.MethodPost, "", wrapperHandler(s.CreateWebhook))
+	route(webhooks, http.MethodDelete, "/:id", wrapperHandler(s.DeleteWebhook))
+	route(webhooks, http.MethodGet, "/:id/deliveries", wrapperHandler(s.ListWebhookDeliveries))
+	route(webhooks, http.MethodPost, "/:id/test", wrapperHandler(s.TestWebhook))
+
+	// wechat routes are exempted from api key, both GET and POST are authenticated by wechat signature
+	route(&r.RouterGroup, http.MethodGet, "/api/wx/mainEntry", s.WxVerify)
+	route(&r.RouterGroup, http.MethodPost, "/api/wx/mainEntry", s.WxEntry)
+
+	if s.c.IsPprof {
+		pprof.Register(r, nil)
 	}
 
-	return tlsConfig, nil
+	return r
 }
 
-func (s *server) start() (chan struct{}, error) {
-	tlsConfig, err := s.newTLSConfig()
+func (s *server) start() (chan error, error) {
+	s.startedAt = time.Now()
+
+	var err error
+	s.tls, err = newTLSReloader(s.c.ClientTLSInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	s.wxs, err = newWxState(s.c, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	util.DefaultClient.Timeout = time.Duration(s.c.FetchTimeout) * time.Second
+	util.DefaultClient.MaxRetries = s.c.FetchRetries
+	util.DefaultClient.HostRate = s.c.FetchRate
+	util.DefaultClient.HostConcurrency = s.c.FetchConcurrency
+	util.DefaultClient.IgnoreRobots = s.c.IgnoreRobots
+	if s.c.UserAgent != "" {
+		util.DefaultClient.UserAgent = s.c.UserAgent
+	}
+	if err := configureTransport(util.DefaultClient, s.c); err != nil {
+		return nil, err
+	}
+
+	storePath := ""
+	if s.c.DataDir != "" {
+		storePath = filepath.Join(s.c.DataDir, "tyche.json")
+	}
+	s.st, err = store.Open(storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheDir := s.c.CacheDir
+	if cacheDir == "" && s.c.DataDir != "" {
+		cacheDir = filepath.Join(s.c.DataDir, "cache")
+	}
+	if cacheDir != "" {
+		s.cache, err = util.NewCache(cacheDir)
+		if err != nil {
+			return nil, err
+		}
+		util.DefaultClient.Cache = s.cache
+	}
+
+	var outbox event.Outbox
+	if s.c.EventOutbox {
+		outbox = storeOutbox{s.st}
+	}
+	s.bus = event.New(outbox)
+	s.stream = newDrawStream()
+	s.webhooks = webhook.New(s.st)
+	if s.c.WxDrawTemplateID != "" {
+		s.notifier = notify.New(s.st, wxSender{s}, s.c.WxDrawTemplateID, s.c.NotifyRate)
+		s.notifier.SetTicketChecker(s.checkSubscriberTickets)
+	}
+	s.subscribe()
+	suspensions, err := parseSuspensions(s.c)
+	if err != nil {
+		return nil, err
+	}
+	tcb.SetSuspensions(suspensions)
+	s.fetcher = tcb.Source500
+	s.watcher = newDrawWatcher(s.st, s.fetcher, s.bus.Publish)
+	if s.c.Reconcile {
+		s.watcher.verifier = tcb.SourceCWL
+	}
+
 	listenURL, _ := url.Parse(s.c.DefaultListenClientURL)
 	srv := &http.Server{
 		Addr:      listenURL.Hostname() + ":" + listenURL.Port(),
-		TLSConfig: tlsConfig,
+		TLSConfig: s.tls.TLSConfig(),
 	}
 
-	r := gin.New()
-	r.Use(logMiddleware())
-	r.GET("/version", wrapperHandler(s.Version))
-	r.GET("/", wrapperHandler(s.Index))
-	r.GET("/api/wx/mainEntry", s.WxVerify)
-	r.POST("/api/wx/mainEntry", s.WxEntry)
-
-	if s.c.IsPprof {
-		pprof.Register(r, nil)
-	}
+	srv.Handler = s.newRouter()
 
-	srv.Handler = r
+	s.lc = lifecycle.New(shutdownTimeout)
 
-	ch := make(chan error, 1)
-	go func() {
+	isTLS := s.c.IsTLSEnable
+	s.lc.Go("http", func(ctx context.Context) error {
 		var err error
-		if s.c.IsTLSEnable {
+		if isTLS {
 			logger.Infof("Listening and serving HTTPS on %s", srv.Addr)
-			err = srv.ListenAndServeTLS(s.c.ClientTLSInfo.CertFile, s.c.ClientTLSInfo.KeyFile)
+			// certificate is served by TLSConfig.GetCertificate, so it can be reloaded
+			err = srv.ListenAndServeTLS("", "")
 		} else {
 			logger.Infof("Listening and serving HTTP on %s", srv.Addr)
 			err = srv.ListenAndServe()
 		}
 
-		if err != nil && err != http.ErrServerClosed {
-			logger.Errorf("Server Start Failed: %s", err)
-			ch <- err
+		if err == http.ErrServerClosed {
+			return nil
 		}
-	}()
+		return err
+	})
+	// the pending wechat replies are sent after the http server drained
+	s.replies.ctx = s.lc.Context()
+	s.lc.OnShutdown("wx-replies", s.replies.drain)
+	s.lc.OnShutdown("http", srv.Shutdown)
+	// end the streams before draining, the hooks are called in reverse order
+	s.lc.OnShutdown("draw-stream", s.stream.close)
+	s.lc.Go("reload", s.watchReload)
+	s.lc.Go("warmup", func(ctx context.Context) error {
+		// make the latest draw available before the first wechat query
+		if _, err := s.latestAward(ctx); err != nil {
+			logger.Errorf("Warm up latest award failed: %s", err)
+		}
+		return nil
+	})
+	s.lc.Go("events", s.bus.Run)
+	s.lc.Go("draw-watcher", s.watcher.run)
 
+	ch := make(chan error, 1)
 	go func() {
-		quit := make(chan os.Signal)
-		signal.Notify(quit, os.Interrupt)
-
-		var err error
-		select {
-		case <-quit:
-		case err = <-ch:
+		err := s.lc.Run(os.Interrupt, syscall.SIGTERM)
+		// the workers are stopped, so the batched changes of store are final
+		if cerr := s.st.Close(); cerr != nil {
+			logger.Errorf("Close store failed: %s", cerr)
 		}
-
-		// Close on signal
-		if err == nil {
-			logger.Infof("Shutdown Server ...")
-
-			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-			defer cancel()
-
-			if err := srv.Shutdown(ctx); err != nil {
-				logger.Errorf("Server Shutdown: ", err)
-			}
+		if err != nil {
+			logger.Errorf("Server Shutdown: %s", err)
+		} else {
+			logger.Infof("Server Shutdown")
 		}
 
-		s.stop <- struct{}{}
+		ch <- err
 	}()
 
-	return s.stop, nil
+	return ch, nil
 }
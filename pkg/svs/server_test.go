@@ -0,0 +1,158 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lsytj0413/tyche/pkg/util"
+	"github.com/stretchr/testify/suite"
+)
+
+type serverTestSuite struct {
+	suite.Suite
+
+	dir string
+	s   *server
+}
+
+func (p *serverTestSuite) SetupTest() {
+	var err error
+	p.dir, err = ioutil.TempDir("", "tyche-svs")
+	p.NoError(err)
+
+	v, err := New()
+	p.NoError(err)
+	p.s = v.(*server)
+}
+
+func (p *serverTestSuite) TearDownTest() {
+	os.RemoveAll(p.dir)
+	os.Unsetenv("TYCHE_WX_APPID")
+	os.Unsetenv("TYCHE_WX_TOKEN")
+	os.Unsetenv("TYCHE_NOTIFY_RATE")
+}
+
+func (p *serverTestSuite) TestParseArgsPrecedence() {
+	path := filepath.Join(p.dir, "tyche.yaml")
+	p.NoError(ioutil.WriteFile(path, []byte("name: file\nwxAppID: file\nwxToken: file\nwxAppSecret: file\n"), 0600))
+	os.Setenv("TYCHE_WX_APPID", "env")
+	os.Setenv("TYCHE_WX_TOKEN", "env")
+
+	p.NoError(parseArgs(p.s, []string{"-config-file", path, "-wx-appid", "flag"}))
+	p.Equal("flag", p.s.c.WxAppID)
+	p.Equal("env", p.s.c.WxToken)
+	p.Equal("file", p.s.c.WxAppSecret)
+	p.Equal("file", p.s.c.Name)
+	p.Equal("data", p.s.c.DataDir)
+}
+
+func (p *serverTestSuite) TestParseArgsInvalidEnv() {
+	os.Setenv("TYCHE_NOTIFY_RATE", "fast")
+
+	p.Error(parseArgs(p.s, []string{}))
+}
+
+func (p *serverTestSuite) TestValidateConfig() {
+	p.NoError(parseArgs(p.s, []string{}))
+	p.NoError(validateConfig(p.s.c))
+
+	c := *p.s.c
+	c.DefaultListenClientURL = "ftp://localhost:21"
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.DefaultListenClientURL = "https://localhost:443"
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.ClientTLSInfo.CRLFile = filepath.Join(p.dir, "notexists.crl")
+	p.Error(validateConfig(&c))
+
+	crl := filepath.Join(p.dir, "ca.crl")
+	p.NoError(ioutil.WriteFile(crl, []byte{}, 0600))
+	c = *p.s.c
+	c.ClientTLSInfo.CRLFile = crl
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.WxDrawTemplateID = "template"
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.WxAppID, c.WxToken, c.WxEncodingAESKey = "appid", "token", "short"
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.NotifyRate = 0
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.LogFormat = "xml"
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.DrawSuspensions = []string{"2020-01-24~2020-01-30"}
+	p.NoError(validateConfig(&c))
+	c.DrawSuspensions = []string{"2020-01-24"}
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.FetchProxy = "ftp://proxy:21"
+	p.Error(validateConfig(&c))
+
+	c = *p.s.c
+	c.FetchProxy = "socks5://127.0.0.1:1080"
+	c.FetchHeaders = map[string]map[string]string{"kaijiang.500.com": {"referer": "http://www.500.com/"}}
+	p.NoError(validateConfig(&c))
+
+	client := util.NewClient()
+	p.NoError(configureTransport(client, &c))
+	p.NotNil(client.Transport)
+	p.Equal("http://www.500.com/", client.Headers["kaijiang.500.com"].Get("Referer"))
+
+	c.FetchHeaders = map[string]map[string]string{"http://kaijiang.500.com": {}}
+	p.Error(validateConfig(&c))
+}
+
+func (p *serverTestSuite) TestReload() {
+	path := filepath.Join(p.dir, "tyche.yaml")
+	p.NoError(ioutil.WriteFile(path, []byte("wxAppID: appid\nwxAppSecret: secret\nwxToken: old\n"), 0600))
+
+	p.s.args = []string{"-config-file", path}
+	p.NoError(parseArgs(p.s, p.s.args))
+	wxs, err := newWxState(p.s.c, nil)
+	p.NoError(err)
+	p.s.wxs = wxs
+
+	p.NoError(ioutil.WriteFile(path, []byte("wxAppID: appid\nwxAppSecret: secret\nwxToken: new\n"), 0600))
+	p.NoError(p.s.reload())
+	p.Equal("new", p.s.config().WxToken)
+	p.Equal("new", p.s.wechat().token)
+	p.True(wxs.client == p.s.wechat().client)
+
+	// keep the current config on invalid file
+	p.NoError(ioutil.WriteFile(path, []byte("wxAppID: appid\nunknown: field\n"), 0600))
+	p.Error(p.s.reload())
+	p.Equal("new", p.s.config().WxToken)
+}
+
+func TestServerTestSuite(t *testing.T) {
+	p := &serverTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+)
+
+const (
+	// events queued for each client, the client is disconnected if it falls behind
+	streamBuffer = 16
+	// recent events kept for the clients reconnecting with Last-Event-ID
+	streamHistory = 32
+	// interval of the comment line which keep the idle connection alive
+	streamHeartbeat = 15 * time.Second
+
+	// the first event of stream without Last-Event-ID, data is streamSnapshot
+	streamEventSnapshot = "snapshot"
+	// sent before the snapshot if Last-Event-ID is not in history, data is streamReset
+	streamEventReset = "reset"
+)
+
+// streamTypes is the event types sent to the draw stream clients
+var streamTypes = []event.Type{
+	event.TypeDrawPending,
+	event.TypeDrawPublished,
+	event.TypePrizeTableUpdated,
+	event.TypeSyncFailed,
+}
+
+// streamSnapshot is the current state sent when the client connects
+type streamSnapshot struct {
+	// Award is the latest stored award, nil if none
+	Award *tcb.Award `json:"award"`
+	// Pending is the draw time being polled, nil if none
+	Pending  *time.Time `json:"pending,omitempty"`
+	NextDraw time.Time  `json:"nextDraw"`
+}
+
+// streamReset report the Last-Event-ID which can not be resumed, the client should
+// replace its state with the following snapshot
+type streamReset struct {
+	LastEventID string `json:"lastEventId"`
+	Error       string `json:"error"`
+}
+
+// drawStream broadcast the draw events to the Server-Sent Events clients
+type drawStream struct {
+	mu      sync.Mutex
+	clients map[chan *event.Event]bool
+	history []*event.Event
+	closed  bool
+}
+
+func newDrawStream() *drawStream {
+	return &drawStream{
+		clients: make(map[chan *event.Event]bool),
+	}
+}
+
+// Handle is the event.Handler which send e to all clients
+func (d *drawStream) Handle(ctx context.Context, e *event.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.history = append(d.history, e); len(d.history) > streamHistory {
+		d.history = append([]*event.Event(nil), d.history[len(d.history)-streamHistory:]...)
+	}
+	for ch := range d.clients {
+		select {
+		case ch <- e:
+		default:
+			// the client will reconnect with Last-Event-ID
+			delete(d.clients, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// subscribe return the channel of new events and the events after lastID in history,
+// found is false if lastID is not in history, ok is false if the stream is closed
+func (d *drawStream) subscribe(lastID string) (ch chan *event.Event, replay []*event.Event, found bool, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, nil, false, false
+	}
+	if lastID != "" {
+		for i, e := range d.history {
+			if e.ID == lastID {
+				replay = append(replay, d.history[i+1:]...)
+				found = true
+				break
+			}
+		}
+	}
+
+	ch = make(chan *event.Event, streamBuffer)
+	d.clients[ch] = true
+	return ch, replay, found, true
+}
+
+func (d *drawStream) unsubscribe(ch chan *event.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.clients[ch] {
+		delete(d.clients, ch)
+		close(ch)
+	}
+}
+
+// close will end all streams, it is called on shutdown so the http server can drain
+func (d *drawStream) close(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	for ch := range d.clients {
+		delete(d.clients, ch)
+		close(ch)
+	}
+	return nil
+}
+
+// writeSSE will write a Server-Sent Event, data is encoded as a single json line
+func writeSSE(w io.Writer, id string, name string, data interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, content)
+	return err
+}
+
+// snapshot return the current draw state
+func (s *server) snapshot(now time.Time) *streamSnapshot {
+	v := &streamSnapshot{
+		NextDraw: tcb.NextDraw(now),
+	}
+	if award, ok := s.st.LatestAward(); ok {
+		v.Award = award
+	}
+	if s.watcher != nil {
+		if draw, ok := s.watcher.pending(now); ok {
+			v.Pending = &draw
+		}
+	}
+	return v
+}
+
+// StreamDraws send the live draw events as Server-Sent Events: a snapshot, then DrawPending,
+// DrawPublished, PrizeTableUpdated and SyncFailed with the event payload as data.
+// The missed events are replayed if the client reconnect with Last-Event-ID, a reset event and
+// the snapshot are sent instead if the id is unknown, e.g. it is dropped from history or the
+// server is restarted.
+func (s *server) StreamDraws(c *gin.Context) {
+	lastID := c.GetHeader("Last-Event-ID")
+	ch, replay, found, ok := s.stream.subscribe(lastID)
+	if !ok {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	defer s.stream.unsubscribe(ch)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// disable the response buffering of nginx
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	send := func(e *event.Event) error {
+		return writeSSE(w, e.ID, string(e.Type), e.Data)
+	}
+
+	var err error
+	if lastID != "" && !found {
+		err = writeSSE(w, "", streamEventReset, &streamReset{
+			LastEventID: lastID,
+			Error:       "not found",
+		})
+	}
+	if err == nil && !found {
+		err = writeSSE(w, "", streamEventSnapshot, s.snapshot(time.Now()))
+	}
+	for _, e := range replay {
+		if err == nil {
+			err = send(e)
+		}
+	}
+	if err != nil {
+		logger.Debugf("Write draw stream failed: %s", err)
+		return
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			err = send(e)
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": ping\n\n")
+		}
+		if err != nil {
+			logger.Debugf("Write draw stream failed: %s", err)
+			return
+		}
+		w.Flush()
+	}
+}
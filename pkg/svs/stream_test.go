@@ -0,0 +1,243 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/stretchr/testify/suite"
+)
+
+// sseEvent is a parsed Server-Sent Event
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+type streamTestSuite struct {
+	suite.Suite
+
+	s   *server
+	srv *httptest.Server
+}
+
+func (p *streamTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+
+	st, err := store.Open("")
+	p.NoError(err)
+	p.s = &server{
+		c:      conf.New(),
+		st:     st,
+		stream: newDrawStream(),
+	}
+	p.s.metrics = newServerMetrics(p.s)
+	p.srv = httptest.NewServer(p.s.newRouter())
+}
+
+func (p *streamTestSuite) TearDownTest() {
+	p.s.stream.close(context.Background())
+	p.srv.Close()
+}
+
+// connect will open the stream, and return the channel of received events which is closed at EOF
+func (p *streamTestSuite) connect(lastID string) (<-chan sseEvent, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest(http.MethodGet, p.srv.URL+"/api/draws/stream", nil)
+	p.NoError(err)
+	req = req.WithContext(ctx)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	p.NoError(err)
+	p.Equal(http.StatusOK, resp.StatusCode)
+	p.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	ch := make(chan sseEvent, 16)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		r := bufio.NewReader(resp.Body)
+		e := sseEvent{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if e.name != "" {
+					ch <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = line[len("id: "):]
+			case strings.HasPrefix(line, "event: "):
+				e.name = line[len("event: "):]
+			case strings.HasPrefix(line, "data: "):
+				e.data = line[len("data: "):]
+			}
+		}
+	}()
+	return ch, cancel
+}
+
+func (p *streamTestSuite) next(ch <-chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-ch:
+		p.True(ok, "stream is closed")
+		return e
+	case <-time.After(5 * time.Second):
+		p.Fail("event is not received")
+		return sseEvent{}
+	}
+}
+
+// waitClients will wait until n clients subscribed
+func (p *streamTestSuite) waitClients(n int) {
+	for i := 0; i < 500; i++ {
+		p.s.stream.mu.Lock()
+		count := len(p.s.stream.clients)
+		p.s.stream.mu.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Fail("clients are not subscribed")
+}
+
+func (p *streamTestSuite) publish(typ event.Type, v interface{}) *event.Event {
+	e, err := event.NewEvent(typ, v)
+	p.NoError(err)
+	p.NoError(p.s.stream.Handle(context.Background(), e))
+	return e
+}
+
+func (p *streamTestSuite) TestStream() {
+	p.NoError(p.s.st.SaveAward(testAward(18076, 5, true)))
+	ch, cancel := p.connect("")
+	defer cancel()
+
+	e := p.next(ch)
+	p.Equal(streamEventSnapshot, e.name)
+	snapshot := &streamSnapshot{}
+	p.NoError(json.Unmarshal([]byte(e.data), snapshot))
+	p.Equal(uint32(18076), snapshot.Award.Term)
+	p.False(snapshot.NextDraw.IsZero())
+
+	p.waitClients(1)
+	pending := p.publish(event.TypeDrawPending, &event.DrawPending{Draw: time.Now()})
+	published := p.publish(event.TypeDrawPublished, &event.Draw{Award: testAward(18077, 8, false)})
+
+	e = p.next(ch)
+	p.Equal(pending.ID, e.id)
+	p.Equal(string(event.TypeDrawPending), e.name)
+	e = p.next(ch)
+	p.Equal(string(event.TypeDrawPublished), e.name)
+	v := &event.Draw{}
+	p.NoError(json.Unmarshal([]byte(e.data), v))
+	p.Equal(uint32(18077), v.Award.Term)
+
+	// the stream ends on shutdown
+	p.NoError(p.s.stream.close(context.Background()))
+	_, ok := <-ch
+	p.False(ok)
+	p.Len(p.s.stream.history, 2)
+	p.Equal(published.ID, p.s.stream.history[1].ID)
+}
+
+func (p *streamTestSuite) TestReconnect() {
+	first := p.publish(event.TypeDrawPending, &event.DrawPending{Draw: time.Now()})
+	second := p.publish(event.TypeDrawPublished, &event.Draw{Award: testAward(18077, 8, false)})
+
+	// the events after Last-Event-ID are replayed without snapshot
+	ch, cancel := p.connect(first.ID)
+	defer cancel()
+	e := p.next(ch)
+	p.Equal(second.ID, e.id)
+
+	p.waitClients(1)
+	third := p.publish(event.TypePrizeTableUpdated, &event.Draw{Award: testAward(18077, 8, true)})
+	p.Equal(third.ID, p.next(ch).id)
+}
+
+func (p *streamTestSuite) TestReconnectUnknownID() {
+	p.NoError(p.s.st.SaveAward(testAward(18077, 8, true)))
+	p.publish(event.TypeDrawPending, &event.DrawPending{Draw: time.Now()})
+
+	// the id is unknown, e.g. the server is restarted: report it and send the snapshot
+	ch, cancel := p.connect("unknown")
+	defer cancel()
+	e := p.next(ch)
+	p.Equal(streamEventReset, e.name)
+	reset := &streamReset{}
+	p.NoError(json.Unmarshal([]byte(e.data), reset))
+	p.Equal("unknown", reset.LastEventID)
+	p.Equal("not found", reset.Error)
+
+	e = p.next(ch)
+	p.Equal(streamEventSnapshot, e.name)
+	snapshot := &streamSnapshot{}
+	p.NoError(json.Unmarshal([]byte(e.data), snapshot))
+	p.Equal(uint32(18077), snapshot.Award.Term)
+
+	// the history is not replayed
+	p.waitClients(1)
+	published := p.publish(event.TypeDrawPublished, &event.Draw{Award: testAward(18078, 10, false)})
+	p.Equal(published.ID, p.next(ch).id)
+}
+
+func (p *streamTestSuite) TestSlowClient() {
+	ch, _, _, ok := p.s.stream.subscribe("")
+	p.True(ok)
+	for i := 0; i <= streamBuffer; i++ {
+		p.publish(event.TypeSyncFailed, &event.SyncFailed{Error: "timeout"})
+	}
+
+	// the client is dropped when its buffer is full
+	count := 0
+	for range ch {
+		count++
+	}
+	p.Equal(streamBuffer, count)
+	p.Empty(p.s.stream.clients)
+	p.s.stream.unsubscribe(ch)
+
+	p.NoError(p.s.stream.close(context.Background()))
+	_, _, _, ok = p.s.stream.subscribe("")
+	p.False(ok)
+}
+
+func TestStreamTestSuite(t *testing.T) {
+	p := &streamTestSuite{}
+	suite.Run(t, p)
+}
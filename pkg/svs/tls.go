@@ -0,0 +1,180 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/conf"
+)
+
+// tlsReloader serve the current server certificate and client CA pool,
+// they are swapped on reload without dropping the established connections.
+type tlsReloader struct {
+	mu     sync.RWMutex
+	info   conf.TLSInfo
+	cert   *tls.Certificate
+	config *tls.Config
+	crl    *crlList
+	// base is the config returned by TLSConfig, the http server add its NextProtos for ALPN
+	base *tls.Config
+}
+
+func newTLSReloader(info conf.TLSInfo) (*tlsReloader, error) {
+	r := &tlsReloader{}
+	if err := r.reload(info); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reload will load the certificate and client CA from files, the current ones are kept on error
+func (r *tlsReloader) reload(info conf.TLSInfo) error {
+	var cert *tls.Certificate
+	if info.CertFile != "" && info.KeyFile != "" {
+		v, err := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
+		if err != nil {
+			return fmt.Errorf("server certificate load error: %s", err.Error())
+		}
+		cert = &v
+	}
+
+	config := &tls.Config{
+		GetCertificate:        r.getCertificate,
+		VerifyPeerCertificate: r.verifyPeerCertificate,
+	}
+	var crl *crlList
+	if info.ClientCertAuth {
+		if info.InsecureSkipVerify {
+			config.InsecureSkipVerify = true
+			config.ClientAuth = tls.RequireAnyClientCert
+		} else {
+			caCrt, err := ioutil.ReadFile(info.TrustedCAFile)
+			if err != nil {
+				return fmt.Errorf("client auth cafile read error: %s", err.Error())
+			}
+			cas, err := parseCAs(caCrt)
+			if err != nil {
+				return fmt.Errorf("client auth cafile parse error: %s", err.Error())
+			}
+			if len(cas) == 0 {
+				return fmt.Errorf("client auth cafile contains no certificate")
+			}
+
+			pool := x509.NewCertPool()
+			for _, ca := range cas {
+				pool.AddCert(ca)
+			}
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+
+			if info.CRLFile != "" {
+				crl, err = loadCRL(info.CRLFile, cas)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.info = info
+	r.cert = cert
+	r.config = config
+	r.crl = crl
+	r.mu.Unlock()
+
+	return nil
+}
+
+// files return the files which should be watched for reload
+func (r *tlsReloader) files() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	files := make([]string, 0, 4)
+	for _, f := range []string{r.info.CertFile, r.info.KeyFile, r.info.TrustedCAFile, r.info.CRLFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (r *tlsReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cert == nil {
+		return nil, errors.New("no server certificate")
+	}
+	return r.cert, nil
+}
+
+// getConfigForClient return the current config, with NextProtos of the base config so h2 is still negotiated
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	config, base := r.config, r.base
+	r.mu.RUnlock()
+
+	if base == nil || len(base.NextProtos) == 0 {
+		return config, nil
+	}
+	config = config.Clone()
+	config.NextProtos = append([]string(nil), base.NextProtos...)
+	return config, nil
+}
+
+// verifyPeerCertificate will reject the client certificate which is revoked by the current CRL
+func (r *tlsReloader) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	r.mu.RLock()
+	crl := r.crl
+	r.mu.RUnlock()
+
+	if crl == nil {
+		return nil
+	}
+
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if crl.isRevoked(cert) {
+				logger.Infof("Reject revoked client certificate %s, serial %s", cert.Subject, cert.SerialNumber)
+				return fmt.Errorf("client certificate serial %s is revoked", cert.SerialNumber)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TLSConfig return the tls.Config which always use the current certificate and client CA pool
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		GetCertificate:     r.getCertificate,
+		GetConfigForClient: r.getConfigForClient,
+	}
+
+	r.mu.Lock()
+	r.base = base
+	r.mu.Unlock()
+	return base
+}
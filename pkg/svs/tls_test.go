@@ -0,0 +1,294 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/stretchr/testify/suite"
+)
+
+type tlsTestSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (p *tlsTestSuite) SetupTest() {
+	var err error
+	p.dir, err = ioutil.TempDir("", "tyche-tls")
+	p.NoError(err)
+}
+
+func (p *tlsTestSuite) TearDownTest() {
+	os.RemoveAll(p.dir)
+}
+
+// newCert will generate a certificate signed by parent, it is self-signed if parent is nil
+func (p *tlsTestSuite) newCert(serial int64, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p.NoError(err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		tmpl.Subject.CommonName = fmt.Sprintf("tyche ca %d", serial)
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	p.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	p.NoError(err)
+	return cert, key
+}
+
+func (p *tlsTestSuite) writePEM(name string, typ string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	p.NoError(ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return path
+}
+
+// newCRL will generate the DER encoded CRL which revoke serials signed by ca
+func (p *tlsTestSuite) newCRL(ca *x509.Certificate, caKey *ecdsa.PrivateKey, serials ...int64) []byte {
+	revoked := make([]x509.RevocationListEntry, 0, len(serials))
+	for _, serial := range serials {
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(time.Now().UnixNano()),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: revoked,
+	}, ca, caKey)
+	p.NoError(err)
+	return der
+}
+
+// writeCRL will write the PEM encoded CRL which revoke serials signed by ca
+func (p *tlsTestSuite) writeCRL(name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serials ...int64) string {
+	return p.writePEM(name, "X509 CRL", p.newCRL(ca, caKey, serials...))
+}
+
+// writeCert will generate a self-signed certificate with serial and write it to cert.pem/key.pem
+func (p *tlsTestSuite) writeCert(serial int64) conf.TLSInfo {
+	cert, key := p.newCert(serial, false, nil, nil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	p.NoError(err)
+
+	return conf.TLSInfo{
+		CertFile: p.writePEM("cert.pem", "CERTIFICATE", cert.Raw),
+		KeyFile:  p.writePEM("key.pem", "EC PRIVATE KEY", keyDer),
+	}
+}
+
+// handshake will run a tls handshake with client certificate, return the server side error
+func (p *tlsTestSuite) handshake(r *tlsReloader, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	c, s := net.Pipe()
+	defer c.Close()
+
+	client := tls.Client(c, &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates: []tls.Certificate{
+			{Certificate: [][]byte{cert.Raw}, PrivateKey: key},
+		},
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Handshake()
+		// drain the alert or session ticket sent by server
+		client.Read(make([]byte, 1))
+	}()
+
+	server := tls.Server(s, r.TLSConfig())
+	err := server.Handshake()
+	s.Close()
+	<-done
+	return err
+}
+
+func (p *tlsTestSuite) serial(r *tlsReloader) int64 {
+	cert, err := r.TLSConfig().GetCertificate(nil)
+	p.NoError(err)
+
+	v, err := x509.ParseCertificate(cert.Certificate[0])
+	p.NoError(err)
+	return v.SerialNumber.Int64()
+}
+
+func (p *tlsTestSuite) TestReload() {
+	info := p.writeCert(1)
+	r, err := newTLSReloader(info)
+	p.NoError(err)
+	p.Equal(int64(1), p.serial(r))
+	p.Equal([]string{info.CertFile, info.KeyFile}, r.files())
+
+	p.writeCert(2)
+	p.NoError(r.reload(info))
+	p.Equal(int64(2), p.serial(r))
+
+	// keep the current certificate on error
+	p.NoError(ioutil.WriteFile(info.KeyFile, []byte("broken"), 0600))
+	p.Error(r.reload(info))
+	p.Equal(int64(2), p.serial(r))
+}
+
+func (p *tlsTestSuite) TestClientCAReload() {
+	info := p.writeCert(1)
+	info.ClientCertAuth = true
+	info.TrustedCAFile = info.CertFile
+
+	r, err := newTLSReloader(info)
+	p.NoError(err)
+	config, err := r.TLSConfig().GetConfigForClient(nil)
+	p.NoError(err)
+	p.NotNil(config.ClientCAs)
+
+	info.TrustedCAFile = filepath.Join(p.dir, "missing.pem")
+	p.Error(r.reload(info))
+	current, err := r.TLSConfig().GetConfigForClient(nil)
+	p.NoError(err)
+	p.True(config == current)
+}
+
+func (p *tlsTestSuite) TestNoCertificate() {
+	r, err := newTLSReloader(conf.TLSInfo{})
+	p.NoError(err)
+
+	_, err = r.TLSConfig().GetCertificate(nil)
+	p.Error(err)
+}
+
+func (p *tlsTestSuite) TestCRL() {
+	ca, caKey := p.newCert(1, true, nil, nil)
+	revoked, revokedKey := p.newCert(10, false, ca, caKey)
+	valid, validKey := p.newCert(11, false, ca, caKey)
+
+	info := p.writeCert(2)
+	info.ClientCertAuth = true
+	info.TrustedCAFile = p.writePEM("ca.pem", "CERTIFICATE", ca.Raw)
+	info.CRLFile = p.writeCRL("ca.crl", ca, caKey, 10)
+
+	r, err := newTLSReloader(info)
+	p.NoError(err)
+	p.Contains(r.files(), info.CRLFile)
+	p.Error(p.handshake(r, revoked, revokedKey))
+	p.NoError(p.handshake(r, valid, validKey))
+
+	// serial of another issuer is not revoked
+	other, otherKey := p.newCert(3, true, nil, nil)
+	p.False(r.crl.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(10), RawIssuer: other.RawSubject}))
+
+	p.writeCRL("ca.crl", ca, caKey, 10, 11)
+	p.NoError(r.reload(info))
+	p.Error(p.handshake(r, valid, validKey))
+
+	// keep the current CRL if the new one is not signed by ca
+	p.writeCRL("ca.crl", other, otherKey)
+	p.Error(r.reload(info))
+	p.Error(p.handshake(r, valid, validKey))
+}
+
+func (p *tlsTestSuite) TestCRLDER() {
+	ca, caKey := p.newCert(1, true, nil, nil)
+
+	info := p.writeCert(2)
+	info.ClientCertAuth = true
+	info.TrustedCAFile = p.writePEM("ca.pem", "CERTIFICATE", ca.Raw)
+	info.CRLFile = filepath.Join(p.dir, "ca.crl")
+	p.NoError(ioutil.WriteFile(info.CRLFile, p.newCRL(ca, caKey, 10), 0600))
+
+	r, err := newTLSReloader(info)
+	p.NoError(err)
+	p.True(r.crl.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(10), RawIssuer: ca.RawSubject}))
+	p.False(r.crl.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(11), RawIssuer: ca.RawSubject}))
+}
+
+func (p *tlsTestSuite) TestNextProtos() {
+	info := p.writeCert(1)
+	r, err := newTLSReloader(info)
+	p.NoError(err)
+
+	// the http server add h2 to the base config for ALPN
+	base := r.TLSConfig()
+	base.NextProtos = []string{"h2", "http/1.1"}
+	config, err := base.GetConfigForClient(nil)
+	p.NoError(err)
+	p.Equal([]string{"h2", "http/1.1"}, config.NextProtos)
+	p.Nil(r.config.NextProtos)
+
+	c, s := net.Pipe()
+	defer c.Close()
+	client := tls.Client(c, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Handshake()
+	}()
+	server := tls.Server(s, base)
+	p.NoError(server.Handshake())
+	<-done
+	p.Equal("h2", server.ConnectionState().NegotiatedProtocol)
+	p.Equal("h2", client.ConnectionState().NegotiatedProtocol)
+	s.Close()
+}
+
+func (p *tlsTestSuite) TestCRLInvalid() {
+	ca, _ := p.newCert(1, true, nil, nil)
+
+	info := p.writeCert(2)
+	info.ClientCertAuth = true
+	info.TrustedCAFile = p.writePEM("ca.pem", "CERTIFICATE", ca.Raw)
+	info.CRLFile = p.writePEM("ca.crl", "X509 CRL", []byte("broken"))
+
+	_, err := newTLSReloader(info)
+	p.Error(err)
+}
+
+func TestTLSTestSuite(t *testing.T) {
+	p := &tlsTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/util"
+)
+
+const (
+	// the polling starts at draw time, and retries with backoff until the prize table is complete
+	drawPollBackoff    = time.Minute
+	drawPollMaxBackoff = 10 * time.Minute
+	// the draw is given up if not complete within the window after draw time
+	drawPollWindow = 12 * time.Hour
+)
+
+// drawWatcher poll the fetcher after each draw until the new term is complete, then store it.
+// DrawPending is published when the polling starts, DrawPublished when the numbers are available,
+// PrizeTableUpdated when the prize table is complete, and SyncFailed if it is not complete within the window
+// unless the draw is suspended. The published terms are kept in store, so they are not published again after restart.
+// If verifier is set, the term is reconciled with it before each publish, the flagged one is stored and held.
+type drawWatcher struct {
+	st       *store.Store
+	fetcher  tcb.Fetcher
+	verifier tcb.Fetcher
+	publish  func(e *event.Event) error
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+	window     time.Duration
+	now        func() time.Time
+}
+
+func newDrawWatcher(st *store.Store, fetcher tcb.Fetcher, publish func(e *event.Event) error) *drawWatcher {
+	return &drawWatcher{
+		st:         st,
+		fetcher:    fetcher,
+		publish:    publish,
+		backoff:    drawPollBackoff,
+		maxBackoff: drawPollMaxBackoff,
+		window:     drawPollWindow,
+		now:        time.Now,
+	}
+}
+
+func (w *drawWatcher) emit(typ event.Type, v interface{}) error {
+	e, err := event.NewEvent(typ, v)
+	if err != nil {
+		return err
+	}
+	return w.publish(e)
+}
+
+// pending return the latest draw time which is within the window and not published complete
+func (w *drawWatcher) pending(now time.Time) (time.Time, bool) {
+	draw := tcb.PrevDraw(now)
+	if now.Sub(draw) >= w.window {
+		return time.Time{}, false
+	}
+	if stored, ok := w.st.LatestAward(); ok && !stored.AwardOpenDate.Before(tcb.DrawDate(draw)) && stored.Complete() &&
+		w.st.PublishedTerm() >= stored.Term {
+		return time.Time{}, false
+	}
+	return draw, true
+}
+
+// run will wait for each draw and poll it, until ctx done
+func (w *drawWatcher) run(ctx context.Context) error {
+	for {
+		now := w.now()
+		draw, ok := w.pending(now)
+		if !ok {
+			next := tcb.NextDraw(now)
+			logger.Infof("Draw watcher waits for the draw at %s", next.Format(time.RFC3339))
+			if !sleep(ctx, next.Sub(now)) {
+				return nil
+			}
+			continue
+		}
+
+		if err := w.emit(event.TypeDrawPending, &event.DrawPending{Draw: draw}); err != nil {
+			logger.Errorf("Publish DrawPending of the draw at %s failed: %s", draw.Format(time.RFC3339), err)
+		}
+		// the draw is out of window after poll failed, so it waits for the next one
+		if err := w.poll(ctx, draw); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// the suspension may be configured after the polling starts
+			if tcb.IsSuspended(draw) {
+				logger.Infof("The draw at %s is suspended: %s", draw.Format(time.RFC3339), err)
+				continue
+			}
+			logger.Errorf("Watch the draw at %s failed: %s", draw.Format(time.RFC3339), err)
+			if err := w.emit(event.TypeSyncFailed, &event.SyncFailed{Draw: draw, Error: err.Error()}); err != nil {
+				logger.Errorf("Publish SyncFailed of the draw at %s failed: %s", draw.Format(time.RFC3339), err)
+			}
+		}
+	}
+}
+
+// poll will check the draw with backoff until it is stored complete or the window passed
+func (w *drawWatcher) poll(ctx context.Context, draw time.Time) error {
+	backoff := w.backoff
+	for {
+		done, err := w.check(ctx, draw)
+		if done {
+			return nil
+		}
+		if err != nil {
+			logger.Errorf("Check the draw at %s failed: %s", draw.Format(time.RFC3339), err)
+		}
+
+		left := draw.Add(w.window).Sub(w.now())
+		if left <= 0 {
+			return fmt.Errorf("draw is not complete within %s", w.window)
+		}
+		wait := backoff
+		if wait > left {
+			wait = left
+		}
+		if !sleep(ctx, wait) {
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// check will fetch the latest term, publish the events and store it if it is the complete award of draw.
+// The draw is done once PrizeTableUpdated is published, the award may be stored before by the queries.
+func (w *drawWatcher) check(ctx context.Context, draw time.Time) (bool, error) {
+	// always fetch the fresh pages
+	fctx := util.WithCacheTTL(ctx, 0)
+
+	terms, err := w.fetcher.FetchTermList(fctx)
+	if err != nil {
+		return false, err
+	}
+	if len(terms) == 0 {
+		return false, fmt.Errorf("term list is empty")
+	}
+	term := terms[len(terms)-1]
+	award, ok := w.st.Award(term)
+	if ok && award.Complete() && award.AwardOpenDate.Equal(tcb.DrawDate(draw)) {
+		if w.st.PublishedTerm() >= term {
+			return true, nil
+		}
+		// it is stored by the queries before the watcher, only publish it
+	} else {
+		award, err = w.fetcher.FetchFromTerm(fctx, term)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !award.AwardOpenDate.Equal(tcb.DrawDate(draw)) {
+		logger.Debugf("Latest term[%d] opened at %s, the draw is not published yet", term, award.AwardOpenDate.Format("2006-01-02"))
+		return false, nil
+	}
+	if err := tcb.Validate(award); err != nil {
+		return false, err
+	}
+	if w.st.DrawPublishedTerm() < term && w.st.PublishedTerm() < term {
+		if err := w.reconcile(fctx, term); err != nil {
+			return false, err
+		}
+		if err := w.emit(event.TypeDrawPublished, &event.Draw{Award: award}); err != nil {
+			return false, err
+		}
+		if err := w.st.SetDrawPublishedTerm(term); err != nil {
+			return false, err
+		}
+		logger.Infof("New draw term[%d] %s is published", award.Term, award.NumberString())
+	}
+	if !award.Complete() {
+		logger.Debugf("The prize table of term[%d] is not complete yet", term)
+		return false, nil
+	}
+
+	// the prize table is reconciled again, it is not published by the sources at the draw
+	if err := w.reconcile(fctx, term); err != nil {
+		return false, err
+	}
+	// publish before store, so the event is not lost if the store succeed but the publish failed
+	if err := w.emit(event.TypePrizeTableUpdated, &event.Draw{Award: award}); err != nil {
+		return false, err
+	}
+	if err := w.st.SaveAward(award); err != nil {
+		return false, err
+	}
+	if err := w.st.SetPublishedTerm(award.Term); err != nil {
+		return false, err
+	}
+	logger.Infof("New draw term[%d] is stored", award.Term)
+	return true, nil
+}
+
+// reconcile will compare term of the fetcher and verifier, the flagged one is stored and an error is returned,
+// so it is retried until the sources agree. The flag is removed once they agree.
+func (w *drawWatcher) reconcile(ctx context.Context, term uint32) error {
+	if w.verifier == nil {
+		return nil
+	}
+
+	r, err := tcb.Reconcile(ctx, term, w.fetcher, w.verifier)
+	if err != nil {
+		return err
+	}
+	for i := range r.Warnings {
+		for _, v := range r.Warnings[i] {
+			logger.Infof("Term[%d] of source[%s] is unusual: %s", term, r.Sources[i], v)
+		}
+	}
+	if r.Flagged() {
+		if err := w.st.FlagDraw(r); err != nil {
+			return err
+		}
+		return fmt.Errorf("term[%05d] is flagged by reconciliation: problems %v, diffs %v", term, r.Problems, r.Diffs)
+	}
+	if ok, err := w.st.UnflagDraw(term); err != nil {
+		return err
+	} else if ok {
+		logger.Infof("Term[%d] is unflagged by reconciliation", term)
+	}
+	return nil
+}
+
+// sleep will wait for d, return false if ctx done
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
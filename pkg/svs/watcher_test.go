@@ -0,0 +1,387 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeFetcher return the awards in order for each FetchFromTerm, the last one is kept
+type fakeFetcher struct {
+	mu     sync.Mutex
+	awards []*tcb.Award
+	errs   []error
+	calls  int
+}
+
+func (f *fakeFetcher) Name() string {
+	return "fake"
+}
+
+func (f *fakeFetcher) next() (*tcb.Award, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := f.calls
+	if i >= len(f.awards) {
+		i = len(f.awards) - 1
+	}
+	f.calls++
+	var err error
+	if i < len(f.errs) {
+		err = f.errs[i]
+	}
+	return f.awards[i], err
+}
+
+func (f *fakeFetcher) FetchTermList(ctx context.Context) ([]uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := f.calls
+	if i >= len(f.awards) {
+		i = len(f.awards) - 1
+	}
+	return []uint32{f.awards[i].Term}, nil
+}
+
+func (f *fakeFetcher) FetchFromTerm(ctx context.Context, term uint32) (*tcb.Award, error) {
+	return f.next()
+}
+
+// testAward return the award opened at date of 2018-07, complete with prize table if complete is true
+func testAward(term uint32, day int, complete bool) *tcb.Award {
+	open := time.Date(2018, 7, day, 0, 0, 0, 0, time.UTC)
+	a := &tcb.Award{
+		Term:          term,
+		AwardOpenDate: open,
+		DeadlineDate:  open.AddDate(0, 0, tcb.DeadlineDays),
+		Number:        []uint8{1, 2, 3, 4, 5, 6, 7},
+	}
+	if complete {
+		a.SalesVolume = 355223534
+		a.Pieces = []tcb.Piece{
+			{Level: tcb.FirstAward, Count: 5, Bonus: 6000000},
+			{Level: tcb.SecondAward, Count: 100, Bonus: 120000},
+			{Level: tcb.ThirdAward, Count: 1000, Bonus: 3000},
+			{Level: tcb.FourthAward, Count: 50000, Bonus: 200},
+			{Level: tcb.FifthAward, Count: 900000, Bonus: 10},
+			{Level: tcb.SixthAward, Count: 9000000, Bonus: 5},
+		}
+	}
+	return a
+}
+
+type watcherTestSuite struct {
+	suite.Suite
+
+	st      *store.Store
+	fetcher *fakeFetcher
+	w       *drawWatcher
+	now     time.Time
+	draw    time.Time
+
+	mu     sync.Mutex
+	events []*event.Event
+	ch     chan *event.Event
+}
+
+func (p *watcherTestSuite) publish(e *event.Event) error {
+	p.mu.Lock()
+	p.events = append(p.events, e)
+	p.mu.Unlock()
+
+	select {
+	case p.ch <- e:
+	default:
+	}
+	return nil
+}
+
+// published return the types and terms of published draw events
+func (p *watcherTestSuite) published() ([]event.Type, []uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	types := make([]event.Type, 0, len(p.events))
+	terms := make([]uint32, 0, len(p.events))
+	for _, e := range p.events {
+		types = append(types, e.Type)
+		var v event.Draw
+		if e.Decode(&v) == nil && v.Award != nil {
+			terms = append(terms, v.Award.Term)
+		}
+	}
+	return types, terms
+}
+
+func (p *watcherTestSuite) SetupTest() {
+	var err error
+	p.st, err = store.Open("")
+	p.NoError(err)
+
+	p.events = nil
+	p.ch = make(chan *event.Event, 16)
+	p.fetcher = &fakeFetcher{}
+	p.w = newDrawWatcher(p.st, p.fetcher, p.publish)
+	p.w.backoff = time.Millisecond
+	p.w.maxBackoff = 2 * time.Millisecond
+	// 2018-07-08 is Sunday
+	p.draw = time.Date(2018, 7, 8, tcb.DrawHour, tcb.DrawMinute, 0, 0, tcb.DrawLocation)
+	p.now = p.draw.Add(time.Minute)
+	p.w.now = func() time.Time {
+		return p.now
+	}
+}
+
+func (p *watcherTestSuite) TestPending() {
+	draw, ok := p.w.pending(p.now)
+	p.True(ok)
+	p.Equal(p.draw, draw)
+
+	_, ok = p.w.pending(p.draw.Add(drawPollWindow))
+	p.False(ok)
+	_, ok = p.w.pending(p.draw.Add(-time.Minute))
+	p.False(ok)
+
+	// the numbers are stored without prize table
+	p.NoError(p.st.SaveAward(testAward(18077, 8, false)))
+	_, ok = p.w.pending(p.now)
+	p.True(ok)
+
+	// the complete award is stored but not published
+	p.NoError(p.st.SaveAward(testAward(18077, 8, true)))
+	_, ok = p.w.pending(p.now)
+	p.True(ok)
+
+	p.NoError(p.st.SetPublishedTerm(18077))
+	_, ok = p.w.pending(p.now)
+	p.False(ok)
+}
+
+func (p *watcherTestSuite) TestCheckStoredBeforePublish() {
+	// the complete award is stored by the query before the watcher polls
+	p.NoError(p.st.SaveAward(testAward(18077, 8, true)))
+	p.fetcher.awards = []*tcb.Award{testAward(18077, 8, true)}
+
+	done, err := p.w.check(context.Background(), p.draw)
+	p.NoError(err)
+	p.True(done)
+	p.Equal(0, p.fetcher.calls)
+	types, terms := p.published()
+	p.Equal([]event.Type{event.TypeDrawPublished, event.TypePrizeTableUpdated}, types)
+	p.Equal([]uint32{18077, 18077}, terms)
+	p.Equal(uint32(18077), p.st.PublishedTerm())
+
+	done, err = p.w.check(context.Background(), p.draw)
+	p.NoError(err)
+	p.True(done)
+	types, _ = p.published()
+	p.Len(types, 2)
+}
+
+func (p *watcherTestSuite) TestPollUntilComplete() {
+	p.fetcher.awards = []*tcb.Award{
+		testAward(18076, 5, true),
+		testAward(18077, 8, false),
+		testAward(18077, 8, false),
+		testAward(18077, 8, true),
+	}
+	p.fetcher.errs = []error{nil, nil, errors.New("timeout")}
+
+	p.NoError(p.w.poll(context.Background(), p.draw))
+	p.Equal(4, p.fetcher.calls)
+	types, terms := p.published()
+	p.Equal([]event.Type{event.TypeDrawPublished, event.TypePrizeTableUpdated}, types)
+	p.Equal([]uint32{18077, 18077}, terms)
+
+	stored, ok := p.st.Award(18077)
+	p.True(ok)
+	p.True(stored.Complete())
+
+	// the stored complete award is not published again
+	p.NoError(p.w.poll(context.Background(), p.draw))
+	p.Equal(4, p.fetcher.calls)
+	types, _ = p.published()
+	p.Len(types, 2)
+}
+
+func (p *watcherTestSuite) TestCheckPublishedAfterRestart() {
+	p.fetcher.awards = []*tcb.Award{testAward(18077, 8, false)}
+
+	done, err := p.w.check(context.Background(), p.draw)
+	p.NoError(err)
+	p.False(done)
+	p.Equal(uint32(18077), p.st.DrawPublishedTerm())
+
+	// the new watcher after restart does not publish the numbers again
+	p.w = newDrawWatcher(p.st, p.fetcher, p.publish)
+	p.fetcher.awards = []*tcb.Award{testAward(18077, 8, true)}
+	done, err = p.w.check(context.Background(), p.draw)
+	p.NoError(err)
+	p.True(done)
+	types, terms := p.published()
+	p.Equal([]event.Type{event.TypeDrawPublished, event.TypePrizeTableUpdated}, types)
+	p.Equal([]uint32{18077, 18077}, terms)
+}
+
+func (p *watcherTestSuite) TestPollInvalid() {
+	v := testAward(18077, 8, true)
+	v.Number[6] = 17
+	p.fetcher.awards = []*tcb.Award{v}
+
+	// the clock is at 5ms before the window end
+	start := time.Now()
+	p.w.now = func() time.Time {
+		return p.draw.Add(drawPollWindow - 5*time.Millisecond).Add(time.Since(start))
+	}
+	p.Error(p.w.poll(context.Background(), p.draw))
+	types, _ := p.published()
+	p.Empty(types)
+	_, ok := p.st.Award(18077)
+	p.False(ok)
+}
+
+func (p *watcherTestSuite) TestCheckFlagged() {
+	p.fetcher.awards = []*tcb.Award{testAward(18077, 8, true)}
+	// the verifier has no deadline, and disagrees on blue
+	v := testAward(18077, 8, true)
+	v.DeadlineDate, v.Number[6] = time.Time{}, 8
+	verifier := &fakeFetcher{awards: []*tcb.Award{v}}
+	p.w.verifier = verifier
+
+	done, err := p.w.check(context.Background(), p.draw)
+	p.Error(err)
+	p.False(done)
+	types, _ := p.published()
+	p.Empty(types)
+	_, ok := p.st.Award(18077)
+	p.False(ok)
+	flagged, ok := p.st.FlaggedDraw(18077)
+	p.True(ok)
+	p.Equal([]tcb.Diff{{Field: "number", A: "01 02 03 04 05 06 + 07", B: "01 02 03 04 05 06 + 08"}}, flagged.Diffs)
+
+	// the verifier is corrected, and the lower level has less winners which is only warned
+	v = testAward(18077, 8, true)
+	v.DeadlineDate = time.Time{}
+	verifier.awards = []*tcb.Award{v}
+	p.fetcher.awards[0].Pieces[4].Count = 10
+	v.Pieces[4].Count = 10
+
+	done, err = p.w.check(context.Background(), p.draw)
+	p.NoError(err)
+	p.True(done)
+	types, terms := p.published()
+	p.Equal([]event.Type{event.TypeDrawPublished, event.TypePrizeTableUpdated}, types)
+	p.Equal([]uint32{18077, 18077}, terms)
+	_, ok = p.st.FlaggedDraw(18077)
+	p.False(ok)
+	p.Equal(uint32(18077), p.st.PublishedTerm())
+}
+
+func (p *watcherTestSuite) TestRunSyncFailed() {
+	p.fetcher.awards = []*tcb.Award{testAward(18076, 5, true)}
+
+	// the clock is at 5ms before the window end
+	start := time.Now()
+	p.w.now = func() time.Time {
+		return p.draw.Add(drawPollWindow - 5*time.Millisecond).Add(time.Since(start))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.w.run(ctx)
+	}()
+
+	select {
+	case e := <-p.ch:
+		p.Equal(event.TypeDrawPending, e.Type)
+		var v event.DrawPending
+		p.NoError(e.Decode(&v))
+		p.True(v.Draw.Equal(p.draw))
+	case <-time.After(5 * time.Second):
+		p.Fail("DrawPending is not published")
+	}
+	select {
+	case e := <-p.ch:
+		p.Equal(event.TypeSyncFailed, e.Type)
+		var v event.SyncFailed
+		p.NoError(e.Decode(&v))
+		p.True(v.Draw.Equal(p.draw))
+		p.NotEmpty(v.Error)
+	case <-time.After(5 * time.Second):
+		p.Fail("SyncFailed is not published")
+	}
+	cancel()
+	p.NoError(<-done)
+}
+
+func (p *watcherTestSuite) TestRunSuspended() {
+	p.fetcher.awards = []*tcb.Award{testAward(18076, 5, true)}
+
+	// the clock is at 200ms before the window end
+	start := time.Now()
+	p.w.now = func() time.Time {
+		return p.draw.Add(drawPollWindow - 200*time.Millisecond).Add(time.Since(start))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.w.run(ctx)
+	}()
+
+	select {
+	case e := <-p.ch:
+		p.Equal(event.TypeDrawPending, e.Type)
+	case <-time.After(5 * time.Second):
+		p.Fail("DrawPending is not published")
+	}
+	// the suspension is configured after the polling starts
+	tcb.SetSuspensions([]tcb.Suspension{{From: tcb.DrawDate(p.draw), To: tcb.DrawDate(p.draw)}})
+	defer tcb.SetSuspensions(nil)
+	select {
+	case e := <-p.ch:
+		p.Failf("unexpected event", "%s is published", e.Type)
+	case <-time.After(500 * time.Millisecond):
+	}
+	cancel()
+	p.NoError(<-done)
+}
+
+func (p *watcherTestSuite) TestRunCanceled() {
+	p.now = p.draw.Add(-time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.w.run(ctx)
+	}()
+
+	cancel()
+	p.NoError(<-done)
+}
+
+func TestWatcherTestSuite(t *testing.T) {
+	p := &watcherTestSuite{}
+	suite.Run(t, p)
+}
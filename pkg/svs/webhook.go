@@ -0,0 +1,177 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/ierror"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/webhook"
+)
+
+func newWebhookID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+type webhookRequest struct {
+	URL string `json:"url"`
+	// Secret is generated if empty
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type webhookResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newWebhookResponse(w *store.Webhook) *webhookResponse {
+	return &webhookResponse{
+		ID:        w.ID,
+		URL:       w.URL,
+		Events:    w.Events,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+// validateWebhook return error if the url is not absolute http(s) url or the events are unknown
+func validateWebhook(req *webhookRequest) error {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return fmt.Errorf("url is invalid: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url[%s] should be absolute http or https url", req.URL)
+	}
+
+	for _, v := range req.Events {
+		known := false
+		for _, t := range webhook.Types {
+			if event.Type(v) == t {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event %s", v)
+		}
+	}
+	return nil
+}
+
+// webhook return the webhook of :id
+func (s *server) webhook(c *gin.Context) (*store.Webhook, error) {
+	id := c.Param("id")
+	w, ok := s.st.Webhook(id)
+	if !ok {
+		return nil, ierror.NewError(ierror.EcodeNotFound, fmt.Sprintf("webhook[%s] not found", id))
+	}
+	return &w, nil
+}
+
+// ListWebhooks return the webhooks, the secrets are not returned
+func (s *server) ListWebhooks(c *gin.Context) (interface{}, error) {
+	webhooks := s.st.Webhooks()
+	v := make([]*webhookResponse, 0, len(webhooks))
+	for i := range webhooks {
+		v = append(v, newWebhookResponse(&webhooks[i]))
+	}
+	return v, nil
+}
+
+// CreateWebhook will save the webhook, the secret is only returned here
+func (s *server) CreateWebhook(c *gin.Context) (interface{}, error) {
+	req := &webhookRequest{}
+	if err := json.NewDecoder(c.Request.Body).Decode(req); err != nil {
+		return nil, ierror.Wrapf(ierror.EcodeRequestParam, err, "decode webhook request")
+	}
+	if err := validateWebhook(req); err != nil {
+		return nil, ierror.Wrap(ierror.EcodeRequestParam, err)
+	}
+	if req.Secret == "" {
+		req.Secret = newWebhookSecret()
+	}
+
+	w := &store.Webhook{
+		ID:        newWebhookID(),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		CreatedAt: time.Now(),
+	}
+	added, err := s.st.SaveWebhook(w)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ierror.NewError(ierror.EcodeRequestParam, fmt.Sprintf("webhook[%s] exists", w.ID))
+	}
+
+	c.Status(http.StatusCreated)
+	v := newWebhookResponse(w)
+	v.Secret = w.Secret
+	return v, nil
+}
+
+// DeleteWebhook will remove the webhook and its delivery log
+func (s *server) DeleteWebhook(c *gin.Context) (interface{}, error) {
+	id := c.Param("id")
+	removed, err := s.st.DeleteWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ierror.NewError(ierror.EcodeNotFound, fmt.Sprintf("webhook[%s] not found", id))
+	}
+	return map[string]string{"id": id}, nil
+}
+
+// ListWebhookDeliveries return the recent deliveries of the webhook, the latest first
+func (s *server) ListWebhookDeliveries(c *gin.Context) (interface{}, error) {
+	w, err := s.webhook(c)
+	if err != nil {
+		return nil, err
+	}
+	return s.st.WebhookDeliveries(w.ID), nil
+}
+
+// TestWebhook will post a Ping event to the webhook and return the delivery
+func (s *server) TestWebhook(c *gin.Context) (interface{}, error) {
+	w, err := s.webhook(c)
+	if err != nil {
+		return nil, err
+	}
+	return s.webhooks.Test(c.Request.Context(), w)
+}
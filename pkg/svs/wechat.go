@@ -0,0 +1,82 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"errors"
+
+	"github.com/lsytj0413/tyche/pkg/conf"
+	"github.com/lsytj0413/tyche/pkg/wechat"
+	"github.com/lsytj0413/tyche/pkg/wechat/client"
+)
+
+// wxState is the wechat config and clients, it is replaced as a whole on reload
+type wxState struct {
+	token   string
+	crypter *wechat.MsgCrypter
+	client  *client.Client
+
+	appID     string
+	appSecret string
+}
+
+// newWxState will construct wxState from config, the client of prev is reused if appid and appsecret unchanged
+func newWxState(c *conf.Config, prev *wxState) (*wxState, error) {
+	v := &wxState{
+		token:     c.WxToken,
+		appID:     c.WxAppID,
+		appSecret: c.WxAppSecret,
+	}
+
+	if c.WxEncodingAESKey != "" {
+		crypter, err := wechat.NewMsgCrypter(c.WxToken, c.WxEncodingAESKey, c.WxAppID)
+		if err != nil {
+			return nil, err
+		}
+		v.crypter = crypter
+	}
+
+	if c.WxAppID != "" && c.WxAppSecret != "" {
+		if prev != nil && prev.client != nil && prev.appID == c.WxAppID && prev.appSecret == c.WxAppSecret {
+			// keep the cached access_token
+			v.client = prev.client
+		} else {
+			v.client = client.New(c.WxAppID, c.WxAppSecret)
+		}
+	}
+
+	return v, nil
+}
+
+// wechat return the current wechat state
+func (s *server) wechat() *wxState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.wxs
+}
+
+// wxSender is notify.Sender which always use the current wechat client
+type wxSender struct {
+	s *server
+}
+
+func (w wxSender) SendTemplateMessage(msg *client.TemplateMessage) (int64, error) {
+	c := w.s.wechat().client
+	if c == nil {
+		return 0, errors.New("wechat client is not configured")
+	}
+	return c.SendTemplateMessage(msg)
+}
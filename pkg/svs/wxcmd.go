@@ -0,0 +1,294 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+)
+
+// wechat text commands
+const (
+	cmdSubscribe      = "订阅开奖"
+	cmdUnsubscribe    = "取消订阅"
+	cmdMyTickets      = "我的号码"
+	cmdAddFavourite   = "添加号码"
+	cmdDelFavourite   = "删除号码"
+	cmdAddBet         = "添加投注"
+	cmdDelBet         = "删除投注"
+	cmdSetPreferences = "设置"
+)
+
+const (
+	maxFavourites = 20
+	maxBets       = 50
+
+	prefNotifyTickets = "推送号码"
+)
+
+const (
+	replyHelp = "回复「" + cmdSubscribe + "」订阅开奖结果推送\n" +
+		"回复「" + cmdUnsubscribe + "」取消开奖结果推送\n" +
+		"回复「" + cmdMyTickets + "」查看号码中奖情况\n" +
+		"回复「" + cmdAddFavourite + " 01 02 03 04 05 06+07」收藏号码\n" +
+		"回复「" + cmdDelFavourite + " 序号」删除收藏号码\n" +
+		"回复「" + cmdAddBet + " 期号 01 02 03 04 05 06+07」记录投注\n" +
+		"回复「" + cmdDelBet + " 序号」删除投注记录\n" +
+		"回复「" + cmdSetPreferences + " " + prefNotifyTickets + " 开/关」设置开奖推送是否包含我的号码"
+	replyUnavailable = "服务暂时不可用, 请稍后再试"
+)
+
+// replyError is the error which should be replied to user as it is
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+type wxCommand func(s *server, ctx context.Context, openid string, args string) (string, error)
+
+var wxCommands = map[string]wxCommand{
+	cmdSubscribe:      (*server).cmdSubscribe,
+	cmdUnsubscribe:    (*server).cmdUnsubscribe,
+	cmdMyTickets:      (*server).cmdMyTickets,
+	cmdAddFavourite:   (*server).cmdAddFavourite,
+	cmdDelFavourite:   (*server).cmdDelFavourite,
+	cmdAddBet:         (*server).cmdAddBet,
+	cmdDelBet:         (*server).cmdDelBet,
+	cmdSetPreferences: (*server).cmdSetPreferences,
+}
+
+// splitCommand will split content to command name and args
+func splitCommand(content string) (string, string) {
+	content = strings.TrimSpace(content)
+	if i := strings.IndexAny(content, " \t\n"); i >= 0 {
+		return content[:i], strings.TrimSpace(content[i+1:])
+	}
+	return content, ""
+}
+
+// commandName return the command name of content for metrics, "unknown" if not a command
+func commandName(content string) string {
+	name, _ := splitCommand(content)
+	if _, ok := wxCommands[name]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// handleText will dispatch the text message to command, return the reply content
+func (s *server) handleText(ctx context.Context, openid string, content string) string {
+	name, args := splitCommand(content)
+	cmd, ok := wxCommands[name]
+	if !ok {
+		return replyHelp
+	}
+
+	reply, err := cmd(s, ctx, openid, args)
+	if err != nil {
+		if v, ok := err.(replyError); ok {
+			return string(v)
+		}
+		logger.Errorf("Handle wechat command[%s] of %s failed: %s", name, openid, err)
+		return replyUnavailable
+	}
+	return reply
+}
+
+func (s *server) cmdSubscribe(ctx context.Context, openid string, args string) (string, error) {
+	added, err := s.st.AddSubscriber(openid)
+	if err != nil {
+		return "", err
+	}
+	if !added {
+		return "您已订阅开奖结果推送", nil
+	}
+	return "订阅成功, 开奖后将第一时间推送开奖结果", nil
+}
+
+func (s *server) cmdUnsubscribe(ctx context.Context, openid string, args string) (string, error) {
+	removed, err := s.st.RemoveSubscriber(openid)
+	if err != nil {
+		return "", err
+	}
+	if !removed {
+		return "您尚未订阅开奖结果推送", nil
+	}
+	return "已取消开奖结果推送", nil
+}
+
+func (s *server) cmdMyTickets(ctx context.Context, openid string, args string) (string, error) {
+	p := s.st.Profile(openid)
+	if len(p.Favourites) == 0 && len(p.Bets) == 0 {
+		return "您还没有号码, 回复「" + cmdAddFavourite + " 01 02 03 04 05 06+07」收藏号码", nil
+	}
+
+	latest, err := s.latestAward(ctx)
+	if err != nil {
+		logger.Errorf("Get latest award failed: %s", err)
+	}
+
+	lines := make([]string, 0)
+	if latest != nil {
+		lines = append(lines, fmt.Sprintf("第 %05d 期开奖号码: %s", latest.Term, latest.NumberString()))
+	} else {
+		lines = append(lines, "开奖结果获取失败, 请稍后再试")
+	}
+
+	if len(p.Favourites) != 0 {
+		lines = append(lines, "", "收藏号码:")
+		for i := range p.Favourites {
+			result := p.Favourites[i].String()
+			if latest != nil {
+				result = checkTicket(&p.Favourites[i], latest)
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, result))
+		}
+	}
+
+	if len(p.Bets) != 0 {
+		lines = append(lines, "", "投注记录:")
+		for i, bet := range p.Bets {
+			result := bet.Ticket.String() + "  待开奖"
+			if award, ok := s.st.Award(bet.Term); ok {
+				result = checkTicket(&bet.Ticket, award)
+			}
+			lines = append(lines, fmt.Sprintf("%d. 第 %05d 期 %s", i+1, bet.Term, result))
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func parseTicket(s string) (*tcb.Ticket, error) {
+	t, err := tcb.ParseTicket(s)
+	if err != nil {
+		return nil, replyError("号码格式错误, 请输入 6 个不重复的红球(1-33)和 1 个蓝球(1-16), 如: 01 02 03 04 05 06+07")
+	}
+	return t, nil
+}
+
+func parseIndex(s string, length int) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 1 || i > length {
+		return 0, replyError("序号错误, 回复「" + cmdMyTickets + "」查看序号")
+	}
+	return i - 1, nil
+}
+
+func (s *server) cmdAddFavourite(ctx context.Context, openid string, args string) (string, error) {
+	t, err := parseTicket(args)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.st.UpdateProfile(openid, func(p *store.Profile) error {
+		if len(p.Favourites) >= maxFavourites {
+			return replyError(fmt.Sprintf("最多收藏 %d 注号码", maxFavourites))
+		}
+		p.Favourites = append(p.Favourites, *t)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "已收藏号码 " + t.String(), nil
+}
+
+func (s *server) cmdDelFavourite(ctx context.Context, openid string, args string) (string, error) {
+	var removed tcb.Ticket
+	err := s.st.UpdateProfile(openid, func(p *store.Profile) error {
+		i, err := parseIndex(args, len(p.Favourites))
+		if err != nil {
+			return err
+		}
+		removed = p.Favourites[i]
+		p.Favourites = append(p.Favourites[:i], p.Favourites[i+1:]...)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "已删除收藏号码 " + removed.String(), nil
+}
+
+func (s *server) cmdAddBet(ctx context.Context, openid string, args string) (string, error) {
+	v := strings.SplitN(args, " ", 2)
+	if len(v) != 2 {
+		return "", replyError("格式错误, 如: " + cmdAddBet + " 18078 01 02 03 04 05 06+07")
+	}
+	term, err := strconv.ParseUint(v[0], 10, 32)
+	if err != nil {
+		return "", replyError("期号格式错误, 如: 18078")
+	}
+	t, err := parseTicket(strings.TrimSpace(v[1]))
+	if err != nil {
+		return "", err
+	}
+
+	err = s.st.UpdateProfile(openid, func(p *store.Profile) error {
+		if len(p.Bets) >= maxBets {
+			return replyError(fmt.Sprintf("最多记录 %d 注投注", maxBets))
+		}
+		p.Bets = append(p.Bets, store.Bet{
+			Term:   uint32(term),
+			Ticket: *t,
+		})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("已记录第 %05d 期投注 %s", term, t), nil
+}
+
+func (s *server) cmdDelBet(ctx context.Context, openid string, args string) (string, error) {
+	var removed store.Bet
+	err := s.st.UpdateProfile(openid, func(p *store.Profile) error {
+		i, err := parseIndex(args, len(p.Bets))
+		if err != nil {
+			return err
+		}
+		removed = p.Bets[i]
+		p.Bets = append(p.Bets[:i], p.Bets[i+1:]...)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("已删除第 %05d 期投注 %s", removed.Term, removed.Ticket.String()), nil
+}
+
+func (s *server) cmdSetPreferences(ctx context.Context, openid string, args string) (string, error) {
+	v := strings.Fields(args)
+	if len(v) != 2 || v[0] != prefNotifyTickets || (v[1] != "开" && v[1] != "关") {
+		return "", replyError("格式错误, 如: " + cmdSetPreferences + " " + prefNotifyTickets + " 开")
+	}
+
+	err := s.st.UpdateProfile(openid, func(p *store.Profile) error {
+		p.Preferences.NotifyTickets = v[1] == "开"
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "设置成功", nil
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/lottery/tcb"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/stretchr/testify/suite"
+)
+
+type wxcmdTestSuite struct {
+	suite.Suite
+}
+
+func (p *wxcmdTestSuite) newServer() *server {
+	st, err := store.Open("")
+	p.Require().NoError(err)
+	return &server{st: st}
+}
+
+const (
+	errTicket = "号码格式错误, 请输入 6 个不重复的红球(1-33)和 1 个蓝球(1-16), 如: 01 02 03 04 05 06+07"
+	errIndex  = "序号错误, 回复「" + cmdMyTickets + "」查看序号"
+	errBet    = "格式错误, 如: " + cmdAddBet + " 18078 01 02 03 04 05 06+07"
+	errTerm   = "期号格式错误, 如: 18078"
+	errPref   = "格式错误, 如: " + cmdSetPreferences + " " + prefNotifyTickets + " 开"
+)
+
+func (p *wxcmdTestSuite) TestCommands() {
+	type testCase struct {
+		description string
+		setup       []string
+		content     string
+		expect      string
+		check       func(s *server)
+	}
+	testCases := []testCase{
+		{
+			description: "unknown command",
+			content:     "你好",
+			expect:      replyHelp,
+		},
+		{
+			description: "empty content",
+			content:     "  ",
+			expect:      replyHelp,
+		},
+		{
+			description: "subscribe",
+			content:     cmdSubscribe,
+			expect:      "订阅成功, 开奖后将第一时间推送开奖结果",
+			check: func(s *server) {
+				v := s.st.Subscribers()
+				p.Len(v, 1)
+				p.Equal("u1", v[0].OpenID)
+			},
+		},
+		{
+			description: "subscribe twice",
+			setup:       []string{cmdSubscribe},
+			content:     cmdSubscribe,
+			expect:      "您已订阅开奖结果推送",
+		},
+		{
+			description: "unsubscribe",
+			setup:       []string{cmdSubscribe},
+			content:     cmdUnsubscribe,
+			expect:      "已取消开奖结果推送",
+			check: func(s *server) {
+				p.Empty(s.st.Subscribers())
+			},
+		},
+		{
+			description: "unsubscribe without subscribe",
+			content:     cmdUnsubscribe,
+			expect:      "您尚未订阅开奖结果推送",
+		},
+		{
+			description: "my tickets without ticket",
+			content:     cmdMyTickets,
+			expect:      "您还没有号码, 回复「" + cmdAddFavourite + " 01 02 03 04 05 06+07」收藏号码",
+		},
+		{
+			description: "add favourite",
+			content:     cmdAddFavourite + " 01 02 03 04 05 06+07",
+			expect:      "已收藏号码 01 02 03 04 05 06 + 07",
+			check: func(s *server) {
+				p.Len(s.st.Profile("u1").Favourites, 1)
+			},
+		},
+		{
+			description: "add favourite without number",
+			content:     cmdAddFavourite,
+			expect:      errTicket,
+		},
+		{
+			description: "add favourite with duplicate red",
+			content:     cmdAddFavourite + " 01 01 03 04 05 06+07",
+			expect:      errTicket,
+		},
+		{
+			description: "add favourite with red out of range",
+			content:     cmdAddFavourite + " 01 02 03 04 05 34+07",
+			expect:      errTicket,
+		},
+		{
+			description: "add favourite with blue out of range",
+			content:     cmdAddFavourite + " 01 02 03 04 05 06+17",
+			expect:      errTicket,
+		},
+		{
+			description: "add favourite over limit",
+			setup:       repeat(cmdAddFavourite+" 01 02 03 04 05 06+07", maxFavourites),
+			content:     cmdAddFavourite + " 01 02 03 04 05 06+08",
+			expect:      fmt.Sprintf("最多收藏 %d 注号码", maxFavourites),
+			check: func(s *server) {
+				p.Len(s.st.Profile("u1").Favourites, maxFavourites)
+			},
+		},
+		{
+			description: "delete favourite",
+			setup:       []string{cmdAddFavourite + " 01 02 03 04 05 06+07", cmdAddFavourite + " 11 12 13 14 15 16+08"},
+			content:     cmdDelFavourite + " 1",
+			expect:      "已删除收藏号码 01 02 03 04 05 06 + 07",
+			check: func(s *server) {
+				v := s.st.Profile("u1").Favourites
+				p.Len(v, 1)
+				p.Equal(uint8(8), v[0].Blue)
+			},
+		},
+		{
+			description: "delete favourite with invalid index",
+			setup:       []string{cmdAddFavourite + " 01 02 03 04 05 06+07"},
+			content:     cmdDelFavourite + " a",
+			expect:      errIndex,
+		},
+		{
+			description: "delete favourite out of range",
+			setup:       []string{cmdAddFavourite + " 01 02 03 04 05 06+07"},
+			content:     cmdDelFavourite + " 2",
+			expect:      errIndex,
+		},
+		{
+			description: "add bet",
+			content:     cmdAddBet + " 18078 01 02 03 04 05 06+07",
+			expect:      "已记录第 18078 期投注 01 02 03 04 05 06 + 07",
+			check: func(s *server) {
+				v := s.st.Profile("u1").Bets
+				p.Len(v, 1)
+				p.Equal(uint32(18078), v[0].Term)
+			},
+		},
+		{
+			description: "add bet without ticket",
+			content:     cmdAddBet + " 18078",
+			expect:      errBet,
+		},
+		{
+			description: "add bet without args",
+			content:     cmdAddBet,
+			expect:      errBet,
+		},
+		{
+			description: "add bet with invalid term",
+			content:     cmdAddBet + " 18o78 01 02 03 04 05 06+07",
+			expect:      errTerm,
+		},
+		{
+			description: "add bet with negative term",
+			content:     cmdAddBet + " -1 01 02 03 04 05 06+07",
+			expect:      errTerm,
+		},
+		{
+			description: "add bet with malformed ticket",
+			content:     cmdAddBet + " 18078 01 02 03 04 05+07",
+			expect:      errTicket,
+			check: func(s *server) {
+				p.Empty(s.st.Profile("u1").Bets)
+			},
+		},
+		{
+			description: "add bet without blue",
+			content:     cmdAddBet + " 18078 01 02 03 04 05 06",
+			expect:      errTicket,
+		},
+		{
+			description: "add bet over limit",
+			setup:       repeat(cmdAddBet+" 18078 01 02 03 04 05 06+07", maxBets),
+			content:     cmdAddBet + " 18078 01 02 03 04 05 06+08",
+			expect:      fmt.Sprintf("最多记录 %d 注投注", maxBets),
+		},
+		{
+			description: "delete bet",
+			setup:       []string{cmdAddBet + " 18078 01 02 03 04 05 06+07"},
+			content:     cmdDelBet + " 1",
+			expect:      "已删除第 18078 期投注 01 02 03 04 05 06 + 07",
+			check: func(s *server) {
+				p.Empty(s.st.Profile("u1").Bets)
+			},
+		},
+		{
+			description: "delete bet without bet",
+			content:     cmdDelBet + " 1",
+			expect:      errIndex,
+		},
+		{
+			description: "set preferences on",
+			content:     cmdSetPreferences + " " + prefNotifyTickets + " 开",
+			expect:      "设置成功",
+			check: func(s *server) {
+				p.True(s.st.Profile("u1").Preferences.NotifyTickets)
+			},
+		},
+		{
+			description: "set preferences off",
+			setup:       []string{cmdSetPreferences + " " + prefNotifyTickets + " 开"},
+			content:     cmdSetPreferences + " " + prefNotifyTickets + " 关",
+			expect:      "设置成功",
+			check: func(s *server) {
+				p.False(s.st.Profile("u1").Preferences.NotifyTickets)
+			},
+		},
+		{
+			description: "set preferences with invalid value",
+			content:     cmdSetPreferences + " " + prefNotifyTickets + " 是",
+			expect:      errPref,
+		},
+		{
+			description: "set preferences with unknown name",
+			content:     cmdSetPreferences + " 其他 开",
+			expect:      errPref,
+		},
+	}
+
+	for _, tc := range testCases {
+		s := p.newServer()
+		for _, content := range tc.setup {
+			s.handleText(context.Background(), "u1", content)
+		}
+		p.Equal(tc.expect, s.handleText(context.Background(), "u1", tc.content), tc.description)
+		if tc.check != nil {
+			tc.check(s)
+		}
+	}
+}
+
+func (p *wxcmdTestSuite) TestMyTickets() {
+	s := p.newServer()
+	award := testAward(18077, 8, true)
+	p.NoError(s.st.SaveAward(award))
+	s.awardCheckAt = time.Now().Add(latestAwardTTL)
+
+	ctx := context.Background()
+	s.handleText(ctx, "u1", cmdAddFavourite+" 01 02 03 04 05 06+08")
+	s.handleText(ctx, "u1", cmdAddBet+" 18077 01 02 03 04 05 06+07")
+	s.handleText(ctx, "u1", cmdAddBet+" 18078 11 12 13 14 15 16+08")
+
+	expect := strings.Join([]string{
+		"第 18077 期开奖号码: " + award.NumberString(),
+		"",
+		"收藏号码:",
+		"1. " + checkTicket(&tcb.Ticket{Reds: []uint8{1, 2, 3, 4, 5, 6}, Blue: 8}, award),
+		"",
+		"投注记录:",
+		"1. 第 18077 期 " + checkTicket(&tcb.Ticket{Reds: []uint8{1, 2, 3, 4, 5, 6}, Blue: 7}, award),
+		"2. 第 18078 期 11 12 13 14 15 16 + 08  待开奖",
+	}, "\n")
+	p.Equal(expect, s.handleText(ctx, "u1", cmdMyTickets))
+}
+
+func (p *wxcmdTestSuite) TestSplitCommand() {
+	type testCase struct {
+		content string
+		name    string
+		args    string
+	}
+	testCases := []testCase{
+		{content: cmdSubscribe, name: cmdSubscribe},
+		{content: " " + cmdSubscribe + " \n", name: cmdSubscribe},
+		{content: cmdAddBet + " 18078  01 02 03 04 05 06+07 ", name: cmdAddBet, args: "18078  01 02 03 04 05 06+07"},
+		{content: cmdDelBet + "\t1", name: cmdDelBet, args: "1"},
+	}
+	for _, tc := range testCases {
+		name, args := splitCommand(tc.content)
+		p.Equal(tc.name, name, tc.content)
+		p.Equal(tc.args, args, tc.content)
+	}
+
+	p.Equal(cmdAddBet, commandName(cmdAddBet+" 18078"))
+	p.Equal("unknown", commandName("你好"))
+}
+
+func repeat(content string, n int) []string {
+	v := make([]string, n)
+	for i := range v {
+		v[i] = content
+	}
+	return v
+}
+
+func TestWxcmdTestSuite(t *testing.T) {
+	p := &wxcmdTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/util"
+	"github.com/lsytj0413/tyche/pkg/wechat/client"
+)
+
+const (
+	// wechat waits 5 seconds for passive reply, then retry the message at most 3 times
+	passiveReplyTimeout = 4500 * time.Millisecond
+	// the retries of a message are finished in 15 seconds
+	wxReplyTTL = 30 * time.Second
+)
+
+// wxReply is the reply of a wechat message, it is shared by the retries of the message
+type wxReply struct {
+	mu      sync.Mutex
+	done    chan struct{}
+	content string
+	// async is set when passive reply timeout, the content will be sent by customer service message
+	async   bool
+	expires time.Time
+}
+
+// finish will set the reply content, return true if it should be sent asynchronously
+func (r *wxReply) finish(content string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.content = content
+	close(r.done)
+	return r.async
+}
+
+// wait will wait the reply content until timeout, return false if the reply is switched to async
+func (r *wxReply) wait(timeout time.Duration) (string, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	r.mu.Lock()
+	async := r.async
+	r.mu.Unlock()
+	if async {
+		return "", false
+	}
+
+	select {
+	case <-r.done:
+		return r.content, true
+	case <-timer.C:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.done:
+		return r.content, true
+	default:
+	}
+	r.async = true
+	return "", false
+}
+
+// wxReplies deduplicate the wechat message retries, and track the pending replies for shutdown
+type wxReplies struct {
+	mu    sync.Mutex
+	items map[string]*wxReply
+
+	// ctx is the base context of handlers, it is the lifecycle context once started
+	ctx     context.Context
+	wg      sync.WaitGroup
+	pending int32
+}
+
+func newWxReplies() *wxReplies {
+	return &wxReplies{
+		items: make(map[string]*wxReply),
+		ctx:   context.Background(),
+	}
+}
+
+// goReply will run fn with the base context in background, it is waited by drain
+func (rs *wxReplies) goReply(fn func(ctx context.Context)) {
+	rs.wg.Add(1)
+	atomic.AddInt32(&rs.pending, 1)
+	go func() {
+		defer rs.wg.Done()
+		defer atomic.AddInt32(&rs.pending, -1)
+		fn(rs.ctx)
+	}()
+}
+
+// drain will wait for the pending replies until ctx done, it is called on shutdown after the http server
+func (rs *wxReplies) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		rs.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d pending wechat replies are dropped", atomic.LoadInt32(&rs.pending))
+	}
+}
+
+// acquire return the reply of message key, created is true if it is the first delivery of message
+func (rs *wxReplies) acquire(key string) (r *wxReply, created bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range rs.items {
+		if now.After(v.expires) {
+			delete(rs.items, k)
+		}
+	}
+
+	if r, ok := rs.items[key]; ok {
+		return r, false
+	}
+
+	r = &wxReply{
+		done:    make(chan struct{}),
+		expires: now.Add(wxReplyTTL),
+	}
+	rs.items[key] = r
+	return r, true
+}
+
+// wxMessageKey return the dedup key of message, event message without MsgId is keyed by user and create time
+func wxMessageKey(msg *TextMessage) string {
+	if msg.MsgID != 0 {
+		return strconv.FormatUint(msg.MsgID, 10)
+	}
+	return msg.FromUserName + "#" + strconv.FormatUint(msg.CreateTime, 10)
+}
+
+// replyText will handle the text message in background and finish r with the reply,
+// the panic of handler is recovered as replyUnavailable
+func (s *server) replyText(requestID string, r *wxReply, openid string, content string) {
+	s.replies.goReply(func(ctx context.Context) {
+		reply := replyUnavailable
+		defer func() {
+			if v := recover(); v != nil {
+				logger.Errorf("Request[%s] handle wechat message of %s panic: %v\n%s", requestID, openid, v, debug.Stack())
+			}
+			if r.finish(reply) {
+				s.sendCustomText(openid, reply)
+			}
+		}()
+
+		reply = s.handleText(util.WithRequestID(ctx, requestID), openid, content)
+	})
+}
+
+// sendCustomText will send the reply content by customer service message
+func (s *server) sendCustomText(openid string, content string) {
+	wx := s.wechat().client
+	if wx == nil {
+		logger.Errorf("Drop async reply to %s without wx-appid or wx-appsecret set", openid)
+		return
+	}
+
+	if err := wx.SendCustomMessage(client.NewTextMessage(openid, content)); err != nil {
+		logger.Errorf("Send async reply to %s failed: %s", openid, err)
+	}
+}
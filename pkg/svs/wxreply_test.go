@@ -0,0 +1,119 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type wxReplyTestSuite struct {
+	suite.Suite
+
+	rs *wxReplies
+}
+
+func (p *wxReplyTestSuite) SetupTest() {
+	p.rs = newWxReplies()
+}
+
+func (p *wxReplyTestSuite) TestAcquireDuplicate() {
+	r1, created := p.rs.acquire("1")
+	p.True(created)
+
+	r2, created := p.rs.acquire("1")
+	p.False(created)
+	p.True(r1 == r2)
+
+	_, created = p.rs.acquire("2")
+	p.True(created)
+}
+
+func (p *wxReplyTestSuite) TestAcquireExpired() {
+	r, _ := p.rs.acquire("1")
+	r.expires = time.Now().Add(-time.Second)
+
+	_, created := p.rs.acquire("1")
+	p.True(created)
+}
+
+func (p *wxReplyTestSuite) TestWaitFinished() {
+	r, _ := p.rs.acquire("1")
+	go func() {
+		p.False(r.finish("reply"))
+	}()
+
+	content, ok := r.wait(time.Second)
+	p.True(ok)
+	p.Equal("reply", content)
+
+	// retry after finished get the same reply
+	content, ok = r.wait(0)
+	p.True(ok)
+	p.Equal("reply", content)
+}
+
+func (p *wxReplyTestSuite) TestWaitTimeout() {
+	r, _ := p.rs.acquire("1")
+
+	_, ok := r.wait(10 * time.Millisecond)
+	p.False(ok)
+	p.True(r.finish("reply"))
+
+	// retry after switched to async doesnot reply again
+	_, ok = r.wait(time.Second)
+	p.False(ok)
+}
+
+func (p *wxReplyTestSuite) TestMessageKey() {
+	p.Equal("1234", wxMessageKey(&TextMessage{MsgID: 1234, FromUserName: "u1"}))
+	p.Equal("u1#1407743423", wxMessageKey(&TextMessage{FromUserName: "u1", CreateTime: 1407743423}))
+}
+
+func (p *wxReplyTestSuite) TestReplyPanic() {
+	// the command panic without store
+	s := &server{replies: p.rs}
+	r, _ := p.rs.acquire("1")
+	s.replyText("req", r, "u1", cmdSubscribe)
+
+	content, ok := r.wait(time.Second)
+	p.True(ok)
+	p.Equal(replyUnavailable, content)
+	p.NoError(p.rs.drain(context.Background()))
+}
+
+func (p *wxReplyTestSuite) TestDrain() {
+	release := make(chan struct{})
+	p.rs.goReply(func(ctx context.Context) {
+		<-release
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := p.rs.drain(ctx)
+	p.Error(err)
+	p.Contains(err.Error(), "1 pending")
+
+	close(release)
+	p.NoError(p.rs.drain(context.Background()))
+}
+
+func TestWxReplyTestSuite(t *testing.T) {
+	p := &wxReplyTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,341 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/structlog"
+)
+
+// CacheForever is the ttl of cache entry which never expire
+const CacheForever = time.Duration(-1)
+
+// ErrCacheMiss is returned when the url is not cached
+var ErrCacheMiss = errors.New("cache: miss")
+
+// the max body size to cache, the larger response is not cached
+const cacheMaxBodySize = 16 * 1024 * 1024
+
+// the response headers kept in cache
+var cacheHeaders = []string{"Content-Type", "ETag", "Last-Modified"}
+
+// CacheEntry is the metadata of a cached response, the raw body is stored as object named by its sha256
+type CacheEntry struct {
+	URL    string      `json:"url"`
+	Object string      `json:"object"`
+	Size   int64       `json:"size"`
+	Header http.Header `json:"header"`
+	// ETag and LastModified are the validators to revalidate the expired entry
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+	// ExpiresAt is zero if the entry never expire
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expired return whether the entry is expired at now
+func (e *CacheEntry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Cache is an on-disk content-addressed response cache:
+// dir/index/<sha256 of url>.json is the CacheEntry, dir/objects/<xx>/<sha256 of body> is the raw body.
+// The raw body is kept after expired until purged, so it can be parsed again.
+type Cache struct {
+	dir string
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewCache will construct a Cache at dir, the directories are created if not exists
+func NewCache(dir string) (*Cache, error) {
+	for _, v := range []string{"index", "objects"} {
+		if err := os.MkdirAll(filepath.Join(dir, v), 0755); err != nil {
+			return nil, fmt.Errorf("cache: create dir failed: %v", err)
+		}
+	}
+	return &Cache{
+		dir: dir,
+		now: time.Now,
+	}, nil
+}
+
+func hashHex(v []byte) string {
+	h := sha256.Sum256(v)
+	return hex.EncodeToString(h[:])
+}
+
+func (c *Cache) indexPath(url string) string {
+	return filepath.Join(c.dir, "index", hashHex([]byte(url))+".json")
+}
+
+func (c *Cache) objectPath(object string) string {
+	return filepath.Join(c.dir, "objects", object[:2], object)
+}
+
+// writeFile write to temp file then rename, so the file is never half written
+func writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readEntry must be called with c.mu held
+func (c *Cache) readEntry(path string) (*CacheEntry, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	e := &CacheEntry{}
+	if err := json.Unmarshal(content, e); err != nil {
+		return nil, fmt.Errorf("cache: decode %s failed: %v", path, err)
+	}
+	return e, nil
+}
+
+// writeEntry must be called with c.mu held
+func (c *Cache) writeEntry(e *CacheEntry) error {
+	content, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFile(c.indexPath(e.URL), content)
+}
+
+// Get return the entry and raw body of url even if it is expired, ErrCacheMiss if not cached
+func (c *Cache) Get(url string) (*CacheEntry, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.readEntry(c.indexPath(url))
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadFile(c.objectPath(e.Object))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrCacheMiss
+		}
+		return nil, nil, err
+	}
+	return e, body, nil
+}
+
+// Put will save the raw body of url with ttl, the body is shared by urls with the same content
+func (c *Cache) Put(url string, header http.Header, body []byte, ttl time.Duration) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	e := &CacheEntry{
+		URL:       url,
+		Object:    hashHex(body),
+		Size:      int64(len(body)),
+		Header:    make(http.Header),
+		FetchedAt: now,
+	}
+	if header != nil {
+		e.ETag, e.LastModified = header.Get("ETag"), header.Get("Last-Modified")
+	}
+	for _, k := range cacheHeaders {
+		if v := header.Get(k); v != "" {
+			e.Header.Set(k, v)
+		}
+	}
+	if ttl != CacheForever {
+		e.ExpiresAt = now.Add(ttl)
+	}
+
+	path := c.objectPath(e.Object)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := writeFile(path, body); err != nil {
+			return nil, fmt.Errorf("cache: write object failed: %v", err)
+		}
+	}
+	if err := c.writeEntry(e); err != nil {
+		return nil, fmt.Errorf("cache: write entry failed: %v", err)
+	}
+	return e, nil
+}
+
+// SetTTL will change the expiration of url to ttl from its fetched time, ErrCacheMiss if not cached
+func (c *Cache) SetTTL(url string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.readEntry(c.indexPath(url))
+	if err != nil {
+		return err
+	}
+	e.ExpiresAt = time.Time{}
+	if ttl != CacheForever {
+		e.ExpiresAt = e.FetchedAt.Add(ttl)
+	}
+	return c.writeEntry(e)
+}
+
+// entries must be called with c.mu held
+func (c *Cache) entries() ([]*CacheEntry, error) {
+	files, err := ioutil.ReadDir(filepath.Join(c.dir, "index"))
+	if err != nil {
+		return nil, err
+	}
+
+	v := make([]*CacheEntry, 0, len(files))
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		e, err := c.readEntry(filepath.Join(c.dir, "index", f.Name()))
+		if err != nil {
+			if err == ErrCacheMiss {
+				continue
+			}
+			return nil, err
+		}
+		v = append(v, e)
+	}
+	sort.Slice(v, func(i, j int) bool {
+		return v[i].URL < v[j].URL
+	})
+	return v, nil
+}
+
+// Entries return all cache entries order by url
+func (c *Cache) Entries() ([]*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries()
+}
+
+// Delete will remove the entry of url, return false if not cached
+func (c *Cache) Delete(url string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := os.Remove(c.indexPath(url))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, c.gc()
+}
+
+// Purge will remove the expired entries, or all if expiredOnly is false, return the removed count
+func (c *Cache) Purge(expiredOnly bool) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	now := c.now()
+	count := 0
+	for _, e := range entries {
+		if expiredOnly && !e.Expired(now) {
+			continue
+		}
+		if err := os.Remove(c.indexPath(e.URL)); err != nil && !os.IsNotExist(err) {
+			return count, err
+		}
+		count++
+	}
+	return count, c.gc()
+}
+
+// gc will remove the objects not referenced by any entry, must be called with c.mu held
+func (c *Cache) gc() error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	refs := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		refs[e.Object] = true
+	}
+
+	return filepath.Walk(filepath.Join(c.dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || refs[info.Name()] {
+			return err
+		}
+		return os.Remove(path)
+	})
+}
+
+// cacheBody return a body which save the raw response to cache when it is read to the end
+func (c *Client) cacheBody(request *http.Request, resp *http.Response, ttl time.Duration) *recordBody {
+	return &recordBody{ReadCloser: resp.Body, limit: cacheMaxBodySize, done: func(body []byte) {
+		if _, err := c.Cache.Put(request.URL.String(), resp.Header, body, ttl); err != nil {
+			structlog.Log("cache put failed",
+				structlog.F("requestId", RequestID(request.Context())),
+				structlog.F("url", request.URL.String()),
+				structlog.F("error", err))
+			return
+		}
+		upstreamCache.WithLabelValues("store").Inc()
+	}}
+}
+
+// cached return the cached entry and raw body of request, fresh is whether it can be returned
+// without request: it is not expired and ttl is not zero
+func (c *Client) cached(request *http.Request, ttl time.Duration) (e *CacheEntry, body []byte, fresh bool) {
+	e, body, err := c.Cache.Get(request.URL.String())
+	if err != nil || hashHex(body) != e.Object {
+		upstreamCache.WithLabelValues("miss").Inc()
+		return nil, nil, false
+	}
+	if ttl == 0 || e.Expired(c.Cache.now()) {
+		upstreamCache.WithLabelValues("stale").Inc()
+		return e, body, false
+	}
+	upstreamCache.WithLabelValues("hit").Inc()
+	return e, body, true
+}
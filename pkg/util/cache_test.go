@@ -0,0 +1,244 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type cacheTestSuite struct {
+	suite.Suite
+
+	dir   string
+	cache *Cache
+	now   time.Time
+}
+
+func (p *cacheTestSuite) SetupTest() {
+	var err error
+	p.dir, err = ioutil.TempDir("", "tyche-cache")
+	p.NoError(err)
+
+	p.cache, err = NewCache(p.dir)
+	p.NoError(err)
+	p.now = time.Date(2018, 7, 8, 21, 15, 0, 0, time.UTC)
+	p.cache.now = func() time.Time {
+		return p.now
+	}
+}
+
+func (p *cacheTestSuite) TearDownTest() {
+	os.RemoveAll(p.dir)
+}
+
+func (p *cacheTestSuite) objects() int {
+	count := 0
+	filepath.Walk(filepath.Join(p.dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	})
+	return count
+}
+
+func (p *cacheTestSuite) TestPutGet() {
+	_, _, err := p.cache.Get("http://a/1")
+	p.Equal(ErrCacheMiss, err)
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/html; charset=gb2312")
+	header.Set("Set-Cookie", "x=1")
+	_, err = p.cache.Put("http://a/1", header, []byte("raw"), time.Minute)
+	p.NoError(err)
+	_, err = p.cache.Put("http://a/2", nil, []byte("raw"), CacheForever)
+	p.NoError(err)
+	p.Equal(1, p.objects())
+
+	e, body, err := p.cache.Get("http://a/1")
+	p.NoError(err)
+	p.Equal([]byte("raw"), body)
+	p.Equal("text/html; charset=gb2312", e.Header.Get("Content-Type"))
+	p.Equal("", e.Header.Get("Set-Cookie"))
+	p.False(e.Expired(p.now))
+	p.True(e.Expired(p.now.Add(time.Minute)))
+
+	e, _, err = p.cache.Get("http://a/2")
+	p.NoError(err)
+	p.True(e.ExpiresAt.IsZero())
+	p.False(e.Expired(p.now.AddDate(10, 0, 0)))
+}
+
+func (p *cacheTestSuite) TestSetTTL() {
+	p.Equal(ErrCacheMiss, p.cache.SetTTL("http://a/1", CacheForever))
+
+	_, err := p.cache.Put("http://a/1", nil, []byte("raw"), time.Minute)
+	p.NoError(err)
+	p.NoError(p.cache.SetTTL("http://a/1", CacheForever))
+	e, _, err := p.cache.Get("http://a/1")
+	p.NoError(err)
+	p.True(e.ExpiresAt.IsZero())
+}
+
+func (p *cacheTestSuite) TestPurge() {
+	_, err := p.cache.Put("http://a/1", nil, []byte("1"), time.Minute)
+	p.NoError(err)
+	_, err = p.cache.Put("http://a/2", nil, []byte("2"), CacheForever)
+	p.NoError(err)
+	_, err = p.cache.Put("http://a/3", nil, []byte("2"), time.Hour)
+	p.NoError(err)
+
+	p.now = p.now.Add(2 * time.Minute)
+	count, err := p.cache.Purge(true)
+	p.NoError(err)
+	p.Equal(1, count)
+	p.Equal(1, p.objects())
+
+	entries, err := p.cache.Entries()
+	p.NoError(err)
+	p.Len(entries, 2)
+	p.Equal("http://a/2", entries[0].URL)
+
+	removed, err := p.cache.Delete("http://a/2")
+	p.NoError(err)
+	p.True(removed)
+	// the object is still referenced by http://a/3
+	p.Equal(1, p.objects())
+	removed, err = p.cache.Delete("http://a/2")
+	p.NoError(err)
+	p.False(removed)
+
+	count, err = p.cache.Purge(false)
+	p.NoError(err)
+	p.Equal(1, count)
+	p.Equal(0, p.objects())
+}
+
+func (p *cacheTestSuite) TestClient() {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("开奖"))
+	}))
+	defer srv.Close()
+
+	c := NewClient()
+	c.IgnoreRobots = true
+	c.Cache = p.cache
+	get := func(ctx context.Context) string {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		p.NoError(err)
+		content, err := c.DoRequest(req.WithContext(ctx))
+		p.NoError(err)
+		return content
+	}
+
+	ctx := WithCacheTTL(context.Background(), time.Minute)
+	p.Equal("开奖", get(ctx))
+	p.Equal("开奖", get(ctx))
+	p.Equal(int32(1), count)
+
+	// not cached without ttl
+	p.Equal("开奖", get(context.Background()))
+	p.Equal("开奖", get(WithCacheTTL(context.Background(), 0)))
+	p.Equal(int32(3), count)
+
+	p.now = p.now.Add(time.Minute)
+	p.Equal("开奖", get(ctx))
+	p.Equal(int32(4), count)
+
+	p.NoError(c.SetCacheTTL(srv.URL, CacheForever))
+	p.now = p.now.AddDate(1, 0, 0)
+	p.Equal("开奖", get(ctx))
+	p.Equal(int32(4), count)
+}
+
+func (p *cacheTestSuite) TestRevalidate() {
+	var count, notModified int32
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if r.Header.Get("If-None-Match") == etag {
+			p.Equal("Sun, 08 Jul 2018 13:15:00 GMT", r.Header.Get("If-Modified-Since"))
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Sun, 08 Jul 2018 13:15:00 GMT")
+		w.Write([]byte("content " + etag))
+	}))
+	defer srv.Close()
+
+	c := NewClient()
+	c.IgnoreRobots = true
+	c.Cache = p.cache
+	get := func(ctx context.Context) string {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		p.NoError(err)
+		content, err := c.DoRequest(req.WithContext(ctx))
+		p.NoError(err)
+		return content
+	}
+
+	ctx := WithCacheTTL(context.Background(), time.Minute)
+	p.Equal(`content "v1"`, get(ctx))
+	e, _, err := p.cache.Get(srv.URL)
+	p.NoError(err)
+	p.Equal(`"v1"`, e.ETag)
+	p.Equal("Sun, 08 Jul 2018 13:15:00 GMT", e.LastModified)
+
+	// the expired entry is revalidated and renewed
+	p.now = p.now.Add(time.Minute)
+	p.Equal(`content "v1"`, get(ctx))
+	p.Equal(int32(2), count)
+	p.Equal(int32(1), notModified)
+	e, _, err = p.cache.Get(srv.URL)
+	p.NoError(err)
+	p.Equal(p.now, e.FetchedAt)
+	p.Equal(`content "v1"`, get(ctx))
+	p.Equal(int32(2), count)
+
+	// zero ttl always revalidate
+	p.Equal(`content "v1"`, get(WithCacheTTL(context.Background(), 0)))
+	p.Equal(int32(3), count)
+	p.Equal(int32(2), notModified)
+
+	// the changed content replace the entry
+	etag = `"v2"`
+	p.now = p.now.Add(time.Minute)
+	p.Equal(`content "v2"`, get(ctx))
+	p.Equal(int32(4), count)
+	p.Equal(int32(2), notModified)
+	e, _, err = p.cache.Get(srv.URL)
+	p.NoError(err)
+	p.Equal(`"v2"`, e.ETag)
+}
+
+func TestCacheTestSuite(t *testing.T) {
+	p := &cacheTestSuite{}
+	suite.Run(t, p)
+}
@@ -15,28 +15,163 @@
 package util
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/axgle/mahonia"
 )
 
+// supported charsets
+const (
+	CharsetUTF8    = "utf-8"
+	CharsetGBK     = "gbk"
+	CharsetGB18030 = "gb18030"
+	CharsetBig5    = "big5"
+)
+
+const (
+	// the bytes of body to sniff the meta charset
+	sniffLength = 1024
+)
+
 var (
-	decoder mahonia.Decoder
+	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+	metaCharsetRegexp = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_\-]+)`)
+
+	charsetAliases = map[string]string{
+		"utf-8":       CharsetUTF8,
+		"utf8":        CharsetUTF8,
+		"gbk":         CharsetGBK,
+		"gb2312":      CharsetGBK,
+		"cp936":       CharsetGBK,
+		"x-gbk":       CharsetGBK,
+		"windows-936": CharsetGBK,
+		"gb18030":     CharsetGB18030,
+		"big5":        CharsetBig5,
+		"big-5":       CharsetBig5,
+		"cn-big5":     CharsetBig5,
+		"x-x-big5":    CharsetBig5,
+		"csbig5":      CharsetBig5,
+	}
+
+	// sniffCharsets is the generic labels which servers declare by default rather than
+	// the real charset, the charset is detected from content as it is not declared
+	sniffCharsets = map[string]bool{
+		"us-ascii":   true,
+		"ascii":      true,
+		"iso-8859-1": true,
+		"latin1":     true,
+	}
 )
 
-// ToUtf8 convert gb18030 inbuf to utf8 string
+// NormalizeCharset return the supported charset name of label, or error if it is not supported
+func NormalizeCharset(label string) (string, error) {
+	name, ok := charsetAliases[strings.ToLower(strings.TrimSpace(label))]
+	if !ok {
+		return "", fmt.Errorf("charset[%s] is not supported", label)
+	}
+	return name, nil
+}
+
+// declaredCharset return the charset declared by BOM, Content-Type or html meta tag in the leading
+// bytes head, ok is false if it is not declared or declared as a generic label in sniffCharsets
+func declaredCharset(head []byte, contentType string) (charset string, ok bool, err error) {
+	if bytes.HasPrefix(head, utf8BOM) {
+		return CharsetUTF8, true, nil
+	}
+
+	if contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err == nil && params["charset"] != "" && !sniffCharsets[strings.ToLower(params["charset"])] {
+			charset, err = NormalizeCharset(params["charset"])
+			return charset, err == nil, err
+		}
+		if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+			return CharsetUTF8, true, nil
+		}
+	}
+
+	if v := metaCharsetRegexp.FindSubmatch(head); v != nil && !sniffCharsets[strings.ToLower(string(v[1]))] {
+		charset, err = NormalizeCharset(string(v[1]))
+		return charset, err == nil, err
+	}
+	return "", false, nil
+}
+
+// DetectCharset detect the charset of body and the Content-Type header.
+// The priority is BOM, Content-Type, html meta tag, then utf-8 if body is json or valid utf8,
+// otherwise gb18030 which the legacy sources use. The body should be the whole content
+// unless the charset is declared in its leading bytes.
+func DetectCharset(body []byte, contentType string) (string, error) {
+	charset, ok, err := declaredCharset(body, contentType)
+	if err != nil || ok {
+		return charset, err
+	}
+	if utf8.Valid(body) {
+		return CharsetUTF8, nil
+	}
+	return CharsetGB18030, nil
+}
+
+// NewUtf8Reader return a reader which decode r to utf8 with the detected charset, the utf-8 BOM is skipped.
+// It only buffers the sniff bytes if the charset is declared, otherwise the whole body is read
+// to check utf8 validity.
+func NewUtf8Reader(r io.Reader, contentType string) (io.Reader, string, error) {
+	br := bufio.NewReaderSize(r, sniffLength)
+	head, err := br.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", err
+	}
+
+	charset, ok, err := declaredCharset(head, contentType)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var src io.Reader = br
+	if !ok {
+		body, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, "", err
+		}
+		if charset, err = DetectCharset(body, contentType); err != nil {
+			return nil, "", err
+		}
+		src = bytes.NewReader(body)
+	}
+
+	if charset == CharsetUTF8 {
+		if bytes.HasPrefix(head, utf8BOM) {
+			br.Discard(len(utf8BOM))
+		}
+		return src, charset, nil
+	}
+
+	decoder := mahonia.NewDecoder(charset)
+	if decoder == nil {
+		return nil, "", fmt.Errorf("no decoder of charset[%s]", charset)
+	}
+	return decoder.NewReader(src), charset, nil
+}
+
+// ToUtf8 convert r to utf8 string, the charset is detected from the content
 func ToUtf8(r io.Reader) (string, error) {
-	buf, err := ioutil.ReadAll(r)
+	ur, _, err := NewUtf8Reader(r, "")
 	if err != nil {
 		return "", err
 	}
-	return decoder.ConvertString(string(buf)), nil
-}
 
-func init() {
-	decoder = mahonia.NewDecoder("gb18030")
-	if decoder == nil {
-		panic("No gb18030 Decoder")
+	buf, err := ioutil.ReadAll(ur)
+	if err != nil {
+		return "", err
 	}
+	return string(buf), nil
 }
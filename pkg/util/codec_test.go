@@ -0,0 +1,225 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/axgle/mahonia"
+	"github.com/stretchr/testify/suite"
+)
+
+type codecTestSuite struct {
+	suite.Suite
+}
+
+func (p *codecTestSuite) encode(charset string, s string) []byte {
+	encoder := mahonia.NewEncoder(charset)
+	p.NotNil(encoder)
+	return []byte(encoder.ConvertString(s))
+}
+
+func (p *codecTestSuite) TestDetectCharset() {
+	gbk := p.encode(CharsetGBK, "双色球开奖")
+	testCases := []struct {
+		desc        string
+		head        []byte
+		contentType string
+		charset     string
+	}{
+		{
+			desc:    "bom",
+			head:    append([]byte{0xEF, 0xBB, 0xBF}, gbk...),
+			charset: CharsetUTF8,
+		},
+		{
+			desc:        "content type",
+			head:        []byte(`<meta charset="utf-8">`),
+			contentType: "text/html; charset=GB2312",
+			charset:     CharsetGBK,
+		},
+		{
+			desc:        "meta charset",
+			head:        []byte(`<html><head><meta charset="big5"></head>`),
+			contentType: "text/html",
+			charset:     CharsetBig5,
+		},
+		{
+			desc:    "meta http-equiv",
+			head:    []byte(`<META http-equiv="Content-Type" content="text/html; charset=gb18030" />`),
+			charset: CharsetGB18030,
+		},
+		{
+			desc:        "json",
+			head:        []byte(`{"term": 18077}`),
+			contentType: "application/json",
+			charset:     CharsetUTF8,
+		},
+		{
+			desc:    "valid utf8",
+			head:    []byte("双色球"),
+			charset: CharsetUTF8,
+		},
+		{
+			desc:    "truncated utf8",
+			head:    []byte("双色球")[:8],
+			charset: CharsetGB18030,
+		},
+		{
+			desc:    "invalid utf8",
+			head:    gbk,
+			charset: CharsetGB18030,
+		},
+		{
+			desc:    "ascii",
+			head:    []byte("<html></html>"),
+			charset: CharsetUTF8,
+		},
+		{
+			desc:        "us-ascii utf8",
+			head:        []byte("双色球"),
+			contentType: "text/html; charset=US-ASCII",
+			charset:     CharsetUTF8,
+		},
+		{
+			desc:        "iso-8859-1 gbk",
+			head:        gbk,
+			contentType: "text/html; charset=ISO-8859-1",
+			charset:     CharsetGB18030,
+		},
+		{
+			desc:        "iso-8859-1 meta charset",
+			head:        []byte(`<meta charset="gbk">`),
+			contentType: "text/html; charset=iso-8859-1",
+			charset:     CharsetGBK,
+		},
+		{
+			desc:    "meta latin1",
+			head:    append([]byte(`<meta charset="latin1">`), "双色球"...),
+			charset: CharsetUTF8,
+		},
+	}
+	for _, tc := range testCases {
+		charset, err := DetectCharset(tc.head, tc.contentType)
+		p.NoError(err, tc.desc)
+		p.Equal(tc.charset, charset, tc.desc)
+	}
+
+	_, err := DetectCharset(nil, "text/html; charset=koi8-r")
+	p.Error(err)
+	_, err = DetectCharset([]byte(`<meta charset="shift_jis">`), "")
+	p.Error(err)
+}
+
+func (p *codecTestSuite) TestToUtf8() {
+	testCases := []struct {
+		desc string
+		in   []byte
+	}{
+		{
+			desc: "utf8",
+			in:   []byte(`<meta charset="utf-8">开奖日期`),
+		},
+		{
+			desc: "utf8 bom",
+			in:   append([]byte{0xEF, 0xBB, 0xBF}, "开奖日期"...),
+		},
+		{
+			desc: "gbk",
+			in:   append([]byte(`<meta charset="gbk">`), p.encode(CharsetGBK, "开奖日期")...),
+		},
+		{
+			desc: "gb18030 default",
+			in:   p.encode(CharsetGB18030, "开奖日期"),
+		},
+		{
+			desc: "big5",
+			in:   append([]byte(`<meta charset="big5">`), p.encode(CharsetBig5, "開獎日期")...),
+		},
+		{
+			desc: "utf8 after ascii sniff window",
+			in:   append([]byte(strings.Repeat(" ", sniffLength)), "开奖日期"...),
+		},
+		{
+			desc: "gb18030 after ascii sniff window",
+			in:   append([]byte(strings.Repeat(" ", sniffLength)), p.encode(CharsetGB18030, "开奖日期")...),
+		},
+		{
+			desc: "gb18030 after utf8 sniff window",
+			in:   append([]byte(strings.Repeat("开", sniffLength)), p.encode(CharsetGB18030, "开奖日期")...),
+		},
+	}
+	for _, tc := range testCases {
+		content, err := ToUtf8(bytes.NewReader(tc.in))
+		p.NoError(err, tc.desc)
+		p.True(strings.HasSuffix(content, "日期"), tc.desc)
+		p.False(strings.HasPrefix(content, "\uFEFF"), tc.desc)
+	}
+}
+
+func (p *codecTestSuite) TestStreamLongBody() {
+	// the charset is declared in the sniff window and the body is larger than the buffers
+	body := strings.Repeat("开奖", 10000)
+	in := append([]byte(`<meta charset="gb18030">`), p.encode(CharsetGB18030, body)...)
+
+	content, err := ToUtf8(bytes.NewReader(in))
+	p.NoError(err)
+	p.Equal(`<meta charset="gb18030">`+body, content)
+}
+
+func (p *codecTestSuite) TestDoRequestCharset() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gbk":
+			w.Header().Set("Content-Type", "text/html; charset=gbk")
+			w.Write(p.encode(CharsetGBK, "开奖"))
+		case "/utf8":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("开奖"))
+		case "/latin1":
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			w.Write(p.encode(CharsetGBK, "开奖"))
+		case "/ascii":
+			w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+			w.Write([]byte("开奖"))
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=koi8-r")
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient()
+	for _, path := range []string{"/gbk", "/utf8", "/latin1", "/ascii"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		p.NoError(err)
+		content, err := c.DoRequest(req)
+		p.NoError(err, path)
+		p.Equal("开奖", content, path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/koi8", nil)
+	p.NoError(err)
+	_, err = c.DoRequest(req)
+	p.IsType(&DecodeError{}, err)
+}
+
+func TestCodecTestSuite(t *testing.T) {
+	p := &codecTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/structlog"
+)
+
+// newResponse return the cached body as 200 OK
+func newResponse(request *http.Request, header http.Header, body []byte) *http.Response {
+	h := make(http.Header, len(header))
+	for k, v := range header {
+		h[k] = v
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// isConditional return whether request has the conditional headers
+func isConditional(request *http.Request) bool {
+	return request.Header.Get("If-None-Match") != "" || request.Header.Get("If-Modified-Since") != ""
+}
+
+// setConditional will set the validators of cached entry e to request, return false if e has none
+func setConditional(request *http.Request, e *CacheEntry) bool {
+	if e.ETag != "" {
+		request.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		request.Header.Set("If-Modified-Since", e.LastModified)
+	}
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// revalidated return the cached response if resp is 304 Not Modified of the conditional request
+// with entry e, the entry is renewed with ttl unless ttl is zero
+func (c *Client) revalidated(request *http.Request, resp *http.Response, e *CacheEntry, body []byte, ttl time.Duration) (*http.Response, bool) {
+	if e == nil || resp.StatusCode != http.StatusNotModified {
+		return resp, false
+	}
+	resp.Body.Close()
+	upstreamCache.WithLabelValues("revalidated").Inc()
+
+	header := make(http.Header, len(e.Header))
+	for k, v := range e.Header {
+		header[k] = v
+	}
+	// the 304 response may carry the updated validators
+	for _, k := range cacheHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			header.Set(k, v)
+		}
+	}
+	if ttl != 0 {
+		if _, err := c.Cache.Put(request.URL.String(), header, body, ttl); err != nil {
+			structlog.Log("cache renew failed",
+				structlog.F("requestId", RequestID(request.Context())),
+				structlog.F("url", request.URL.String()),
+				structlog.F("error", err))
+		}
+	}
+	return newResponse(request, header, body), true
+}
+
+// recordBody buffer the body, and call done with it at EOF if the body is not larger than limit
+type recordBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int
+	done     func([]byte)
+	overflow bool
+}
+
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.buf.Len()+n > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
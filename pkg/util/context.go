@@ -0,0 +1,48 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"time"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID return a copy of ctx which carry the request id, it is logged with upstream requests
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID return the request id carried by ctx, empty if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type cacheTTLKey struct{}
+
+// WithCacheTTL return a copy of ctx which enable the response cache of GET requests,
+// the response is kept for ttl, or never expire if ttl is CacheForever. Zero ttl always send
+// the request, the cached response is only returned if it is revalidated as not modified.
+func WithCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheTTLKey{}, ttl)
+}
+
+// CacheTTL return the cache ttl carried by ctx, false if none
+func CacheTTL(ctx context.Context) (time.Duration, bool) {
+	ttl, ok := ctx.Value(cacheTTLKey{}).(time.Duration)
+	return ttl, ok
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+)
+
+// NetworkError is returned when no response received, e.g. connection refused or timeout
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("request %s failed: %v", e.URL, e.Err)
+}
+
+// Unwrap return the underlying error
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is returned when the response status code is not 2xx
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request %s failed: unexpected status %s", e.URL, e.Status)
+}
+
+// DecodeError is returned when the response body can not be read or decoded
+type DecodeError struct {
+	URL string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode response of %s failed: %v", e.URL, e.Err)
+}
+
+// Unwrap return the underlying error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RobotsError is returned when the request is disallowed by robots.txt of the host
+type RobotsError struct {
+	URL string
+	// Unreachable is true if the request is disallowed as robots.txt can not be fetched
+	Unreachable bool
+}
+
+func (e *RobotsError) Error() string {
+	if e.Unreachable {
+		return fmt.Sprintf("request %s is disallowed as robots.txt is unreachable", e.URL)
+	}
+	return fmt.Sprintf("request %s is disallowed by robots.txt", e.URL)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/ratelimit"
+)
+
+// host hold the throttle and robots.txt state of an upstream host
+type host struct {
+	limiter *ratelimit.Limiter
+	sem     chan struct{}
+
+	robotsMu      sync.Mutex
+	robots        *robotsRules
+	robotsExpires time.Time
+}
+
+// hostKey return the scheme and host of u, robots.txt is different for http and https
+func hostKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// host return the state of u's host, it is created with the current HostRate and HostConcurrency
+func (c *Client) host(u *url.URL) *host {
+	c.hostsMu.Lock()
+	defer c.hostsMu.Unlock()
+
+	key := hostKey(u)
+	h, ok := c.hosts[key]
+	if !ok {
+		h = &host{}
+		if c.HostRate > 0 {
+			h.limiter = ratelimit.New(c.HostRate, c.HostBurst)
+		}
+		if c.HostConcurrency > 0 {
+			h.sem = make(chan struct{}, c.HostConcurrency)
+		}
+		c.hosts[key] = h
+	}
+	return h
+}
+
+// acquire will wait for a concurrency slot and a rate token of host, release must be called after done.
+// It is safe to call release more than once.
+func (h *host) acquire(ctx context.Context) (release func(), err error) {
+	if h.sem != nil {
+		select {
+		case h.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if h.sem != nil {
+				<-h.sem
+			}
+		})
+	}
+
+	if h.limiter != nil {
+		if err := h.limiter.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
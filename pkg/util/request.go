@@ -15,19 +15,411 @@
 package util
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/structlog"
+	"github.com/lsytj0413/tyche/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tyche_upstream_requests_total",
+		Help: "Total upstream requests by host and status code, code is \"error\" if no response.",
+	}, []string{"host", "code"})
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "tyche_upstream_request_duration_seconds",
+		Help: "Upstream request latency by host.",
+	}, []string{"host"})
+	upstreamCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tyche_upstream_cache_total",
+		Help: "Total upstream response cache lookups and stores by result: hit, miss, stale, revalidated or store.",
+	}, []string{"result"})
 )
 
-// DoRequest will process request and return response body
+const (
+	defaultTimeout    = 15 * time.Second
+	defaultMaxRetries = 3
+	defaultBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+	// the longer Retry-After is not waited, e.g. the daily quota exhausted
+	defaultMaxRetryAfter = time.Minute
+
+	defaultHostRate        = 2
+	defaultHostBurst       = 4
+	defaultHostConcurrency = 2
+)
+
+var (
+	// DefaultUserAgent is sent if the request and client have no User-Agent
+	DefaultUserAgent = "tyche/" + version.Version + " (+https://github.com/lsytj0413/tyche)"
+)
+
+// Client send upstream requests with timeout and retries on transient errors
+type Client struct {
+	// Timeout of each attempt, including reading the body, 0 for no timeout
+	Timeout time.Duration
+	// MaxRetries is the max retries after the first attempt
+	MaxRetries int
+	// Backoff is the wait before the first retry, it is doubled for each retry up to MaxBackoff.
+	// The longer Retry-After of response is honoured as it is, the request is failed without
+	// retry if the wait exceeds MaxRetryAfter or the deadline of request context.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxRetryAfter is the longest Retry-After to wait, 0 for no limit
+	MaxRetryAfter time.Duration
+
+	// UserAgent is sent if the request has no User-Agent
+	UserAgent string
+	// HostRate and HostBurst limit the requests per second to each host, 0 for no limit
+	HostRate  float64
+	HostBurst int
+	// HostConcurrency is the max in-flight requests to each host, 0 for no limit.
+	// A request is in-flight until its response body closed.
+	HostConcurrency int
+	// IgnoreRobots disable the robots.txt check
+	IgnoreRobots bool
+	// Cache keep the GET responses of requests with WithCacheTTL context, nil to disable
+	Cache *Cache
+	// Headers is the default headers of requests to each host, e.g. Referer or Cookie of a source.
+	// It does not override the header set by request.
+	Headers map[string]http.Header
+	// Transport send the requests, nil for http.DefaultTransport
+	Transport http.RoundTripper
+
+	hostsMu sync.Mutex
+	hosts   map[string]*host
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewClient will construct a Client with the default timeout and retries
+func NewClient() *Client {
+	return &Client{
+		Timeout:       defaultTimeout,
+		MaxRetries:    defaultMaxRetries,
+		Backoff:       defaultBackoff,
+		MaxBackoff:    defaultMaxBackoff,
+		MaxRetryAfter: defaultMaxRetryAfter,
+
+		UserAgent:       DefaultUserAgent,
+		HostRate:        defaultHostRate,
+		HostBurst:       defaultHostBurst,
+		HostConcurrency: defaultHostConcurrency,
+
+		hosts: make(map[string]*host),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// DefaultClient is used by DoRequest
+var DefaultClient = NewClient()
+
+// SetCacheTTL will change the cache ttl of url in DefaultClient, see Client.SetCacheTTL
+func SetCacheTTL(url string, ttl time.Duration) error {
+	return DefaultClient.SetCacheTTL(url, ttl)
+}
+
+// SetCacheTTL will change the cache ttl of url, e.g. keep the page forever once its content is final.
+// It is no-op if the cache is disabled or url is not cached.
+func (c *Client) SetCacheTTL(url string, ttl time.Duration) error {
+	if c.Cache == nil {
+		return nil
+	}
+	if err := c.Cache.SetTTL(url, ttl); err != nil && err != ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+// DoRequest will process request with DefaultClient and return response body
 func DoRequest(request *http.Request) (content string, err error) {
-	resp, err := http.DefaultClient.Do(request)
+	return DefaultClient.DoRequest(request)
+}
+
+// DoRequest will process request and return response body decoded to utf8
+func (c *Client) DoRequest(request *http.Request) (string, error) {
+	body, err := c.DoRequestReader(request)
 	if err != nil {
-		return
+		return "", err
 	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
+	defer body.Close()
+
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", &DecodeError{URL: request.URL.String(), Err: err}
+	}
+	return string(buf), nil
+}
+
+// DoRequestReader will process request with DefaultClient and return the utf8 decoding body
+func DoRequestReader(request *http.Request) (io.ReadCloser, error) {
+	return DefaultClient.DoRequestReader(request)
+}
+
+// DoRequestReader will process request and return the response body stream decoded to utf8,
+// the charset is detected by NewUtf8Reader. The caller should close the returned body.
+func (c *Client) DoRequestReader(request *http.Request) (io.ReadCloser, error) {
+	resp, err := c.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	r, _, err := NewUtf8Reader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, &DecodeError{URL: request.URL.String(), Err: err}
 	}
+	return &utf8Body{Reader: r, Closer: resp.Body}, nil
+}
+
+// utf8Body read the decoded stream and close the response body
+type utf8Body struct {
+	io.Reader
+	io.Closer
+}
+
+// Do will send request, and retry with backoff on network error, 429 and 5xx status.
+// The requests to each host are throttled.
+// If request context has WithCacheTTL, the fresh response in Cache is returned without sending request,
+// and the expired one is revalidated by conditional request with its ETag and Last-Modified.
+// The error is *RobotsError, *NetworkError or *StatusError, the caller should close the body of returned response.
+func (c *Client) Do(request *http.Request) (*http.Response, error) {
+	ttl, cacheable := CacheTTL(request.Context())
+	cacheable = cacheable && c.Cache != nil && request.Method == http.MethodGet && !isConditional(request)
+
+	var (
+		stale     *CacheEntry
+		staleBody []byte
+	)
+	if cacheable {
+		e, body, fresh := c.cached(request, ttl)
+		if fresh {
+			return newResponse(request, e.Header, body), nil
+		}
+		stale, staleBody = e, body
+	}
+
+	if !c.IgnoreRobots {
+		if err := c.checkRobots(request); err != nil {
+			return nil, err
+		}
+	}
+
+	request = withHeader(request)
+	for k, values := range c.Headers[request.URL.Host] {
+		if _, ok := request.Header[k]; !ok {
+			request.Header[k] = values
+		}
+	}
+	request.Header.Set("User-Agent", c.userAgent(request))
+	if stale != nil && !setConditional(request, stale) {
+		stale = nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, retryAfter, err := c.do(request, attempt)
+		if err == nil {
+			if v, ok := c.revalidated(request, resp, stale, staleBody, ttl); ok {
+				return v, nil
+			}
+			if cacheable && resp.StatusCode == http.StatusOK {
+				resp.Body = c.cacheBody(request, resp, ttl)
+			}
+			return resp, nil
+		}
+		if attempt >= c.MaxRetries || !retryable(request, err) {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > wait {
+			if c.MaxRetryAfter > 0 && retryAfter > c.MaxRetryAfter {
+				return nil, err
+			}
+			wait = retryAfter
+		}
+		if deadline, ok := request.Context().Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-request.Context().Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// do send an attempt of request, retryAfter is parsed from the Retry-After header of failed response
+func (c *Client) do(request *http.Request, attempt int) (resp *http.Response, retryAfter time.Duration, err error) {
+	ctx, cancel := request.Context(), context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+	req := request.WithContext(ctx)
+	if attempt > 0 && request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			cancel()
+			return nil, 0, &NetworkError{URL: request.URL.String(), Err: err}
+		}
+		req.Body = body
+	}
+
+	release, err := c.host(request.URL).acquire(ctx)
+	if err != nil {
+		cancel()
+		return nil, 0, &NetworkError{URL: request.URL.String(), Err: err}
+	}
+
+	start := time.Now()
+	hc := &http.Client{Transport: c.Transport}
+	resp, err = hc.Do(req)
+	latency := time.Since(start)
+	upstreamDuration.WithLabelValues(request.URL.Host).Observe(latency.Seconds())
+
+	fields := []structlog.Field{
+		structlog.F("requestId", RequestID(request.Context())),
+		structlog.F("method", request.Method),
+		structlog.F("url", request.URL.String()),
+		structlog.F("attempt", attempt+1),
+		structlog.F("latency", latency),
+	}
+	if err != nil {
+		release()
+		cancel()
+		upstreamRequests.WithLabelValues(request.URL.Host, "error").Inc()
+		structlog.Log("upstream", append(fields, structlog.F("error", err))...)
+		return nil, 0, &NetworkError{URL: request.URL.String(), Err: err}
+	}
+	upstreamRequests.WithLabelValues(request.URL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+	structlog.Log("upstream", append(fields, structlog.F("status", resp.StatusCode))...)
+
+	if resp.StatusCode == http.StatusNotModified && isConditional(request) {
+		resp.Body.Close()
+		release()
+		cancel()
+		resp.Body = http.NoBody
+		return resp, 0, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		release()
+		cancel()
+
+		retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return nil, retryAfter, &StatusError{
+			URL:        request.URL.String(),
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+
+	// the timeout and host concurrency cover reading body, so release after body closed
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: func() {
+		release()
+		cancel()
+	}}
+	return resp, 0, nil
+}
+
+// withHeader return a shallow copy of request with its own header, so the caller's request is not modified
+func withHeader(request *http.Request) *http.Request {
+	v := request.WithContext(request.Context())
+	v.Header = make(http.Header, len(request.Header)+3)
+	for k, values := range request.Header {
+		v.Header[k] = values
+	}
+	return v
+}
+
+// userAgent return the User-Agent of request, or the client's default
+func (c *Client) userAgent(request *http.Request) string {
+	if v := request.Header.Get("User-Agent"); v != "" {
+		return v
+	}
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+// retryable return whether err is transient and request can be sent again
+func retryable(request *http.Request, err error) bool {
+	if request.Context().Err() != nil {
+		return false
+	}
+	if request.Body != nil && request.GetBody == nil {
+		return false
+	}
+
+	switch v := err.(type) {
+	case *NetworkError:
+		return true
+	case *StatusError:
+		return retryableStatus(v.StatusCode)
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// backoff return the wait before retry after attempt, with jitter in [d/2, d)
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.Backoff
+	for i := 0; i < attempt && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return d/2 + time.Duration(c.rand.Int63n(int64(d/2)+1))
+}
+
+// parseRetryAfter parse the Retry-After header in seconds or http date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	content, err = ToUtf8(resp.Body)
-	return
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
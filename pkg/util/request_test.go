@@ -0,0 +1,382 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type requestTestSuite struct {
+	suite.Suite
+
+	c *Client
+}
+
+func (p *requestTestSuite) SetupTest() {
+	p.c = NewClient()
+	p.c.Backoff = time.Millisecond
+	p.c.MaxBackoff = 5 * time.Millisecond
+	p.c.HostRate = 0
+	p.c.IgnoreRobots = true
+}
+
+// serve return a server which reply statuses in order, then 200 with body
+func (p *requestTestSuite) serve(body string, statuses ...int) (*httptest.Server, *int32) {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		if int(n) <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	return srv, &count
+}
+
+func (p *requestTestSuite) get(url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	p.NoError(err)
+	return p.c.DoRequest(req)
+}
+
+func (p *requestTestSuite) TestOk() {
+	srv, count := p.serve("ok")
+	defer srv.Close()
+
+	content, err := p.get(srv.URL)
+	p.NoError(err)
+	p.Equal("ok", content)
+	p.Equal(int32(1), *count)
+}
+
+func (p *requestTestSuite) TestRetryTransient() {
+	srv, count := p.serve("ok", http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer srv.Close()
+
+	content, err := p.get(srv.URL)
+	p.NoError(err)
+	p.Equal("ok", content)
+	p.Equal(int32(3), *count)
+}
+
+func (p *requestTestSuite) TestRetryExhausted() {
+	srv, count := p.serve("ok", 502, 502, 502, 502, 502)
+	defer srv.Close()
+
+	_, err := p.get(srv.URL)
+	v, ok := err.(*StatusError)
+	p.True(ok)
+	p.Equal(http.StatusBadGateway, v.StatusCode)
+	p.Equal(int32(p.c.MaxRetries+1), *count)
+}
+
+func (p *requestTestSuite) TestNotRetryClientError() {
+	srv, count := p.serve("ok", http.StatusNotFound)
+	defer srv.Close()
+
+	_, err := p.get(srv.URL)
+	v, ok := err.(*StatusError)
+	p.True(ok)
+	p.Equal(http.StatusNotFound, v.StatusCode)
+	p.Equal(int32(1), *count)
+}
+
+func (p *requestTestSuite) TestTimeout() {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	p.c.Timeout = 10 * time.Millisecond
+	p.c.MaxRetries = 1
+	_, err := p.get(srv.URL)
+	_, ok := err.(*NetworkError)
+	p.True(ok)
+}
+
+func (p *requestTestSuite) TestContextCanceled() {
+	srv, count := p.serve("ok", 503, 503, 503)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.c.Backoff = time.Hour
+	p.c.MaxBackoff = time.Hour
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	p.NoError(err)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = p.c.DoRequest(req.WithContext(ctx))
+	_, ok := err.(*StatusError)
+	p.True(ok)
+	p.Equal(int32(1), *count)
+}
+
+func (p *requestTestSuite) TestBackoff() {
+	p.c.Backoff = 100 * time.Millisecond
+	p.c.MaxBackoff = 300 * time.Millisecond
+	for attempt, max := range []time.Duration{100, 200, 300, 300} {
+		d := p.c.backoff(attempt)
+		p.True(d >= max*time.Millisecond/2, d)
+		p.True(d <= max*time.Millisecond, d)
+	}
+}
+
+func (p *requestTestSuite) TestRetryAfter() {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// Retry-After is longer than MaxBackoff
+	start := time.Now()
+	content, err := p.get(srv.URL)
+	p.NoError(err)
+	p.Equal("ok", content)
+	p.True(time.Since(start) >= time.Second, time.Since(start))
+	p.Equal(int32(2), atomic.LoadInt32(&count))
+}
+
+func (p *requestTestSuite) TestRetryAfterExceedDeadline() {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	p.NoError(err)
+
+	// give up at once, instead of waiting until the deadline
+	start := time.Now()
+	_, err = p.c.DoRequest(req.WithContext(ctx))
+	v, ok := err.(*StatusError)
+	p.True(ok)
+	p.Equal(http.StatusTooManyRequests, v.StatusCode)
+	p.True(time.Since(start) < time.Second, time.Since(start))
+	p.Equal(int32(1), atomic.LoadInt32(&count))
+}
+
+func (p *requestTestSuite) TestRetryAfterExceedMax() {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// give up at once without deadline, instead of waiting for a day
+	start := time.Now()
+	_, err := p.get(srv.URL)
+	v, ok := err.(*StatusError)
+	p.True(ok)
+	p.Equal(http.StatusServiceUnavailable, v.StatusCode)
+	p.True(time.Since(start) < time.Second, time.Since(start))
+	p.Equal(int32(1), atomic.LoadInt32(&count))
+}
+
+func (p *requestTestSuite) TestParseRetryAfter() {
+	d, ok := parseRetryAfter("3")
+	p.True(ok)
+	p.Equal(3*time.Second, d)
+
+	_, ok = parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	p.True(ok)
+
+	_, ok = parseRetryAfter("soon")
+	p.False(ok)
+}
+
+func (p *requestTestSuite) TestUserAgent() {
+	var agents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+	}))
+	defer srv.Close()
+
+	p.c.UserAgent = "tyche-test/1.0"
+	_, err := p.get(srv.URL)
+	p.NoError(err)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	p.NoError(err)
+	req.Header.Set("User-Agent", "custom")
+	_, err = p.c.DoRequest(req)
+	p.NoError(err)
+	p.Equal("custom", req.Header.Get("User-Agent"))
+	p.Equal([]string{"tyche-test/1.0", "custom"}, agents)
+}
+
+func (p *requestTestSuite) TestHostConcurrency() {
+	var inflight, max int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	p.c.HostConcurrency = 2
+	done := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			p.get(srv.URL)
+		}()
+	}
+	for i := 0; i < 6; i++ {
+		<-done
+	}
+	p.True(max <= 2, max)
+}
+
+func (p *requestTestSuite) TestHostRate() {
+	srv, count := p.serve("ok")
+	defer srv.Close()
+
+	p.c.HostRate, p.c.HostBurst = 20, 1
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := p.get(srv.URL)
+		p.NoError(err)
+	}
+	p.True(time.Since(start) >= 90*time.Millisecond)
+	p.Equal(int32(3), *count)
+}
+
+func (p *requestTestSuite) TestRobots() {
+	var robots int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&robots, 1)
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p.c.IgnoreRobots = false
+	content, err := p.get(srv.URL + "/public")
+	p.NoError(err)
+	p.Equal("ok", content)
+
+	_, err = p.get(srv.URL + "/private/1")
+	p.IsType(&RobotsError{}, err)
+	p.Equal(int32(1), robots)
+}
+
+func (p *requestTestSuite) TestRobotsNotFound() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p.c.IgnoreRobots = false
+	content, err := p.get(srv.URL + "/private")
+	p.NoError(err)
+	p.Equal("ok", content)
+}
+
+func (p *requestTestSuite) TestRobotsForbidden() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p.c.IgnoreRobots = false
+	content, err := p.get(srv.URL + "/private")
+	p.NoError(err)
+	p.Equal("ok", content)
+}
+
+func (p *requestTestSuite) TestRobotsUnreachable() {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&count, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p.c.IgnoreRobots = false
+	_, err := p.get(srv.URL + "/public")
+	p.IsType(&RobotsError{}, err)
+	p.True(err.(*RobotsError).Unreachable)
+	p.Equal(int32(0), atomic.LoadInt32(&count))
+}
+
+func (p *requestTestSuite) TestRobotsNetworkError() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			p.NoError(err)
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p.c.IgnoreRobots = false
+	_, err := p.get(srv.URL + "/public")
+	p.IsType(&RobotsError{}, err)
+	p.True(err.(*RobotsError).Unreachable)
+}
+
+func TestRequestTestSuite(t *testing.T) {
+	p := &requestTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/structlog"
+)
+
+const (
+	// robots.txt is fetched again after robotsTTL, or robotsRetryTTL if it is unavailable
+	robotsTTL      = 24 * time.Hour
+	robotsRetryTTL = 5 * time.Minute
+
+	// the max robots.txt size to parse
+	robotsMaxSize = 512 * 1024
+)
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// newRobotsRule compile pattern which may contain * wildcard and $ end anchor
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	if strings.HasSuffix(expr, `\$`) {
+		expr = strings.TrimSuffix(expr, `\$`) + "$"
+	}
+	return robotsRule{
+		allow:   allow,
+		pattern: pattern,
+		re:      regexp.MustCompile("^" + expr),
+	}
+}
+
+// robotsRules is the rules of robots.txt apply to our user agent
+type robotsRules struct {
+	rules []robotsRule
+	// unreachable disallow all, robots.txt is failed with 5xx or network error
+	unreachable bool
+}
+
+// allowed return whether path is allowed, the longest matched rule wins and allow wins on tie
+func (r *robotsRules) allowed(path string) bool {
+	if r.unreachable {
+		return false
+	}
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+			allowed, longest = rule.allow, n
+		}
+	}
+	return allowed
+}
+
+// userAgentToken return the product token of user agent, e.g. tyche of "tyche/1.0"
+func userAgentToken(userAgent string) string {
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return token
+}
+
+// parseRobots parse robots.txt, use the groups match token if any, otherwise the * groups.
+// The user-agent line matches if its product token equals token case-insensitively.
+func parseRobots(r io.Reader, token string) *robotsRules {
+	var (
+		specific, wildcard []robotsRule
+		matched            bool
+		agents             []string
+		inRules            bool
+	)
+
+	scanner := bufio.NewScanner(io.LimitReader(r, robotsMaxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agent := value
+			if agent != "*" {
+				agent = userAgentToken(value)
+			}
+			agents = append(agents, agent)
+			if agent != "*" && token != "" && agent == token {
+				matched = true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			rule := newRobotsRule(key == "allow", value)
+			for _, agent := range agents {
+				if agent == "*" {
+					wildcard = append(wildcard, rule)
+				} else if token != "" && agent == token {
+					specific = append(specific, rule)
+				}
+			}
+		}
+	}
+
+	if matched {
+		return &robotsRules{rules: specific}
+	}
+	return &robotsRules{rules: wildcard}
+}
+
+// checkRobots return *RobotsError if the robots.txt of host disallow the request
+func (c *Client) checkRobots(request *http.Request) error {
+	if request.URL.Path == "/robots.txt" {
+		return nil
+	}
+
+	h := c.host(request.URL)
+	h.robotsMu.Lock()
+	defer h.robotsMu.Unlock()
+
+	if h.robots == nil || time.Now().After(h.robotsExpires) {
+		h.robots, h.robotsExpires = c.fetchRobots(request)
+	}
+	if !h.robots.allowed(request.URL.RequestURI()) {
+		return &RobotsError{URL: request.URL.String(), Unreachable: h.robots.unreachable}
+	}
+	return nil
+}
+
+// fetchRobots fetch the robots.txt of request host, it allow all if robots.txt is failed with 4xx,
+// and disallow all if it is unreachable for 5xx or network error
+func (c *Client) fetchRobots(request *http.Request) (*robotsRules, time.Time) {
+	u := hostKey(request.URL) + "/robots.txt"
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return &robotsRules{unreachable: true}, time.Now().Add(robotsRetryTTL)
+	}
+	req = req.WithContext(request.Context())
+	req.Header.Set("User-Agent", c.userAgent(request))
+
+	resp, _, err := c.do(req, 0)
+	if err != nil {
+		if v, ok := err.(*StatusError); ok && v.StatusCode >= 400 && v.StatusCode < 500 {
+			return &robotsRules{}, time.Now().Add(robotsTTL)
+		}
+		structlog.Log("robots unreachable, disallow all",
+			structlog.F("requestId", RequestID(request.Context())),
+			structlog.F("url", u),
+			structlog.F("error", err))
+		expires := time.Now().Add(robotsRetryTTL)
+		if request.Context().Err() != nil {
+			// the caller is cancelled, fetch again by the next request
+			expires = time.Now()
+		}
+		return &robotsRules{unreachable: true}, expires
+	}
+	defer resp.Body.Close()
+
+	return parseRobots(resp.Body, userAgentToken(c.userAgent(request))), time.Now().Add(robotsTTL)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type robotsTestSuite struct {
+	suite.Suite
+}
+
+const testRobots = `
+# comment
+User-agent: *
+Disallow: /shtml/
+Allow: /shtml/ssq/
+Disallow: /*.json$
+
+User-agent: badbot
+User-agent: tyche
+Disallow: /private # trailing comment
+Allow: /private/public
+Disallow:
+`
+
+func (p *robotsTestSuite) TestWildcardGroup() {
+	r := parseRobots(strings.NewReader(testRobots), "otherbot")
+	testCases := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/", true},
+		{"/shtml/dlt/", false},
+		{"/shtml/ssq/18077.shtml", true},
+		{"/data/list.json", false},
+		{"/data/list.json?x=1", true},
+		{"/private", true},
+	}
+	for _, tc := range testCases {
+		p.Equal(tc.allowed, r.allowed(tc.path), tc.path)
+	}
+}
+
+func (p *robotsTestSuite) TestSpecificGroup() {
+	r := parseRobots(strings.NewReader(testRobots), userAgentToken("Tyche/1.0 (+https://github.com/lsytj0413/tyche)"))
+	p.True(r.allowed("/shtml/dlt/"))
+	p.False(r.allowed("/private/1"))
+	p.True(r.allowed("/private/public/1"))
+}
+
+func (p *robotsTestSuite) TestAgentMatch() {
+	content := `
+User-agent: *
+Disallow: /all
+
+User-agent: ty
+User-agent: tyche-bot
+Disallow: /prefix
+
+User-agent: TYCHE/2.0
+Disallow: /exact
+`
+	testCases := []struct {
+		userAgent string
+		path      string
+		allowed   bool
+	}{
+		// the product token matches case-insensitively, the version is ignored
+		{"Tyche/1.0", "/exact", false},
+		{"Tyche/1.0", "/all", true},
+		{"Tyche/1.0", "/prefix", true},
+		// the agent which is a substring or extension of token doesnot match
+		{"tyche-bot/1.0", "/prefix", false},
+		{"tyche-bot/1.0", "/exact", true},
+		{"tychebot", "/all", false},
+		{"tychebot", "/exact", true},
+	}
+	for _, tc := range testCases {
+		r := parseRobots(strings.NewReader(content), userAgentToken(tc.userAgent))
+		p.Equal(tc.allowed, r.allowed(tc.path), "%s %s", tc.userAgent, tc.path)
+	}
+}
+
+func (p *robotsTestSuite) TestUnreachable() {
+	r := &robotsRules{unreachable: true}
+	p.False(r.allowed("/"))
+}
+
+func (p *robotsTestSuite) TestEmpty() {
+	r := parseRobots(strings.NewReader(""), "tyche")
+	p.True(r.allowed("/anything"))
+}
+
+func TestRobotsTestSuite(t *testing.T) {
+	p := &robotsTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook post the events to the registered webhooks, signed with HMAC-SHA256.
+//
+// The signature covers the timestamp and the body, so a captured delivery can not be replayed
+// later: the receiver should Verify the signature with a tolerance (e.g. DefaultTolerance) of
+// the timestamp, and may skip the duplicated HeaderDelivery as an event is delivered at least once.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/lsytj0413/tyche/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tyche_webhook_deliveries_total",
+		Help: "Total webhook delivery attempts by event type and result: succeeded or failed.",
+	}, []string{"type", "result"})
+)
+
+const (
+	// HeaderEvent is the event type
+	HeaderEvent = "X-Tyche-Event"
+	// HeaderDelivery is the event id, it is the same for retries
+	HeaderDelivery = "X-Tyche-Delivery"
+	// HeaderTimestamp is the unix seconds when the attempt is sent
+	HeaderTimestamp = "X-Tyche-Timestamp"
+	// HeaderSignature is "sha256=" + hex HMAC-SHA256 of timestamp + "." + body with webhook secret
+	HeaderSignature = "X-Tyche-Signature"
+
+	// DefaultTolerance is the max difference of timestamp and the receive time suggested for receivers
+	DefaultTolerance = 5 * time.Minute
+
+	// TypePing is the event sent by Test
+	TypePing = event.Type("Ping")
+
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = 30 * time.Second
+
+	// max bytes of response body read, it is discarded
+	maxResponseSize = 64 << 10
+)
+
+// Types is the event types which can be subscribed by webhook
+var Types = []event.Type{
+	event.TypeDrawPublished,
+	event.TypePrizeTableUpdated,
+	event.TypeTicketWon,
+	event.TypeSyncFailed,
+}
+
+// Sign return the signature of timestamp and body with secret
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify return whether signature is the signature of timestamp and body with secret,
+// and the timestamp is within tolerance of now
+func Verify(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Subscribed return whether the webhook subscribe typ, all Types are subscribed if w.Events is empty
+func Subscribed(w *store.Webhook, typ event.Type) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, v := range w.Events {
+		if event.Type(v) == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher post the events to the webhooks in store, each attempt is recorded in the delivery log
+type Dispatcher struct {
+	st     *store.Store
+	client *http.Client
+
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// New will construct a Dispatcher instance
+func New(st *store.Store) *Dispatcher {
+	return &Dispatcher{
+		st: st,
+		client: &http.Client{
+			Timeout: defaultTimeout,
+		},
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+	}
+}
+
+// Handle is the event.Handler which post e to the subscribed webhooks concurrently with retries.
+// The error is returned if any webhook failed, so e is delivered again later; the webhooks
+// which already succeeded at e are skipped.
+func (d *Dispatcher) Handle(ctx context.Context, e *event.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make([]string, 0)
+	for _, w := range d.st.Webhooks() {
+		if !Subscribed(&w, e.Type) || d.succeeded(w.ID, e.ID) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(w store.Webhook) {
+			defer wg.Done()
+			if !d.deliver(ctx, &w, e, body) {
+				mu.Lock()
+				failed = append(failed, w.ID)
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("deliver to webhooks %v failed", failed)
+	}
+	return nil
+}
+
+// Test will post a Ping event to the webhook once, and return the delivery
+func (d *Dispatcher) Test(ctx context.Context, w *store.Webhook) (*store.WebhookDelivery, error) {
+	e, err := event.NewEvent(TypePing, map[string]string{"webhook": w.ID})
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return d.attempt(ctx, w, e, body, 1), nil
+}
+
+func (d *Dispatcher) succeeded(webhook string, id string) bool {
+	for _, v := range d.st.WebhookDeliveries(webhook) {
+		if v.Event == id && v.Succeeded {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver will post e to w with backoff until it succeed, attempts exhausted or ctx done,
+// return whether it succeeded
+func (d *Dispatcher) deliver(ctx context.Context, w *store.Webhook, e *event.Event, body []byte) bool {
+	backoff := d.backoff
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if attempt > 1 {
+			t := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return false
+			case <-t.C:
+			}
+			if backoff *= 2; backoff > d.maxBackoff {
+				backoff = d.maxBackoff
+			}
+		}
+
+		v := d.attempt(ctx, w, e, body, attempt)
+		if v.Succeeded {
+			return true
+		}
+		if v.StatusCode != 0 && !retryable(v.StatusCode) {
+			break
+		}
+	}
+	logger.Errorf("Deliver event[%s] %s to webhook[%s] failed", e.ID, e.Type, w.ID)
+	return false
+}
+
+// retryable return whether the delivery should be retried at status code
+func retryable(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// attempt will post e to w once, and record the delivery
+func (d *Dispatcher) attempt(ctx context.Context, w *store.Webhook, e *event.Event, body []byte, attempt int) *store.WebhookDelivery {
+	v := &store.WebhookDelivery{
+		Webhook: w.ID,
+		Event:   e.ID,
+		Type:    string(e.Type),
+		Attempt: attempt,
+		Time:    time.Now(),
+	}
+
+	err := d.post(ctx, w, e, body, v)
+	v.Duration = time.Since(v.Time)
+	if err != nil {
+		v.Error = err.Error()
+		deliveries.WithLabelValues(string(e.Type), "failed").Inc()
+	} else {
+		v.Succeeded = true
+		deliveries.WithLabelValues(string(e.Type), "succeeded").Inc()
+	}
+
+	if err := d.st.AddWebhookDelivery(v); err != nil {
+		logger.Errorf("Save delivery of event[%s] to webhook[%s] failed: %s", e.ID, w.ID, err)
+	}
+	return v
+}
+
+func (d *Dispatcher) post(ctx context.Context, w *store.Webhook, e *event.Event, body []byte, v *store.WebhookDelivery) error {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "tyche-webhook/"+version.Version)
+	request.Header.Set(HeaderEvent, string(e.Type))
+	request.Header.Set(HeaderDelivery, e.ID)
+	// each attempt is signed with its own timestamp
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+
+	resp, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	v.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
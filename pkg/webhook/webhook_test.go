@@ -0,0 +1,186 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsytj0413/tyche/pkg/event"
+	"github.com/lsytj0413/tyche/pkg/store"
+	"github.com/stretchr/testify/suite"
+)
+
+type webhookTestSuite struct {
+	suite.Suite
+
+	st *store.Store
+	d  *Dispatcher
+
+	mu       sync.Mutex
+	statuses []int
+	received []*event.Event
+	srv      *httptest.Server
+}
+
+func (p *webhookTestSuite) SetupTest() {
+	var err error
+	p.st, err = store.Open("")
+	p.NoError(err)
+	p.d = New(p.st)
+	p.d.backoff = time.Millisecond
+	p.d.maxBackoff = 2 * time.Millisecond
+
+	p.statuses = nil
+	p.received = nil
+	p.srv = httptest.NewServer(http.HandlerFunc(p.handle))
+}
+
+func (p *webhookTestSuite) TearDownTest() {
+	p.srv.Close()
+}
+
+// handle will verify the request and reply the statuses in order, then 200
+func (p *webhookTestSuite) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	p.NoError(err)
+	if !Verify("secret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature), DefaultTolerance) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	e := &event.Event{}
+	p.NoError(json.Unmarshal(body, e))
+	p.Equal(string(e.Type), r.Header.Get(HeaderEvent))
+	p.Equal(e.ID, r.Header.Get(HeaderDelivery))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received = append(p.received, e)
+	if len(p.statuses) > 0 {
+		w.WriteHeader(p.statuses[0])
+		p.statuses = p.statuses[1:]
+	}
+}
+
+func (p *webhookTestSuite) addWebhook(id string, secret string, events ...string) {
+	added, err := p.st.SaveWebhook(&store.Webhook{ID: id, URL: p.srv.URL, Secret: secret, Events: events})
+	p.NoError(err)
+	p.True(added)
+}
+
+func (p *webhookTestSuite) newEvent(typ event.Type) *event.Event {
+	e, err := event.NewEvent(typ, &event.TicketWon{OpenID: "u1", Term: 18077})
+	p.NoError(err)
+	return e
+}
+
+func (p *webhookTestSuite) TestSign() {
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := Sign("secret", now, body)
+	p.Equal("sha256=", signature[:7])
+	p.True(Verify("secret", now, body, signature, DefaultTolerance))
+	p.False(Verify("other", now, body, signature, DefaultTolerance))
+	p.False(Verify("secret", now, []byte(`{"id":"2"}`), signature, DefaultTolerance))
+	p.False(Verify("secret", "", body, Sign("secret", "", body), DefaultTolerance))
+
+	// the replayed delivery is rejected out of tolerance, and the timestamp can not be changed
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	signature = Sign("secret", old, body)
+	p.False(Verify("secret", old, body, signature, DefaultTolerance))
+	p.True(Verify("secret", old, body, signature, 2*time.Hour))
+	p.False(Verify("secret", now, body, signature, DefaultTolerance))
+}
+
+func (p *webhookTestSuite) TestHandle() {
+	p.addWebhook("won", "secret", string(event.TypeTicketWon))
+	p.addWebhook("draws", "secret", string(event.TypeDrawPublished))
+	p.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+
+	e := p.newEvent(event.TypeTicketWon)
+	p.NoError(p.d.Handle(context.Background(), e))
+	p.Len(p.received, 3)
+	p.Empty(p.st.WebhookDeliveries("draws"))
+
+	deliveries := p.st.WebhookDeliveries("won")
+	p.Len(deliveries, 3)
+	p.True(deliveries[0].Succeeded)
+	p.Equal(3, deliveries[0].Attempt)
+	p.Equal(http.StatusServiceUnavailable, deliveries[2].StatusCode)
+	p.NotEmpty(deliveries[2].Error)
+
+	// the succeeded webhook is skipped when the event is delivered again
+	p.NoError(p.d.Handle(context.Background(), e))
+	p.Len(p.received, 3)
+}
+
+func (p *webhookTestSuite) TestHandleFailed() {
+	p.addWebhook("bad-request", "secret")
+	p.addWebhook("wrong-secret", "wrong")
+	p.statuses = []int{http.StatusBadRequest}
+	p.d.maxAttempts = 2
+
+	e := p.newEvent(event.TypeSyncFailed)
+	p.Error(p.d.Handle(context.Background(), e))
+
+	// 4xx is not retried
+	p.Len(p.st.WebhookDeliveries("bad-request"), 1)
+	deliveries := p.st.WebhookDeliveries("wrong-secret")
+	p.Len(deliveries, 1)
+	p.False(deliveries[0].Succeeded)
+	p.Equal(http.StatusUnauthorized, deliveries[0].StatusCode)
+
+	// the event is delivered again by bus, only the failed webhook is posted
+	p.addWebhook("ok", "secret")
+	_, err := p.st.DeleteWebhook("wrong-secret")
+	p.NoError(err)
+	p.NoError(p.d.Handle(context.Background(), e))
+	p.Len(p.st.WebhookDeliveries("bad-request"), 2)
+	p.Len(p.st.WebhookDeliveries("ok"), 1)
+	p.NoError(p.d.Handle(context.Background(), e))
+	p.Len(p.st.WebhookDeliveries("bad-request"), 2)
+	p.Len(p.st.WebhookDeliveries("ok"), 1)
+}
+
+func (p *webhookTestSuite) TestTest() {
+	p.addWebhook("h", "secret", string(event.TypeTicketWon))
+	w, _ := p.st.Webhook("h")
+
+	v, err := p.d.Test(context.Background(), &w)
+	p.NoError(err)
+	p.True(v.Succeeded)
+	p.Equal(string(TypePing), v.Type)
+	p.Len(p.received, 1)
+
+	p.srv.Close()
+	v, err = p.d.Test(context.Background(), &w)
+	p.NoError(err)
+	p.False(v.Succeeded)
+	p.NotEmpty(v.Error)
+	p.Len(p.st.WebhookDeliveries("h"), 2)
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	p := &webhookTestSuite{}
+	suite.Run(t, p)
+}
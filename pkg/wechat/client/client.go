@@ -0,0 +1,258 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is the wechat official account active api client
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsytj0413/ena/logger"
+)
+
+const (
+	defaultBaseURL = "https://api.weixin.qq.com"
+
+	// refresh the token before it expires, wechat keeps the old token valid for 5 minutes after refresh
+	refreshAhead = 5 * time.Minute
+	// token which expires within this window should be refreshed synchronously
+	expireAhead = 30 * time.Second
+)
+
+// errcode of invalid or expired access_token
+const (
+	ErrCodeInvalidCredential  = 40001
+	ErrCodeInvalidAccessToken = 40014
+	ErrCodeAccessTokenExpired = 42001
+)
+
+// Error is the errcode/errmsg returned by wechat api
+type Error struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("wechat: errcode=%d, errmsg=%s", e.ErrCode, e.ErrMsg)
+}
+
+func (e *Error) isTokenError() bool {
+	switch e.ErrCode {
+	case ErrCodeInvalidCredential, ErrCodeInvalidAccessToken, ErrCodeAccessTokenExpired:
+		return true
+	}
+	return false
+}
+
+type response interface {
+	apiError() *Error
+	resetError()
+}
+
+func (e *Error) apiError() *Error {
+	if e.ErrCode == 0 {
+		return nil
+	}
+	return e
+}
+
+// resetError will clear the error decoded from the previous response, the success
+// response of some apis has no errcode
+func (e *Error) resetError() {
+	*e = Error{}
+}
+
+// tokenCall is a in-flight access_token request
+type tokenCall struct {
+	wg    sync.WaitGroup
+	token string
+	err   error
+}
+
+// Client is wechat api client, it caches access_token and refresh it before expired
+type Client struct {
+	appID     string
+	appSecret string
+	baseURL   string
+	hc        *http.Client
+	now       func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	inflight  *tokenCall
+}
+
+// New will construct a Client instance
+func New(appID, appSecret string) *Client {
+	return &Client{
+		appID:     appID,
+		appSecret: appSecret,
+		baseURL:   defaultBaseURL,
+		hc:        &http.Client{Timeout: 10 * time.Second},
+		now:       time.Now,
+	}
+}
+
+// AccessToken return the cached access_token, it will be refreshed when expired
+func (c *Client) AccessToken() (string, error) {
+	c.mu.Lock()
+	now := c.now()
+	if c.token != "" && now.Before(c.expiresAt.Add(-expireAhead)) {
+		token := c.token
+		if now.After(c.expiresAt.Add(-refreshAhead)) && c.inflight == nil {
+			// proactive refresh in background, the current token is still usable
+			c.startRefresh()
+		}
+		c.mu.Unlock()
+		return token, nil
+	}
+
+	call := c.inflight
+	if call == nil {
+		call = c.startRefresh()
+	}
+	c.mu.Unlock()
+
+	call.wg.Wait()
+	return call.token, call.err
+}
+
+// InvalidateToken will drop the cached access_token if it still equal to token
+func (c *Client) InvalidateToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = ""
+		c.expiresAt = time.Time{}
+	}
+}
+
+// startRefresh must be called with c.mu held
+func (c *Client) startRefresh() *tokenCall {
+	call := &tokenCall{}
+	call.wg.Add(1)
+	c.inflight = call
+
+	go func() {
+		token, expiresIn, err := c.fetchToken()
+		if err != nil {
+			logger.Errorf("Refresh wechat access_token failed: %s", err)
+		}
+
+		c.mu.Lock()
+		if err == nil {
+			c.token = token
+			c.expiresAt = c.now().Add(expiresIn)
+		}
+		c.inflight = nil
+		c.mu.Unlock()
+
+		call.token, call.err = token, err
+		call.wg.Done()
+	}()
+
+	return call
+}
+
+type tokenResponse struct {
+	Error
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (c *Client) fetchToken() (string, time.Duration, error) {
+	query := url.Values{}
+	query.Set("grant_type", "client_credential")
+	query.Set("appid", c.appID)
+	query.Set("secret", c.appSecret)
+
+	resp := &tokenResponse{}
+	if err := c.doRequest(http.MethodGet, c.baseURL+"/cgi-bin/token?"+query.Encode(), nil, resp); err != nil {
+		return "", 0, err
+	}
+	if resp.AccessToken == "" {
+		return "", 0, fmt.Errorf("wechat: empty access_token in response")
+	}
+
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+// call will invoke the api at path with access_token, the token will be refreshed and retry once when it is invalid
+func (c *Client) call(method, path string, req interface{}, resp response) error {
+	for i := 0; ; i++ {
+		token, err := c.AccessToken()
+		if err != nil {
+			return err
+		}
+
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		err = c.doRequest(method, c.baseURL+path+sep+"access_token="+url.QueryEscape(token), req, resp)
+		if apiErr, ok := err.(*Error); ok && apiErr.isTokenError() && i == 0 {
+			logger.Infof("Wechat access_token invalid, errcode=%d, refreshing", apiErr.ErrCode)
+			c.InvalidateToken(token)
+			continue
+		}
+		return err
+	}
+}
+
+func (c *Client) doRequest(method, rawurl string, req interface{}, resp response) error {
+	var data []byte
+	if req != nil {
+		var err error
+		data, err = json.Marshal(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(method, rawurl, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	r, err := c.hc.Do(request)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("wechat: unexpected http status %d", r.StatusCode)
+	}
+	resp.resetError()
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("wechat: decode response failed: %v", err)
+	}
+	if apiErr := resp.apiError(); apiErr != nil {
+		return apiErr
+	}
+
+	return nil
+}
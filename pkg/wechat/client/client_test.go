@@ -0,0 +1,239 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeWechat is a fake of wechat api server
+type fakeWechat struct {
+	mu        sync.Mutex
+	token     string
+	expiresIn int64
+	delay     time.Duration
+
+	tokenCalls int32
+	requests   map[string][]byte
+	menu       []byte
+}
+
+func (f *fakeWechat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/cgi-bin/token" {
+		n := atomic.AddInt32(&f.tokenCalls, 1)
+		time.Sleep(f.delay)
+		if r.URL.Query().Get("appid") != "appid" || r.URL.Query().Get("secret") != "secret" {
+			fmt.Fprint(w, `{"errcode":40013,"errmsg":"invalid appid"}`)
+			return
+		}
+
+		f.mu.Lock()
+		f.token = fmt.Sprintf("token-%d", n)
+		f.mu.Unlock()
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d}`, n, f.expiresIn)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Query().Get("access_token") != f.token {
+		fmt.Fprint(w, `{"errcode":42001,"errmsg":"access_token expired"}`)
+		return
+	}
+
+	var body json.RawMessage
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+		f.requests[r.URL.Path] = body
+	}
+	switch r.URL.Path {
+	case "/cgi-bin/menu/create":
+		f.menu = body
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	case "/cgi-bin/menu/get":
+		fmt.Fprintf(w, `{"menu":%s}`, f.menu)
+	case "/cgi-bin/message/custom/send":
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	case "/cgi-bin/message/template/send":
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":200228332}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type clientTestSuite struct {
+	suite.Suite
+
+	f  *fakeWechat
+	ts *httptest.Server
+	c  *Client
+}
+
+func (p *clientTestSuite) SetupTest() {
+	p.f = &fakeWechat{
+		expiresIn: 7200,
+		requests:  make(map[string][]byte),
+	}
+	p.ts = httptest.NewServer(p.f)
+	p.c = New("appid", "secret")
+	p.c.baseURL = p.ts.URL
+}
+
+func (p *clientTestSuite) TearDownTest() {
+	p.ts.Close()
+}
+
+func (p *clientTestSuite) TestAccessTokenCached() {
+	token, err := p.c.AccessToken()
+	p.NoError(err)
+	p.Equal("token-1", token)
+
+	token, err = p.c.AccessToken()
+	p.NoError(err)
+	p.Equal("token-1", token)
+	p.Equal(int32(1), atomic.LoadInt32(&p.f.tokenCalls))
+}
+
+func (p *clientTestSuite) TestAccessTokenSingleFlight() {
+	p.f.delay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = p.c.AccessToken()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, token := range tokens {
+		p.Equal("token-1", token)
+	}
+	p.Equal(int32(1), atomic.LoadInt32(&p.f.tokenCalls))
+}
+
+func (p *clientTestSuite) TestAccessTokenProactiveRefresh() {
+	now := time.Now()
+	p.c.now = func() time.Time { return now }
+
+	token, err := p.c.AccessToken()
+	p.NoError(err)
+	p.Equal("token-1", token)
+
+	// within refresh window, the old token is returned and a refresh is started
+	now = now.Add(7200*time.Second - refreshAhead + time.Second)
+	token, err = p.c.AccessToken()
+	p.NoError(err)
+	p.Equal("token-1", token)
+
+	p.Eventually(func() bool {
+		token, _ := p.c.AccessToken()
+		return token == "token-2"
+	}, time.Second, 10*time.Millisecond)
+	p.Equal(int32(2), atomic.LoadInt32(&p.f.tokenCalls))
+}
+
+func (p *clientTestSuite) TestAccessTokenExpired() {
+	now := time.Now()
+	p.c.now = func() time.Time { return now }
+
+	_, err := p.c.AccessToken()
+	p.NoError(err)
+
+	now = now.Add(7200 * time.Second)
+	token, err := p.c.AccessToken()
+	p.NoError(err)
+	p.Equal("token-2", token)
+}
+
+func (p *clientTestSuite) TestAccessTokenError() {
+	p.c.appSecret = "invalid"
+
+	_, err := p.c.AccessToken()
+	p.Equal(&Error{ErrCode: 40013, ErrMsg: "invalid appid"}, err)
+}
+
+func (p *clientTestSuite) TestInvalidTokenRetry() {
+	_, err := p.c.AccessToken()
+	p.NoError(err)
+
+	// token revoked at server side, eg: refreshed by other service
+	p.f.mu.Lock()
+	p.f.token = "other"
+	p.f.mu.Unlock()
+
+	p.NoError(p.c.SendCustomMessage(NewTextMessage("openid", "hello")))
+	p.Equal(int32(2), atomic.LoadInt32(&p.f.tokenCalls))
+	p.JSONEq(`{"touser":"openid","msgtype":"text","text":{"content":"hello"}}`, string(p.f.requests["/cgi-bin/message/custom/send"]))
+}
+
+func (p *clientTestSuite) TestInvalidTokenRetryWithoutErrcode() {
+	p.NoError(p.c.CreateMenu(&Menu{Buttons: []Button{{Type: "click", Name: "最新开奖", Key: "LATEST"}}}))
+
+	p.f.mu.Lock()
+	p.f.token = "other"
+	p.f.mu.Unlock()
+
+	// the success response of menu/get has no errcode
+	v, err := p.c.GetMenu()
+	p.NoError(err)
+	p.Len(v.Buttons, 1)
+	p.Equal(int32(2), atomic.LoadInt32(&p.f.tokenCalls))
+}
+
+func (p *clientTestSuite) TestMenuOk() {
+	menu := &Menu{
+		Buttons: []Button{
+			{Type: "click", Name: "最新开奖", Key: "LATEST"},
+			{Name: "更多", SubButtons: []Button{
+				{Type: "view", Name: "主页", URL: "https://www.soren.vip"},
+			}},
+		},
+	}
+	p.NoError(p.c.CreateMenu(menu))
+
+	v, err := p.c.GetMenu()
+	p.NoError(err)
+	p.Equal(menu, v)
+}
+
+func (p *clientTestSuite) TestTemplateMessageOk() {
+	msgID, err := p.c.SendTemplateMessage(&TemplateMessage{
+		ToUser:     "openid",
+		TemplateID: "template",
+		Data: map[string]TemplateData{
+			"first": {Value: "hello", Color: "#173177"},
+		},
+	})
+	p.NoError(err)
+	p.Equal(int64(200228332), msgID)
+	p.JSONEq(`{"touser":"openid","template_id":"template","data":{"first":{"value":"hello","color":"#173177"}}}`, string(p.f.requests["/cgi-bin/message/template/send"]))
+}
+
+func TestClientTestSuite(t *testing.T) {
+	p := &clientTestSuite{}
+	suite.Run(t, p)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net/http"
+)
+
+// Button is custom menu(自定义菜单) button
+type Button struct {
+	Type       string   `json:"type,omitempty"`
+	Name       string   `json:"name"`
+	Key        string   `json:"key,omitempty"`
+	URL        string   `json:"url,omitempty"`
+	MediaID    string   `json:"media_id,omitempty"`
+	AppID      string   `json:"appid,omitempty"`
+	PagePath   string   `json:"pagepath,omitempty"`
+	SubButtons []Button `json:"sub_button,omitempty"`
+}
+
+// Menu is custom menu definition
+type Menu struct {
+	Buttons []Button `json:"button"`
+	MenuID  int64    `json:"menuid,omitempty"`
+}
+
+type menuResponse struct {
+	Error
+	Menu Menu `json:"menu"`
+}
+
+// CreateMenu will create the custom menu
+func (c *Client) CreateMenu(menu *Menu) error {
+	return c.call(http.MethodPost, "/cgi-bin/menu/create", menu, &Error{})
+}
+
+// GetMenu will query the current custom menu
+func (c *Client) GetMenu() (*Menu, error) {
+	resp := &menuResponse{}
+	if err := c.call(http.MethodGet, "/cgi-bin/menu/get", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Menu, nil
+}
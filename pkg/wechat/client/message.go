@@ -0,0 +1,45 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net/http"
+)
+
+// Text is text content of custom message
+type Text struct {
+	Content string `json:"content"`
+}
+
+// CustomMessage is customer service message(客服消息)
+type CustomMessage struct {
+	ToUser  string `json:"touser"`
+	MsgType string `json:"msgtype"`
+	Text    *Text  `json:"text,omitempty"`
+}
+
+// NewTextMessage will construct a text CustomMessage
+func NewTextMessage(toUser, content string) *CustomMessage {
+	return &CustomMessage{
+		ToUser:  toUser,
+		MsgType: "text",
+		Text:    &Text{Content: content},
+	}
+}
+
+// SendCustomMessage will send customer service message to user
+func (c *Client) SendCustomMessage(msg *CustomMessage) error {
+	return c.call(http.MethodPost, "/cgi-bin/message/custom/send", msg, &Error{})
+}
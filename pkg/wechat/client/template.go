@@ -0,0 +1,48 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net/http"
+)
+
+// TemplateData is a value of template message keyword
+type TemplateData struct {
+	Value string `json:"value"`
+	Color string `json:"color,omitempty"`
+}
+
+// TemplateMessage is template message(模板消息)
+type TemplateMessage struct {
+	ToUser     string                  `json:"touser"`
+	TemplateID string                  `json:"template_id"`
+	URL        string                  `json:"url,omitempty"`
+	Data       map[string]TemplateData `json:"data"`
+}
+
+type templateResponse struct {
+	Error
+	MsgID int64 `json:"msgid"`
+}
+
+// SendTemplateMessage will send template message to user, return the msgid
+func (c *Client) SendTemplateMessage(msg *TemplateMessage) (int64, error) {
+	resp := &templateResponse{}
+	if err := c.call(http.MethodPost, "/cgi-bin/message/template/send", msg, resp); err != nil {
+		return 0, err
+	}
+
+	return resp.MsgID, nil
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	// EncryptTypeAES is the encrypt_type query value when message is encrypted(安全模式/兼容模式)
+	EncryptTypeAES = "aes"
+
+	encodingAESKeyLength = 43
+	// wechat pads the plaintext to multiple of 32 bytes, not the aes block size
+	pkcs7BlockSize = 32
+	randomLength   = 16
+)
+
+var (
+	// ErrInvalidSignature is returned when msg_signature doesnot match the message
+	ErrInvalidSignature = errors.New("wechat: invalid message signature")
+	// ErrInvalidAppID is returned when the appid in decrypted message doesnot match
+	ErrInvalidAppID = errors.New("wechat: invalid message appid")
+	// ErrInvalidPadding is returned when the decrypted message has broken pkcs#7 padding
+	ErrInvalidPadding = errors.New("wechat: invalid message padding")
+)
+
+// Signature return the wechat sha1 signature of sorted params
+func Signature(params ...string) string {
+	sorted := make([]string, len(params))
+	copy(sorted, params)
+	sort.Strings(sorted)
+
+	h := sha1.New()
+	for _, s := range sorted {
+		io.WriteString(h, s)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// EncryptedMessage is the message envelope posted by wechat in aes mode
+type EncryptedMessage struct {
+	XMLName    xml.Name `xml:"xml"`
+	ToUserName string   `xml:"ToUserName"`
+	Encrypt    string   `xml:"Encrypt"`
+}
+
+// EncryptedReply is the reply envelope responsed to wechat in aes mode
+type EncryptedReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      CDATA    `xml:"Encrypt"`
+	MsgSignature CDATA    `xml:"MsgSignature"`
+	TimeStamp    string   `xml:"TimeStamp"`
+	Nonce        CDATA    `xml:"Nonce"`
+}
+
+// CDATA is string marshaled as xml CDATA section
+type CDATA struct {
+	Value string `xml:",cdata"`
+}
+
+// MsgCrypter encrypt and decrypt wechat message in secure(安全模式) or compatible(兼容模式) mode
+type MsgCrypter struct {
+	token  string
+	appID  string
+	aesKey []byte
+
+	rand io.Reader
+}
+
+// NewMsgCrypter will construct a MsgCrypter instance
+func NewMsgCrypter(token, encodingAESKey, appID string) (*MsgCrypter, error) {
+	if len(encodingAESKey) != encodingAESKeyLength {
+		return nil, fmt.Errorf("wechat: encoding aes key length[%d] doesnot equal %d", len(encodingAESKey), encodingAESKeyLength)
+	}
+
+	aesKey, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, fmt.Errorf("wechat: invalid encoding aes key: %v", err)
+	}
+
+	return &MsgCrypter{
+		token:  token,
+		appID:  appID,
+		aesKey: aesKey,
+		rand:   rand.Reader,
+	}, nil
+}
+
+// VerifySignature check the msg_signature of encrypt message
+func (c *MsgCrypter) VerifySignature(signature, timestamp, nonce, encrypt string) bool {
+	return signature == Signature(c.token, timestamp, nonce, encrypt)
+}
+
+// Decrypt will decrypt the base64 encrypt content and return the plain message
+func (c *MsgCrypter) Decrypt(encrypt string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypt)
+	if err != nil {
+		return nil, fmt.Errorf("wechat: invalid encrypt content: %v", err)
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("wechat: encrypt content length[%d] is not multiple of block size", len(ciphertext))
+	}
+
+	block, err := aes.NewCipher(c.aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, c.aesKey[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext)
+
+	plaintext, err = pkcs7Unpad(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	// random(16B) + msg_len(4B) + msg + appid
+	if len(plaintext) < randomLength+4 {
+		return nil, fmt.Errorf("wechat: decrypted content length[%d] too short", len(plaintext))
+	}
+	msgLen := int(binary.BigEndian.Uint32(plaintext[randomLength : randomLength+4]))
+	if msgLen > len(plaintext)-randomLength-4 {
+		return nil, fmt.Errorf("wechat: decrypted message length[%d] out of range", msgLen)
+	}
+	msg := plaintext[randomLength+4 : randomLength+4+msgLen]
+	if string(plaintext[randomLength+4+msgLen:]) != c.appID {
+		return nil, ErrInvalidAppID
+	}
+
+	return msg, nil
+}
+
+// Encrypt will encrypt the plain message and return base64 encrypt content
+func (c *MsgCrypter) Encrypt(msg []byte) (string, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, randomLength+4+len(msg)+len(c.appID)+pkcs7BlockSize))
+	if _, err := io.CopyN(buf, c.rand, randomLength); err != nil {
+		return "", fmt.Errorf("wechat: generate random failed: %v", err)
+	}
+	binary.Write(buf, binary.BigEndian, uint32(len(msg)))
+	buf.Write(msg)
+	buf.WriteString(c.appID)
+
+	plaintext := pkcs7Pad(buf.Bytes())
+	block, err := aes.NewCipher(c.aesKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, c.aesKey[:aes.BlockSize]).CryptBlocks(ciphertext, plaintext)
+
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptMessage will verify and decrypt the posted envelope body, return the plain message xml
+func (c *MsgCrypter) DecryptMessage(signature, timestamp, nonce string, body []byte) ([]byte, error) {
+	envelope := EncryptedMessage{}
+	if err := xml.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+
+	encrypt := strings.TrimSpace(envelope.Encrypt)
+	if !c.VerifySignature(signature, timestamp, nonce, encrypt) {
+		return nil, ErrInvalidSignature
+	}
+
+	return c.Decrypt(encrypt)
+}
+
+// EncryptMessage will encrypt and sign the plain reply xml, return the reply envelope xml
+func (c *MsgCrypter) EncryptMessage(msg []byte, timestamp, nonce string) ([]byte, error) {
+	encrypt, err := c.Encrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return xml.Marshal(&EncryptedReply{
+		Encrypt:      CDATA{encrypt},
+		MsgSignature: CDATA{Signature(c.token, timestamp, nonce, encrypt)},
+		TimeStamp:    timestamp,
+		Nonce:        CDATA{nonce},
+	})
+}
+
+func pkcs7Pad(data []byte) []byte {
+	n := pkcs7BlockSize - len(data)%pkcs7BlockSize
+	return append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidPadding
+	}
+
+	n := int(data[len(data)-1])
+	if n < 1 || n > pkcs7BlockSize || n > len(data) {
+		return nil, ErrInvalidPadding
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, ErrInvalidPadding
+		}
+	}
+
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2018 soren yang
+//
+// Licensed under the MIT License
+// you may not use this file except in complicance with the License.
+// You may obtain a copy of the License at
+//
+//     https://opensource.org/licenses/MIT
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// sample parameters from the wechat official message crypto sample code
+const (
+	sampleEncodingAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+	sampleToken          = "spamtest"
+	sampleTimestamp      = "1409304348"
+	sampleNonce          = "xxxxxx"
+	sampleAppID          = "wxb11529c136998cb6"
+	sampleMessage        = "<xml><ToUserName><![CDATA[oia2TjjewbmiOUlr6X-1crbLOvLw]]></ToUserName><FromUserName><![CDATA[gh_7f083739789a]]></FromUserName><CreateTime>1407743423</CreateTime><MsgType><![CDATA[video]]></MsgType><Video><MediaId><![CDATA[eYJ1MbwPRJtOvIEabaxHs7TX2D-HV71s79GUxqdUkjm6Gs2Ed1KF3ulAOA9H1xG0]]></MediaId><Title><![CDATA[testCallBackReplyVideo]]></Title><Description><![CDATA[testCallBackReplyVideo]]></Description></Video></xml>"
+)
+
+// the published decrypt vector of the wechat official message crypto sample code
+const (
+	vectorToken        = "spamtest"
+	vectorAppID        = "wx2c2769f8efd9abc2"
+	vectorTimestamp    = "1409735669"
+	vectorNonce        = "1320562132"
+	vectorMsgSignature = "5d197aaffba7e9b25a30732f161a50dee96bd5fa"
+	vectorEncrypt      = "hyzAe4OzmOMbd6TvGdIOO6uBmdJoD0Fk53REIHvxYtJlE2B655HuD0m8KUePWB3+LrPXo87wzQ1QLvbeUgmBM4x6F8PGHQHFVAFmOD2LdJF9FrXpbUAh0B5GIItb52sn896wVsMSHGuPE328HnRGBcrS7C41IzDWyWNlZkyyXwon8T332jisa+h6tEDYsVticbSnyU8dKOIbgU6ux5VTjg3yt+WGzjlpKn6NPhRjpA912xMezR4kw6KWwMrCVKSVCZciVGCgavjIQ6X8tCOp3yZbGpy0VxpAe+77TszTfRd5RJSVO/HTnifJpXgCSUdUue1v6h0EIBYYI1BD1DlD+C0CR8e6OewpusjZ4uBl9FyJvnhvQl+q5rv1ixrcpCumEPo5MJSgM9ehVsNPfUM669WuMyVWQLCzpu9GhglF2PE="
+	vectorMessage      = "<xml><ToUserName><![CDATA[gh_10f6c3c3ac5a]]></ToUserName>\n" +
+		"<FromUserName><![CDATA[oyORnuP8q7ou2gfYjqLzSIWZf0rs]]></FromUserName>\n" +
+		"<CreateTime>1409735668</CreateTime>\n" +
+		"<MsgType><![CDATA[text]]></MsgType>\n" +
+		"<Content><![CDATA[abcdteT]]></Content>\n" +
+		"<MsgId>6054768590064713728</MsgId>\n" +
+		"</xml>"
+)
+
+type cryptoTestSuite struct {
+	suite.Suite
+
+	c *MsgCrypter
+}
+
+func (p *cryptoTestSuite) SetupTest() {
+	c, err := NewMsgCrypter(sampleToken, sampleEncodingAESKey, sampleAppID)
+	p.NoError(err)
+	c.rand = strings.NewReader(strings.Repeat("0123456789abcdef", 8))
+	p.c = c
+}
+
+func (p *cryptoTestSuite) TestNewMsgCrypterInvalidKey() {
+	_, err := NewMsgCrypter(sampleToken, "short", sampleAppID)
+	p.Error(err)
+
+	_, err = NewMsgCrypter(sampleToken, strings.Repeat("!", encodingAESKeyLength), sampleAppID)
+	p.Error(err)
+}
+
+func (p *cryptoTestSuite) TestSignatureOk() {
+	p.Equal(Signature("b", "c", "a"), Signature("a", "b", "c"))
+	// sha1("abc")
+	p.Equal("a9993e364706816aba3e25717850c26c9cd0d89d", Signature("b", "c", "a"))
+}
+
+func (p *cryptoTestSuite) TestEncryptLayoutOk() {
+	encrypt, err := p.c.Encrypt([]byte(sampleMessage))
+	p.NoError(err)
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypt)
+	p.NoError(err)
+	p.Equal(0, len(ciphertext)%pkcs7BlockSize)
+
+	key, _ := base64.StdEncoding.DecodeString(sampleEncodingAESKey + "=")
+	block, _ := aes.NewCipher(key)
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, key[:16]).CryptBlocks(plaintext, ciphertext)
+
+	p.Equal("0123456789abcdef", string(plaintext[:16]))
+	p.Equal(uint32(len(sampleMessage)), binary.BigEndian.Uint32(plaintext[16:20]))
+	p.Equal(sampleMessage, string(plaintext[20:20+len(sampleMessage)]))
+	p.True(bytes.HasPrefix(plaintext[20+len(sampleMessage):], []byte(sampleAppID)))
+}
+
+func (p *cryptoTestSuite) TestEncryptDecryptOk() {
+	encrypt, err := p.c.Encrypt([]byte(sampleMessage))
+	p.NoError(err)
+
+	msg, err := p.c.Decrypt(encrypt)
+	p.NoError(err)
+	p.Equal(sampleMessage, string(msg))
+}
+
+func (p *cryptoTestSuite) TestDecryptInvalidAppID() {
+	other, err := NewMsgCrypter(sampleToken, sampleEncodingAESKey, "wx0000000000000000")
+	p.NoError(err)
+	encrypt, err := other.Encrypt([]byte(sampleMessage))
+	p.NoError(err)
+
+	_, err = p.c.Decrypt(encrypt)
+	p.Equal(ErrInvalidAppID, err)
+}
+
+func (p *cryptoTestSuite) TestDecryptInvalidContent() {
+	_, err := p.c.Decrypt("not base64!")
+	p.Error(err)
+
+	_, err = p.c.Decrypt(base64.StdEncoding.EncodeToString([]byte("short")))
+	p.Error(err)
+}
+
+func (p *cryptoTestSuite) TestMessageRoundTripOk() {
+	reply, err := p.c.EncryptMessage([]byte(sampleMessage), sampleTimestamp, sampleNonce)
+	p.NoError(err)
+
+	envelope := EncryptedReply{}
+	p.NoError(xml.Unmarshal(reply, &envelope))
+	p.Equal(sampleTimestamp, envelope.TimeStamp)
+	p.Equal(sampleNonce, envelope.Nonce.Value)
+	p.Equal(Signature(sampleToken, sampleTimestamp, sampleNonce, envelope.Encrypt.Value), envelope.MsgSignature.Value)
+
+	body := fmt.Sprintf("<xml><ToUserName><![CDATA[gh_7f083739789a]]></ToUserName><Encrypt><![CDATA[%s]]></Encrypt></xml>", envelope.Encrypt.Value)
+	msg, err := p.c.DecryptMessage(envelope.MsgSignature.Value, sampleTimestamp, sampleNonce, []byte(body))
+	p.NoError(err)
+	p.Equal(sampleMessage, string(msg))
+}
+
+func (p *cryptoTestSuite) TestDecryptMessageVector() {
+	c, err := NewMsgCrypter(vectorToken, sampleEncodingAESKey, vectorAppID)
+	p.NoError(err)
+	p.Equal(vectorMsgSignature, Signature(vectorToken, vectorTimestamp, vectorNonce, vectorEncrypt))
+	p.True(c.VerifySignature(vectorMsgSignature, vectorTimestamp, vectorNonce, vectorEncrypt))
+
+	body := fmt.Sprintf("<xml><ToUserName><![CDATA[gh_10f6c3c3ac5a]]></ToUserName><Encrypt><![CDATA[%s]]></Encrypt></xml>", vectorEncrypt)
+	msg, err := c.DecryptMessage(vectorMsgSignature, vectorTimestamp, vectorNonce, []byte(body))
+	p.NoError(err)
+	p.Equal(vectorMessage, string(msg))
+
+	_, err = c.DecryptMessage(vectorMsgSignature, vectorTimestamp, "1320562133", []byte(body))
+	p.Equal(ErrInvalidSignature, err)
+
+	// the same ciphertext is rejected for the other appid
+	_, err = p.c.DecryptMessage(Signature(sampleToken, vectorTimestamp, vectorNonce, vectorEncrypt), vectorTimestamp, vectorNonce, []byte(body))
+	p.Equal(ErrInvalidAppID, err)
+}
+
+func (p *cryptoTestSuite) TestDecryptMessageInvalidSignature() {
+	encrypt, err := p.c.Encrypt([]byte(sampleMessage))
+	p.NoError(err)
+
+	body := fmt.Sprintf("<xml><Encrypt><![CDATA[%s]]></Encrypt></xml>", encrypt)
+	_, err = p.c.DecryptMessage("0000", sampleTimestamp, sampleNonce, []byte(body))
+	p.Equal(ErrInvalidSignature, err)
+}
+
+func (p *cryptoTestSuite) TestPkcs7Ok() {
+	for i := 0; i <= pkcs7BlockSize*2; i++ {
+		data := bytes.Repeat([]byte{'a'}, i)
+		padded := pkcs7Pad(append([]byte{}, data...))
+		p.Equal(0, len(padded)%pkcs7BlockSize)
+		p.True(len(padded) > len(data))
+
+		unpadded, err := pkcs7Unpad(padded)
+		p.NoError(err)
+		p.Equal(data, unpadded)
+	}
+}
+
+func (p *cryptoTestSuite) TestPkcs7UnpadInvalid() {
+	_, err := pkcs7Unpad(nil)
+	p.Equal(ErrInvalidPadding, err)
+
+	_, err = pkcs7Unpad([]byte{'a', 0})
+	p.Equal(ErrInvalidPadding, err)
+
+	_, err = pkcs7Unpad([]byte{'a', 33})
+	p.Equal(ErrInvalidPadding, err)
+
+	_, err = pkcs7Unpad([]byte{'a', 1, 2})
+	p.Equal(ErrInvalidPadding, err)
+}
+
+func TestCryptoTestSuite(t *testing.T) {
+	p := &cryptoTestSuite{}
+	suite.Run(t, p)
+}